@@ -83,6 +83,8 @@ func ServeFirebase(keypath string) error {
 			_ = broadcastDelete(ctx, msg, fb)
 		case wasabee.FbccDeleteOp:
 			_ = deleteOp(ctx, msg, fb)
+		case wasabee.FbccAgentStatusChange:
+			_ = agentStatusChange(ctx, msg, fb)
 		default:
 			wasabee.Log.Warnw("unknown command", "subsystem", "Firebase", "command", fb.Cmd)
 		}
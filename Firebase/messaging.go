@@ -120,6 +120,32 @@ func markerStatusChange(ctx context.Context, c *messaging.Client, fb wasabee.Fir
 	return nil
 }
 
+func agentStatusChange(ctx context.Context, c *messaging.Client, fb wasabee.FirebaseCmd) error {
+	if fb.TeamID == "" {
+		err := fmt.Errorf("only send status changes to teams")
+		wasabee.Log.Error(err)
+		return err
+	}
+
+	data := map[string]string{
+		"opID": string(fb.OpID),
+		"gid":  string(fb.Gid),
+		"msg":  fb.Msg,
+		"cmd":  fb.Cmd.String(),
+	}
+	msg := messaging.Message{
+		Topic: string(fb.TeamID),
+		Data:  data,
+	}
+
+	_, err := c.Send(ctx, &msg)
+	if err != nil {
+		wasabee.Log.Error(err)
+		return err
+	}
+	return nil
+}
+
 func markerAssignmentChange(ctx context.Context, c *messaging.Client, fb wasabee.FirebaseCmd) error {
 	if fb.Gid == "" {
 		return nil
@@ -83,6 +83,61 @@ func assignmentKeyboard(gid wasabee.GoogleID) tgbotapi.InlineKeyboardMarkup {
 	return tmp
 }
 
+// joinRequestKeyboard lists the join requests waiting on the agent, with approve and deny buttons
+func joinRequestKeyboard(gid wasabee.GoogleID) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	requests, err := gid.ManagedJoinRequests()
+	if err != nil {
+		wasabee.Log.Error(err)
+	}
+	for i, r := range requests {
+		if i > 8 { // too many rows and the screen fills up
+			break
+		}
+		status := "unverified"
+		if r.Agent.Blacklisted {
+			status = "blacklisted"
+		} else if r.Agent.Verified || r.Agent.RocksVerified {
+			status = "verified"
+		}
+		title := fmt.Sprintf("Approve %s (%s) to %s", r.Agent.Name, status, r.TeamName)
+		var row []tgbotapi.InlineKeyboardButton
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(title, "join/approve/"+r.ID))
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("deny", "join/deny/"+r.ID))
+		rows = append(rows, row)
+	}
+
+	tmp := tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: rows,
+	}
+	return tmp
+}
+
+// arrivalKeyboard lists the tasks the agent has arrived at, with a button to complete each
+func arrivalKeyboard(gid wasabee.GoogleID) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	arrivals, err := gid.OpenArrivals()
+	if err != nil {
+		wasabee.Log.Error(err)
+	}
+	for i, a := range arrivals {
+		if i > 8 { // too many rows and the screen fills up
+			break
+		}
+		title := fmt.Sprintf("%s %s - Complete", a.Kind, a.PortalName)
+		var row []tgbotapi.InlineKeyboardButton
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(title, "arrival/complete/"+a.ID))
+		rows = append(rows, row)
+	}
+
+	tmp := tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: rows,
+	}
+	return tmp
+}
+
 func nearbyAssignmentKeyboard(gid wasabee.GoogleID) tgbotapi.InlineKeyboardMarkup {
 	return assignmentKeyboard(gid)
 }
@@ -139,6 +194,22 @@ func callback(update *tgbotapi.Update) (tgbotapi.MessageConfig, error) {
 			tgbotapi.CallbackConfig{CallbackQueryID: update.CallbackQuery.ID, Text: "Marker Updated"},
 		)
 		msg.ReplyMarkup = assignmentKeyboard(gid)
+	case "join":
+		_ = callbackJoin(command[1], command[2], gid, lang, &msg)
+		resp, err = bot.AnswerCallbackQuery(
+			tgbotapi.CallbackConfig{CallbackQueryID: update.CallbackQuery.ID, Text: "Join Request Updated"},
+		)
+		if kbd := joinRequestKeyboard(gid); len(kbd.InlineKeyboard) > 0 {
+			msg.ReplyMarkup = kbd
+		}
+	case "arrival":
+		_ = callbackArrival(command[1], command[2], gid, lang, &msg)
+		resp, err = bot.AnswerCallbackQuery(
+			tgbotapi.CallbackConfig{CallbackQueryID: update.CallbackQuery.ID, Text: "Task Updated"},
+		)
+		if kbd := arrivalKeyboard(gid); len(kbd.InlineKeyboard) > 0 {
+			msg.ReplyMarkup = kbd
+		}
 	case "assignments":
 		resp, err = bot.AnswerCallbackQuery(
 			tgbotapi.CallbackConfig{CallbackQueryID: update.CallbackQuery.ID, Text: "Assignments"},
@@ -215,3 +286,41 @@ func callbackMarker(action, target string, gid wasabee.GoogleID, lang string, ms
 	}
 	return nil
 }
+
+func callbackJoin(action, request string, gid wasabee.GoogleID, lang string, msg *tgbotapi.MessageConfig) error {
+	var err error
+	switch action {
+	case "approve":
+		if err = gid.ApproveJoinRequest(request); err == nil {
+			msg.Text = "join request approved"
+		}
+	case "deny":
+		if err = gid.DenyJoinRequest(request); err == nil {
+			msg.Text = "join request denied"
+		}
+	default:
+		err = fmt.Errorf("unknown join request action: %s", action)
+		wasabee.Log.Error(err)
+	}
+	if err != nil {
+		msg.Text = err.Error()
+	}
+	return err
+}
+
+func callbackArrival(action, arrival string, gid wasabee.GoogleID, lang string, msg *tgbotapi.MessageConfig) error {
+	var err error
+	switch action {
+	case "complete":
+		if err = gid.CompleteArrival(arrival); err == nil {
+			msg.Text = "task completed"
+		}
+	default:
+		err = fmt.Errorf("unknown arrival action: %s", action)
+		wasabee.Log.Error(err)
+	}
+	if err != nil {
+		msg.Text = err.Error()
+	}
+	return err
+}
@@ -1,7 +1,10 @@
 package wasabeetelegram
 
 import (
+	"fmt"
+	"html"
 	"strconv"
+	"strings"
 
 	"github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/wasabee-project/Wasabee-Server"
@@ -83,6 +86,33 @@ func processMessage(msg *tgbotapi.MessageConfig, inMsg *tgbotapi.Update, gid was
 			tmp, _ := templateExecute("help", inMsg.Message.From.LanguageCode, nil)
 			msg.Text = tmp
 			msg.ReplyMarkup = config.baseKbd
+		case "attack":
+			msg.Text = reportAttack(gid, inMsg.Message.CommandArguments())
+			msg.ReplyMarkup = config.baseKbd
+		case "status":
+			msg.Text = setStatus(gid, inMsg.Message.CommandArguments())
+			msg.ReplyMarkup = config.baseKbd
+		case "claim", "decline":
+			msg.Text = respondAttack(gid, inMsg.Message.CommandArguments(), inMsg.Message.Command() == "claim")
+			msg.ReplyMarkup = config.baseKbd
+		case "arrivals":
+			kbd := arrivalKeyboard(gid)
+			if len(kbd.InlineKeyboard) == 0 {
+				msg.Text = "no open arrivals"
+				msg.ReplyMarkup = config.baseKbd
+			} else {
+				msg.Text = "Tasks you are on site for"
+				msg.ReplyMarkup = kbd
+			}
+		case "joinrequests":
+			kbd := joinRequestKeyboard(gid)
+			if len(kbd.InlineKeyboard) == 0 {
+				msg.Text = "no pending join requests"
+				msg.ReplyMarkup = config.baseKbd
+			} else {
+				msg.Text = "Pending join requests"
+				msg.ReplyMarkup = kbd
+			}
 		default:
 			tmp, _ := templateExecute("default", inMsg.Message.From.LanguageCode, nil)
 			msg.Text = tmp
@@ -149,3 +179,83 @@ func teammatesNear(gid wasabee.GoogleID, inMsg *tgbotapi.Update) (string, error)
 
 	return txt, nil
 }
+
+// reportAttack handles "/attack portal name", alerting teammates holding defensive keys for the portal
+func reportAttack(gid wasabee.GoogleID, portal string) string {
+	portal = strings.TrimSpace(portal)
+	if portal == "" {
+		return "usage: /attack portal name"
+	}
+
+	portalID, err := gid.DefensivePortalByName(portal)
+	if err != nil {
+		return err.Error()
+	}
+
+	alert, err := gid.ReportAttack(portalID, "")
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("alerted %d agents holding keys for %s", len(alert.Claims), html.EscapeString(alert.Name))
+}
+
+// respondAttack handles "/claim alertID" and "/decline alertID"
+func respondAttack(gid wasabee.GoogleID, alertID string, claim bool) string {
+	alertID = strings.TrimSpace(alertID)
+	if alertID == "" {
+		return "usage: /claim alertID or /decline alertID"
+	}
+
+	if err := gid.RespondAttack(alertID, claim); err != nil {
+		return err.Error()
+	}
+	if claim {
+		return "recharge claimed, the reporting agent has been told"
+	}
+	return "recharge declined"
+}
+
+// setStatus handles "/status status [note]", setting the status on every op in which the agent has assignments
+func setStatus(gid wasabee.GoogleID, args string) string {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		return "usage: /status enroute|onsite|blocked|outofgear|done [note], or /status clear"
+	}
+
+	tokens := strings.SplitN(args, " ", 2)
+	status := strings.ToLower(tokens[0])
+	var note string
+	if len(tokens) > 1 {
+		note = strings.TrimSpace(tokens[1])
+	}
+	if status == "clear" {
+		status = ""
+	}
+
+	var ud wasabee.AgentData
+	if err := gid.GetAgentData(&ud); err != nil {
+		return err.Error()
+	}
+	if len(ud.Assignments) == 0 {
+		return "no operations with assignments"
+	}
+
+	// carry on past an op that refuses, e.g. a frozen one, and say which did
+	var names, failed []string
+	for _, a := range ud.Assignments {
+		op := wasabee.Operation{ID: a.OpID}
+		if _, err := op.SetStatus(gid, status, note); err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%s)", html.EscapeString(a.OperationName), html.EscapeString(err.Error())))
+			continue
+		}
+		names = append(names, html.EscapeString(a.OperationName))
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("status not updated: %s", strings.Join(failed, ", "))
+	}
+	msg := fmt.Sprintf("status updated on %s", strings.Join(names, ", "))
+	if len(failed) > 0 {
+		msg = fmt.Sprintf("%s; not updated on %s", msg, strings.Join(failed, ", "))
+	}
+	return msg
+}
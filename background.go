@@ -9,6 +9,10 @@ import (
 func BackgroundTasks(c chan os.Signal) {
 	Log.Infow("startup", "message", "running initial background tasks")
 	locationClean()
+	transferClean()
+	defenseAlertClean()
+	locationHistoryClean()
+	locationShareClean()
 
 	ticker := time.NewTicker(time.Hour)
 	defer ticker.Stop()
@@ -20,6 +24,10 @@ func BackgroundTasks(c chan os.Signal) {
 			return
 		case <-ticker.C:
 			locationClean()
+			transferClean()
+			defenseAlertClean()
+			locationHistoryClean()
+			locationShareClean()
 		}
 	}
 }
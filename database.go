@@ -27,6 +27,7 @@ func Connect(uri string) error {
 	Log.Infow("startup", "database", "connected", "version", version, "message", "connected to database")
 
 	setupTables()
+	upgradeTables()
 	return nil
 }
 
@@ -39,6 +40,11 @@ func Disconnect() {
 	}
 }
 
+// execer is satisfied by both db and a transaction, so inserts can be grouped into a transaction when needed
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
 // setupTables checks for the existence of tables and creates them if needed
 func setupTables() {
 	var t = []struct {
@@ -46,23 +52,45 @@ func setupTables() {
 		creation  string
 	}{
 		// agent must come first, team must come second, operation must come third, the rest can be in alphabetical order
-		{"agent", `CREATE TABLE agent ( gid varchar(32) NOT NULL, iname varchar(64) DEFAULT NULL, level tinyint(4) NOT NULL DEFAULT '1', lockey varchar(64) DEFAULT NULL, VVerified tinyint(1) NOT NULL DEFAULT '0', Vblacklisted tinyint(1) NOT NULL DEFAULT '0', Vid varchar(40) DEFAULT NULL, RocksVerified tinyint(1) NOT NULL DEFAULT '0', RAID tinyint(1) NOT NULL DEFAULT '0', RISC tinyint(1) NOT NULL DEFAULT '0', PRIMARY KEY (gid), UNIQUE KEY iname (iname), UNIQUE KEY lockey (lockey), UNIQUE KEY Vid (Vid)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
-		{"team", `CREATE TABLE team ( teamID varchar(64) NOT NULL, owner varchar(32) NOT NULL, name varchar(64) DEFAULT NULL, rockskey varchar(32) DEFAULT NULL, rockscomm varchar(32) DEFAULT NULL, joinLinkToken varchar(64), telegram bigint signed, PRIMARY KEY (teamID), KEY fk_team_owner (owner), CONSTRAINT fk_team_owner FOREIGN KEY (owner) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
-		{"operation", `CREATE TABLE operation ( ID varchar(64) NOT NULL, name varchar(128) NOT NULL DEFAULT 'new op', gid varchar(32) NOT NULL, color varchar(16) NOT NULL DEFAULT 'groupa', teamID varchar(64) NOT NULL DEFAULT '', modified datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, comment text, PRIMARY KEY (ID), KEY gid (gid), KEY teamID (teamID), CONSTRAINT fk_operation_agent FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
+		{"agent", `CREATE TABLE agent ( gid varchar(32) NOT NULL, iname varchar(64) DEFAULT NULL, level tinyint(4) NOT NULL DEFAULT '1', lockey varchar(64) DEFAULT NULL, VVerified tinyint(1) NOT NULL DEFAULT '0', Vblacklisted tinyint(1) NOT NULL DEFAULT '0', Vid varchar(40) DEFAULT NULL, RocksVerified tinyint(1) NOT NULL DEFAULT '0', RAID tinyint(1) NOT NULL DEFAULT '0', RISC tinyint(1) NOT NULL DEFAULT '0', lochistory tinyint(1) NOT NULL DEFAULT '0', lochistorydays smallint(6) NOT NULL DEFAULT '7', PRIMARY KEY (gid), UNIQUE KEY iname (iname), UNIQUE KEY lockey (lockey), UNIQUE KEY Vid (Vid)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
+		{"team", `CREATE TABLE team ( teamID varchar(64) NOT NULL, owner varchar(32) NOT NULL, name varchar(64) DEFAULT NULL, rockskey varchar(32) DEFAULT NULL, rockscomm varchar(32) DEFAULT NULL, joinLinkToken varchar(64), telegram bigint signed, joinapproval tinyint(1) NOT NULL DEFAULT '0', requirev tinyint(1) NOT NULL DEFAULT '0', requirerocks tinyint(1) NOT NULL DEFAULT '0', refuseblacklisted tinyint(1) NOT NULL DEFAULT '0', minlevel tinyint(4) NOT NULL DEFAULT '0', parent varchar(64) DEFAULT NULL, PRIMARY KEY (teamID), KEY fk_team_owner (owner), KEY fk_team_parent (parent), CONSTRAINT fk_team_owner FOREIGN KEY (owner) REFERENCES agent (gid) ON DELETE CASCADE, CONSTRAINT fk_team_parent FOREIGN KEY (parent) REFERENCES team (teamID) ON DELETE SET NULL) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
+		{"operation", `CREATE TABLE operation ( ID varchar(64) NOT NULL, name varchar(128) NOT NULL DEFAULT 'new op', gid varchar(32) NOT NULL, color varchar(16) NOT NULL DEFAULT 'groupa', teamID varchar(64) NOT NULL DEFAULT '', modified datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, comment text, template tinyint(1) NOT NULL DEFAULT '0', frozen tinyint(1) NOT NULL DEFAULT '0', PRIMARY KEY (ID), KEY gid (gid), KEY teamID (teamID), CONSTRAINT fk_operation_agent FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
 
 		{"agentextras", `CREATE TABLE agentextras ( gid varchar(32) NOT NULL, picurl text, UNIQUE KEY gid (gid), CONSTRAINT fk_extra_agent FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
-		{"agentteams", `CREATE TABLE agentteams ( teamID varchar(64) NOT NULL, gid varchar(32) NOT NULL, state enum('Off','On') NOT NULL DEFAULT 'Off', color varchar(32) NOT NULL DEFAULT 'boots', displayname varchar(32) DEFAULT NULL,  PRIMARY KEY (teamID,gid), KEY GIDKEY (gid), CONSTRAINT fk_agent_teams FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE, CONSTRAINT fk_t_teams FOREIGN KEY (teamID) REFERENCES team (teamID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
+		{"agentteams", `CREATE TABLE agentteams ( teamID varchar(64) NOT NULL, gid varchar(32) NOT NULL, state enum('Off','On') NOT NULL DEFAULT 'Off', color varchar(32) NOT NULL DEFAULT 'boots', displayname varchar(32) DEFAULT NULL, role enum('admin','member','observer') NOT NULL DEFAULT 'member', suspended tinyint(1) NOT NULL DEFAULT '0', locprecision tinyint(4) NOT NULL DEFAULT '0', shareuntil datetime DEFAULT NULL, shareop varchar(64) DEFAULT NULL, sharesince datetime DEFAULT NULL, PRIMARY KEY (teamID,gid), KEY GIDKEY (gid), CONSTRAINT fk_agent_teams FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE, CONSTRAINT fk_t_teams FOREIGN KEY (teamID) REFERENCES team (teamID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
 		{"firebase", `CREATE TABLE firebase ( gid varchar(32) NOT NULL, token varchar(4092) NOT NULL, KEY fk_gid (gid), CONSTRAINT fk_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
 		{"link", `CREATE TABLE link ( ID varchar(64) NOT NULL, fromPortalID varchar(64) NOT NULL, toPortalID varchar(64) NOT NULL, opID varchar(64) NOT NULL, description text, gid varchar(32) DEFAULT NULL, throworder int(11) DEFAULT '0', completed tinyint(1) NOT NULL DEFAULT '0', color varchar(16) NOT NULL DEFAULT 'main', zone tinyint(4) NOT NULL DEFAULT 1, PRIMARY KEY (ID,opID), KEY fk_operation_id_link (opID), KEY fk_link_gid (gid), CONSTRAINT fk_link_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE SET NULL, CONSTRAINT fk_operation_id_link FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
+		{"locationhistory", `CREATE TABLE locationhistory ( gid varchar(32) NOT NULL, loc point NOT NULL, recorded datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, KEY gid_recorded (gid,recorded), CONSTRAINT fk_locationhistory_agent FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
 		{"locations", `CREATE TABLE locations ( gid varchar(32) NOT NULL, upTime datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, loc point NOT NULL, PRIMARY KEY (gid)) DEFAULT CHARSET=utf8mb4;`},
-		{"marker", `CREATE TABLE marker ( ID varchar(64) NOT NULL, opID varchar(64) NOT NULL, portalID varchar(64) NOT NULL, type varchar(128) NOT NULL, gid varchar(32) DEFAULT NULL, comment text, complete tinyint(1) NOT NULL DEFAULT '0', state enum('pending','assigned','acknowledged','completed') NOT NULL DEFAULT 'pending', completedBy varchar(32) DEFAULT NULL, oporder int NOT NULL DEFAULT 0, zone tinyint(4) NOT NULL DEFAULT 1, PRIMARY KEY (ID,opID), KEY fk_operation_marker (opID), KEY fk_marker_gid (gid), CONSTRAINT fk_marker_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE SET NULL, CONSTRAINT fk_operation_marker FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
+		{"marker", `CREATE TABLE marker ( ID varchar(64) NOT NULL, opID varchar(64) NOT NULL, portalID varchar(64) NOT NULL, type varchar(128) NOT NULL, gid varchar(32) DEFAULT NULL, comment text, complete tinyint(1) NOT NULL DEFAULT '0', state enum('pending','assigned','acknowledged','onsite','completed') NOT NULL DEFAULT 'pending', completedBy varchar(32) DEFAULT NULL, oporder int NOT NULL DEFAULT 0, zone tinyint(4) NOT NULL DEFAULT 1, PRIMARY KEY (ID,opID), KEY fk_operation_marker (opID), KEY fk_marker_gid (gid), CONSTRAINT fk_marker_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE SET NULL, CONSTRAINT fk_operation_marker FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
 		{"messagelog", `CREATE TABLE messagelog ( timestamp datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, gid varchar(32) NOT NULL, message text NOT NULL) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
-		{"opkeys", `CREATE TABLE opkeys ( opID varchar(64) NOT NULL, portalID varchar(64) NOT NULL, gid varchar(32) NOT NULL, onhand int(11) NOT NULL DEFAULT '0', capsule varchar(8) DEFAULT NULL, UNIQUE KEY key_unique (opID,portalID,gid), KEY fk_operation_id_keys (opID), CONSTRAINT fk_operation_id_keys FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
 		{"portal", `CREATE TABLE portal ( ID varchar(64) NOT NULL, opID varchar(64) NOT NULL, name varchar(128) NOT NULL, loc point NOT NULL, comment text, hardness varchar(64) DEFAULT NULL, PRIMARY KEY ID (ID,opID), KEY fk_operation_id (opID)) DEFAULT CHARSET=utf8mb4;`},
+		{"portaldefense", `CREATE TABLE portaldefense ( opID varchar(64) NOT NULL, portalID varchar(64) NOT NULL, bursters int NOT NULL DEFAULT 0, shields tinyint NOT NULL DEFAULT 0, linkamps tinyint NOT NULL DEFAULT 0, virus enum('ADA','JARVIS') DEFAULT NULL, owner varchar(64) DEFAULT NULL, observed datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (opID,portalID), CONSTRAINT fk_operation_defense FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
+		{"portalnotes", `CREATE TABLE portalnotes ( portalID varchar(64) NOT NULL, teamID varchar(64) NOT NULL, gid varchar(32) DEFAULT NULL, comment text, hardness varchar(64) DEFAULT NULL, updated datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (portalID,teamID), KEY fk_portalnote_team (teamID), CONSTRAINT fk_portalnote_team FOREIGN KEY (teamID) REFERENCES team (teamID) ON DELETE CASCADE, CONSTRAINT fk_portalnote_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE SET NULL) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
+		{"portalregistry", `CREATE TABLE portalregistry ( ID varchar(64) NOT NULL, name varchar(128) NOT NULL, loc point NOT NULL, updated datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (ID), KEY name (name)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
 		{"telegram", `CREATE TABLE telegram ( telegramID bigint(20) NOT NULL, telegramName varchar(32) NOT NULL, gid varchar(32) NOT NULL, verified tinyint(1) NOT NULL DEFAULT '0', authtoken varchar(32) DEFAULT NULL, PRIMARY KEY (telegramID), UNIQUE KEY gid (gid), CONSTRAINT fk_agent_telegram FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
 		{"opteams", `CREATE TABLE opteams (teamID varchar(64) NOT NULL, opID varchar(64) NOT NULL, permission enum('read','write','assignedonly') NOT NULL DEFAULT 'read', zone tinyint(4) NOT NULL DEFAULT 0, KEY opID (opID), KEY teamID (teamID), CONSTRAINT fk_ops_teamID FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE, CONSTRAINT fk_teamIDs_op FOREIGN KEY (teamID) REFERENCES team (teamID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
 		{"defensivekeys", `CREATE TABLE defensivekeys (gid varchar(32) NOT NULL, portalID varchar(64) NOT NULL, capID varchar(12) DEFAULT NULL, count int(3) NOT NULL DEFAULT '0', name varchar(128) DEFAULT NULL, loc point DEFAULT NULL, PRIMARY KEY (portalID, gid), KEY fk_dk_gid (gid), CONSTRAINT fk_dk_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"defensealert", `CREATE TABLE defensealert ( ID varchar(16) NOT NULL, portalID varchar(64) NOT NULL, gid varchar(32) NOT NULL, message text, created datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, open tinyint(1) NOT NULL DEFAULT '1', PRIMARY KEY (ID), KEY fk_alert_gid (gid), CONSTRAINT fk_alert_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"defenseclaim", `CREATE TABLE defenseclaim ( alertID varchar(16) NOT NULL, gid varchar(32) NOT NULL, response enum('notified','claimed','declined') NOT NULL DEFAULT 'notified', updated datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (alertID,gid), KEY fk_claim_gid (gid), CONSTRAINT fk_claim_alert FOREIGN KEY (alertID) REFERENCES defensealert (ID) ON DELETE CASCADE, CONSTRAINT fk_claim_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
 		{"deletedops", `CREATE TABLE deletedops ( opID varchar(64) NOT NULL, deletedate datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, gid varchar(32), PRIMARY KEY(opID)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"opcoowners", `CREATE TABLE opcoowners ( opID varchar(64) NOT NULL, gid varchar(32) NOT NULL, PRIMARY KEY (opID,gid), KEY fk_coowner_gid (gid), CONSTRAINT fk_coowner_op FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE, CONSTRAINT fk_coowner_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"opshare", `CREATE TABLE opshare ( token varchar(64) NOT NULL, opID varchar(64) NOT NULL, gid varchar(32) NOT NULL, zone tinyint(4) NOT NULL DEFAULT 0, created datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, expires datetime DEFAULT NULL, PRIMARY KEY (token), KEY fk_operation_share (opID), CONSTRAINT fk_operation_share FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"opsharelog", `CREATE TABLE opsharelog ( token varchar(64) NOT NULL, accessed datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, remote varchar(64) DEFAULT NULL, KEY fk_share_log (token), CONSTRAINT fk_share_log FOREIGN KEY (token) REFERENCES opshare (token) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"transfer", `CREATE TABLE transfer ( kind enum('op','team') NOT NULL, resource varchar(64) NOT NULL, name varchar(128) DEFAULT NULL, fromgid varchar(32) NOT NULL, togid varchar(32) NOT NULL, expires datetime NOT NULL, PRIMARY KEY (kind,resource), KEY fk_transfer_to (togid), CONSTRAINT fk_transfer_to FOREIGN KEY (togid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"keyinventory", `CREATE TABLE keyinventory ( gid varchar(32) NOT NULL, portalID varchar(64) NOT NULL, capsule varchar(16) NOT NULL DEFAULT '', count int(11) NOT NULL DEFAULT '0', PRIMARY KEY (gid,portalID,capsule), KEY portalID (portalID), CONSTRAINT fk_keyinventory_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
+		{"opkeyshare", `CREATE TABLE opkeyshare ( opID varchar(64) NOT NULL, gid varchar(32) NOT NULL, PRIMARY KEY (opID,gid), KEY fk_opkeyshare_gid (gid), CONSTRAINT fk_opkeyshare_op FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE, CONSTRAINT fk_opkeyshare_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
+		{"keyrequest", `CREATE TABLE keyrequest ( ID varchar(32) NOT NULL, opID varchar(64) NOT NULL, portalID varchar(64) NOT NULL, gid varchar(32) NOT NULL, count int(11) NOT NULL DEFAULT '1', state enum('open','fulfilled','cancelled') NOT NULL DEFAULT 'open', created datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (ID), KEY fk_keyrequest_op (opID), KEY fk_keyrequest_gid (gid), CONSTRAINT fk_keyrequest_op FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE, CONSTRAINT fk_keyrequest_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
+		{"keypledge", `CREATE TABLE keypledge ( requestID varchar(32) NOT NULL, gid varchar(32) NOT NULL, count int(11) NOT NULL DEFAULT '1', state enum('pledged','delivered','withdrawn') NOT NULL DEFAULT 'pledged', updated datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (requestID,gid), KEY fk_keypledge_gid (gid), CONSTRAINT fk_keypledge_request FOREIGN KEY (requestID) REFERENCES keyrequest (ID) ON DELETE CASCADE, CONSTRAINT fk_keypledge_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`},
+		{"opclaimpolicy", `CREATE TABLE opclaimpolicy ( opID varchar(64) NOT NULL, enabled tinyint(1) NOT NULL DEFAULT '0', links tinyint(1) NOT NULL DEFAULT '0', types text, zones varchar(255) DEFAULT NULL, maxclaims int(11) NOT NULL DEFAULT '0', PRIMARY KEY (opID), CONSTRAINT fk_claimpolicy_op FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"opclaims", `CREATE TABLE opclaims ( opID varchar(64) NOT NULL, kind enum('marker','link') NOT NULL, taskID varchar(64) NOT NULL, gid varchar(32) NOT NULL, claimed datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (opID,kind,taskID), KEY fk_claims_gid (gid), CONSTRAINT fk_claims_op FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE, CONSTRAINT fk_claims_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"oparrivalpolicy", `CREATE TABLE oparrivalpolicy ( opID varchar(64) NOT NULL, enabled tinyint(1) NOT NULL DEFAULT '0', radius int(11) NOT NULL DEFAULT '40', prompt tinyint(1) NOT NULL DEFAULT '0', PRIMARY KEY (opID), CONSTRAINT fk_arrivalpolicy_op FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"joinrequest", `CREATE TABLE joinrequest ( ID varchar(16) NOT NULL, teamID varchar(64) NOT NULL, gid varchar(32) NOT NULL, requested datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, token varchar(64) DEFAULT NULL, PRIMARY KEY (ID), UNIQUE KEY team_agent (teamID,gid), KEY fk_joinrequest_gid (gid), CONSTRAINT fk_joinrequest_team FOREIGN KEY (teamID) REFERENCES team (teamID) ON DELETE CASCADE, CONSTRAINT fk_joinrequest_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"joinlink", `CREATE TABLE joinlink ( token varchar(64) NOT NULL, teamID varchar(64) NOT NULL, gid varchar(32) NOT NULL, created datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, expires datetime DEFAULT NULL, maxuses int(11) NOT NULL DEFAULT '0', uses int(11) NOT NULL DEFAULT '0', squad varchar(32) DEFAULT NULL, starton tinyint(1) NOT NULL DEFAULT '0', PRIMARY KEY (token), KEY fk_joinlink_team (teamID), CONSTRAINT fk_joinlink_team FOREIGN KEY (teamID) REFERENCES team (teamID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"joinlinklog", `CREATE TABLE joinlinklog ( token varchar(64) NOT NULL, gid varchar(32) NOT NULL, joined datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (token,gid), KEY fk_joinlinklog_gid (gid), CONSTRAINT fk_joinlinklog_token FOREIGN KEY (token) REFERENCES joinlink (token) ON DELETE CASCADE, CONSTRAINT fk_joinlinklog_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"teamtree", `CREATE TABLE teamtree ( ancestor varchar(64) NOT NULL, descendant varchar(64) NOT NULL, PRIMARY KEY (ancestor,descendant), KEY fk_teamtree_descendant (descendant), CONSTRAINT fk_teamtree_ancestor FOREIGN KEY (ancestor) REFERENCES team (teamID) ON DELETE CASCADE, CONSTRAINT fk_teamtree_descendant FOREIGN KEY (descendant) REFERENCES team (teamID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"arrival", `CREATE TABLE arrival ( ID varchar(16) NOT NULL, opID varchar(64) NOT NULL, kind enum('marker','link') NOT NULL, taskID varchar(64) NOT NULL, gid varchar(32) NOT NULL, arrived datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (ID), UNIQUE KEY task (opID,kind,taskID), KEY fk_arrival_gid (gid), CONSTRAINT fk_arrival_op FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE, CONSTRAINT fk_arrival_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
+		{"agentstatus", `CREATE TABLE agentstatus ( opID varchar(64) NOT NULL, gid varchar(32) NOT NULL, status enum('enroute','onsite','blocked','outofgear','done') NOT NULL, note varchar(255) DEFAULT NULL, updated datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (opID,gid), KEY fk_agentstatus_gid (gid), CONSTRAINT fk_agentstatus_op FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE, CONSTRAINT fk_agentstatus_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
 		{"zone", `CREATE TABLE zone ( ID tinyint(4) NOT NULL, opID varchar(64) NOT NULL, name varchar(64) NOT NULL DEFAULT 'zone', PRIMARY KEY (ID,opID), KEY fk_operation_zone (opID), CONSTRAINT fk_operation_zone FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`},
 	}
 
@@ -90,19 +118,52 @@ func setupTables() {
 			Log.Error(err)
 		}
 	}()
-	for _, v := range t {
-		q := fmt.Sprintf("SHOW TABLES LIKE '%s'", v.tablename)
-		err = tx.QueryRow(q).Scan(&table)
+	exists := func(tablename string) bool {
+		table = ""
+		q := fmt.Sprintf("SHOW TABLES LIKE '%s'", tablename)
+		err := tx.QueryRow(q).Scan(&table)
 		if err != nil && err != sql.ErrNoRows {
 			Log.Error(err)
-			continue
+			return true // do not attempt to create or read from it
 		}
-		if err == sql.ErrNoRows || table == "" {
+		return err == nil && table != ""
+	}
+
+	for _, v := range t {
+		if !exists(v.tablename) {
 			Log.Infof("Setting up '%s' table...", v.tablename)
 			_, err = tx.Exec(v.creation)
 			if err != nil {
 				Log.Error(err)
 			}
+			// carry existing key counts into the new inventory
+			if v.tablename == "keyinventory" {
+				_, err = tx.Exec("INSERT IGNORE INTO keyinventory (gid, portalID, capsule, count) SELECT gid, portalID, COALESCE(capID, ''), count FROM defensivekeys WHERE count > 0")
+				if err != nil {
+					Log.Error(err)
+				}
+				if exists("opkeys") {
+					_, err = tx.Exec("INSERT IGNORE INTO keyinventory (gid, portalID, capsule, count) SELECT gid, portalID, COALESCE(capsule, ''), MAX(onhand) FROM opkeys WHERE onhand > 0 GROUP BY gid, portalID, capsule")
+					if err != nil {
+						Log.Error(err)
+					}
+				}
+			}
+			// start the registry with every portal already in an op
+			if v.tablename == "portalregistry" {
+				_, err = tx.Exec("INSERT IGNORE INTO portalregistry (ID, name, loc) SELECT ID, name, loc FROM portal")
+				if err != nil {
+					Log.Error(err)
+				}
+			}
+			// agents who reported keys to an op keep sharing them with it
+			// opkeys is no longer used but is left in place, drop it by hand once the copy has been checked
+			if v.tablename == "opkeyshare" && exists("opkeys") {
+				_, err = tx.Exec("INSERT IGNORE INTO opkeyshare (opID, gid) SELECT DISTINCT opID, gid FROM opkeys")
+				if err != nil {
+					Log.Error(err)
+				}
+			}
 		}
 	}
 	_, err = tx.Exec("SET FOREIGN_KEY_CHECKS=1")
@@ -116,6 +177,43 @@ func setupTables() {
 	// defer'd func runs here
 }
 
+// upgradeTables brings tables created by older versions up to date
+// each statement must be safe to run on every startup
+func upgradeTables() {
+	var u = []struct {
+		tablename string
+		upgrade   string
+	}{
+		{"operation", "ALTER TABLE operation ADD COLUMN IF NOT EXISTS template tinyint(1) NOT NULL DEFAULT '0' AFTER comment"},
+		{"operation", "ALTER TABLE operation ADD COLUMN IF NOT EXISTS frozen tinyint(1) NOT NULL DEFAULT '0' AFTER template"},
+		{"agentteams", "ALTER TABLE agentteams ADD COLUMN IF NOT EXISTS role enum('admin','member','observer') NOT NULL DEFAULT 'member' AFTER displayname"},
+		{"team", "ALTER TABLE team ADD COLUMN IF NOT EXISTS joinapproval tinyint(1) NOT NULL DEFAULT '0' AFTER telegram"},
+		{"team", "ALTER TABLE team ADD COLUMN IF NOT EXISTS requirev tinyint(1) NOT NULL DEFAULT '0' AFTER joinapproval"},
+		{"team", "ALTER TABLE team ADD COLUMN IF NOT EXISTS requirerocks tinyint(1) NOT NULL DEFAULT '0' AFTER requirev"},
+		{"team", "ALTER TABLE team ADD COLUMN IF NOT EXISTS refuseblacklisted tinyint(1) NOT NULL DEFAULT '0' AFTER requirerocks"},
+		{"team", "ALTER TABLE team ADD COLUMN IF NOT EXISTS minlevel tinyint(4) NOT NULL DEFAULT '0' AFTER refuseblacklisted"},
+		{"agentteams", "ALTER TABLE agentteams ADD COLUMN IF NOT EXISTS suspended tinyint(1) NOT NULL DEFAULT '0' AFTER role"},
+		{"team", "ALTER TABLE team ADD COLUMN IF NOT EXISTS parent varchar(64) DEFAULT NULL AFTER minlevel"},
+		{"team", "ALTER TABLE team ADD KEY IF NOT EXISTS fk_team_parent (parent)"},
+		{"team", "ALTER TABLE team ADD CONSTRAINT fk_team_parent FOREIGN KEY IF NOT EXISTS fk_team_parent (parent) REFERENCES team (teamID) ON DELETE SET NULL"},
+		{"teamtree", "INSERT IGNORE INTO teamtree (ancestor, descendant) SELECT teamID, teamID FROM team"},
+		{"agent", "ALTER TABLE agent ADD COLUMN IF NOT EXISTS lochistory tinyint(1) NOT NULL DEFAULT '0' AFTER RISC"},
+		{"agent", "ALTER TABLE agent ADD COLUMN IF NOT EXISTS lochistorydays smallint(6) NOT NULL DEFAULT '7' AFTER lochistory"},
+		{"agentteams", "ALTER TABLE agentteams ADD COLUMN IF NOT EXISTS locprecision tinyint(4) NOT NULL DEFAULT '0' AFTER suspended"},
+		{"agentteams", "ALTER TABLE agentteams ADD COLUMN IF NOT EXISTS shareuntil datetime DEFAULT NULL AFTER locprecision"},
+		{"agentteams", "ALTER TABLE agentteams ADD COLUMN IF NOT EXISTS shareop varchar(64) DEFAULT NULL AFTER shareuntil"},
+		{"marker", "ALTER TABLE marker MODIFY state enum('pending','assigned','acknowledged','onsite','completed') NOT NULL DEFAULT 'pending'"},
+		{"agentteams", "ALTER TABLE agentteams ADD COLUMN IF NOT EXISTS sharesince datetime DEFAULT NULL AFTER shareop"},
+		{"agentteams", "UPDATE agentteams SET sharesince = UTC_TIMESTAMP() WHERE state = 'On' AND sharesince IS NULL"},
+	}
+
+	for _, v := range u {
+		if _, err := db.Exec(v.upgrade); err != nil {
+			Log.Errorw(err.Error(), "table", v.tablename)
+		}
+	}
+}
+
 // MakeNullString is used for values that may & might be inserted/updated as NULL in the database
 func MakeNullString(in interface{}) sql.NullString {
 	var s string
@@ -138,3 +236,9 @@ func MakeNullString(in interface{}) sql.NullString {
 		Valid:  true,
 	}
 }
+
+// distanceSQL is the great-circle distance in km from a point to the lat and lon column expressions, MariaDB has no ST_Distance_Sphere yet.
+// The query takes the point's lat, lon and lat again as arguments.
+func distanceSQL(lat, lon string) string {
+	return fmt.Sprintf("6371 * acos(LEAST(1, cos(radians(?)) * cos(radians(%s)) * cos(radians(%s) - radians(?)) + sin(radians(?)) * sin(radians(%s))))", lat, lon, lat)
+}
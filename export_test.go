@@ -0,0 +1,12 @@
+package wasabee
+
+import (
+	"time"
+)
+
+// SetTransferWindow changes how long ownership transfers stay open, returning the previous window
+func SetTransferWindow(d time.Duration) time.Duration {
+	old := transferWindow
+	transferWindow = d
+	return old
+}
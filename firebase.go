@@ -31,12 +31,13 @@ const (
 	FbccBroadcastDelete
 	FbccDeleteOp
 	FbccTarget
+	FbccAgentStatusChange
 )
 
 // String is the string value of the Firebase Command Code
 // yes, delete is the same for broadcast and direct
 func (cc FirebaseCommandCode) String() string {
-	return [...]string{"Quit", "Generic Message", "Agent Location Change", "Map Change", "Marker Status Change", "Marker Assignment Change", "Link Status Change", "Link Assignment Change", "Subscribe", "Login", "Delete", "Delete", "Target"}[cc]
+	return [...]string{"Quit", "Generic Message", "Agent Location Change", "Map Change", "Marker Status Change", "Marker Assignment Change", "Link Status Change", "Link Assignment Change", "Subscribe", "Login", "Delete", "Delete", "Target", "Agent Status Change"}[cc]
 }
 
 // FirebaseCmd is the struct passed to the Firebase module to take actions -- required params depend on the FBCC
@@ -0,0 +1,129 @@
+package wasabeehttps
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func drawArrivalPolicyRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if read, _ := op.ReadAccess(gid); !read && !op.AssignedOnlyAccess(gid) {
+		err = fmt.Errorf("permission to view arrival policy denied")
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", op.ID)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	p, err := op.ID.ArrivalPolicy()
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	if p == nil {
+		p = &wasabee.ArrivalPolicy{}
+	}
+
+	j, _ := json.Marshal(p)
+	fmt.Fprint(res, string(j))
+}
+
+func drawArrivalPolicySetRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if !contentTypeIs(req, jsonTypeShort) {
+		err := fmt.Errorf("invalid request (needs to be application/json)")
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", op.ID)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	jBlob, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	if string(jBlob) == "" {
+		err := fmt.Errorf("empty JSON for arrival policy")
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", op.ID)
+		http.Error(res, jsonStatusEmpty, http.StatusNotAcceptable)
+		return
+	}
+
+	var p wasabee.ArrivalPolicy
+	jRaw := json.RawMessage(jBlob)
+	if err = json.Unmarshal(jRaw, &p); err != nil {
+		wasabee.Log.Errorw(err.Error(), "GID", gid, "content", jRaw)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	if err := op.ID.SetArrivalPolicy(gid, p); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	uid, err := op.Touch()
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, jsonOKUpdateID(uid))
+}
+
+func drawArrivalsRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if read, _ := op.ReadAccess(gid); !read {
+		err = fmt.Errorf("read access required to view arrivals")
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", op.ID)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	list, err := op.ID.Arrivals()
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	j, _ := json.Marshal(list)
+	fmt.Fprint(res, string(j))
+}
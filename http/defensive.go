@@ -3,10 +3,12 @@ package wasabeehttps
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/gorilla/mux"
 	"github.com/wasabee-project/Wasabee-Server"
-	"net/http"
-	// "strconv"
 	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
 )
 
 func getDefensiveKeys(res http.ResponseWriter, req *http.Request) {
@@ -18,7 +20,14 @@ func getDefensiveKeys(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	dkl, err := gid.ListDefensiveKeys()
+	f, err := defensiveKeyFilter(req)
+	if err != nil {
+		wasabee.Log.Warnw(err.Error(), "GID", gid)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	dkl, err := gid.ListDefensiveKeys(f)
 	if err != nil {
 		wasabee.Log.Warn(err)
 		http.Error(res, jsonError(err), http.StatusInternalServerError)
@@ -29,6 +38,80 @@ func getDefensiveKeys(res http.ResponseWriter, req *http.Request) {
 	fmt.Fprint(res, string(data))
 }
 
+// the same filters as getDefensiveKeys, grouped by portal
+func getDefensiveKeysByPortal(res http.ResponseWriter, req *http.Request) {
+	res.Header().Add("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Warn(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	f, err := defensiveKeyFilter(req)
+	if err != nil {
+		wasabee.Log.Warnw(err.Error(), "GID", gid)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	dkl, err := gid.ListDefensiveKeys(f)
+	if err != nil {
+		wasabee.Log.Warn(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(dkl.ByPortal())
+	fmt.Fprint(res, string(data))
+}
+
+// defensiveKeyFilter reads the optional bbox, lat/lon/radius, portals, min and agent query parameters
+func defensiveKeyFilter(req *http.Request) (wasabee.DefensiveKeyFilter, error) {
+	var f wasabee.DefensiveKeyFilter
+	var err error
+
+	if f.BBox, err = parseBBox(req.FormValue("bbox")); err != nil {
+		return f, err
+	}
+
+	if r := req.FormValue("radius"); r != "" {
+		f.Lat = req.FormValue("lat")
+		f.Lon = req.FormValue("lon")
+		for _, v := range []string{f.Lat, f.Lon} {
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return f, fmt.Errorf("radius requires a valid lat and lon")
+			}
+		}
+		if f.Radius, err = strconv.ParseFloat(r, 64); err != nil || f.Radius <= 0 {
+			return f, fmt.Errorf("invalid radius")
+		}
+	}
+
+	if p := req.FormValue("portals"); p != "" {
+		for _, id := range strings.Split(p, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				f.PortalIDs = append(f.PortalIDs, wasabee.PortalID(id))
+			}
+		}
+	}
+
+	if m := req.FormValue("min"); m != "" {
+		min, err := strconv.ParseInt(m, 10, 32)
+		if err != nil {
+			return f, fmt.Errorf("invalid min")
+		}
+		f.MinCount = int32(min)
+	}
+
+	if a := req.FormValue("agent"); a != "" {
+		if f.Agent, err = wasabee.ToGid(a); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
 func setDefensiveKey(res http.ResponseWriter, req *http.Request) {
 	var dk wasabee.DefensiveKey
 
@@ -152,3 +235,92 @@ func setDefensiveKeyBulk(res http.ResponseWriter, req *http.Request) {
 	}
 	fmt.Fprint(res, jsonStatusOK)
 }
+
+func defenseAlertsRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Add("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Warn(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	alerts, err := gid.DefenseAlerts()
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(alerts)
+	fmt.Fprint(res, string(data))
+}
+
+// report a portal under attack
+func defenseAlertNewRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Add("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Warn(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	portalID := wasabee.PortalID(req.FormValue("portalID"))
+	if portalID == "" {
+		err := fmt.Errorf("portalID required")
+		wasabee.Log.Warnw(err.Error(), "GID", gid)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	alert, err := gid.ReportAttack(portalID, req.FormValue("message"))
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	data, _ := json.Marshal(alert)
+	fmt.Fprint(res, string(data))
+}
+
+func defenseAlertClaimRoute(res http.ResponseWriter, req *http.Request) {
+	defenseAlertRespond(res, req, true)
+}
+
+func defenseAlertDeclineRoute(res http.ResponseWriter, req *http.Request) {
+	defenseAlertRespond(res, req, false)
+}
+
+func defenseAlertRespond(res http.ResponseWriter, req *http.Request, claim bool) {
+	res.Header().Add("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Warn(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	if err := gid.RespondAttack(vars["alert"], claim); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func defenseAlertCloseRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Add("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Warn(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	if err := gid.CloseAttack(vars["alert"]); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -152,7 +153,7 @@ func drawDeleteRoute(res http.ResponseWriter, req *http.Request) {
 	op.ID = wasabee.OperationID(vars["document"])
 
 	// op.Delete checks ownership, do we need this check? -- yes for good status codes
-	if !op.ID.IsOwner(gid) {
+	if !op.ID.IsPrimaryOwner(gid) {
 		err = fmt.Errorf("forbidden: only the owner can delete an operation")
 		wasabee.Log.Warnw(err.Error(), "resource", op.ID, "GID", gid)
 		http.Error(res, jsonError(err), http.StatusForbidden)
@@ -238,7 +239,7 @@ func drawChownRoute(res http.ResponseWriter, req *http.Request) {
 	var op wasabee.Operation
 	op.ID = wasabee.OperationID(vars["document"])
 
-	if !op.ID.IsOwner(gid) {
+	if !op.ID.IsPrimaryOwner(gid) {
 		err = fmt.Errorf("forbidden: only the owner can set operation ownership ")
 		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", op.ID)
 		http.Error(res, jsonError(err), http.StatusForbidden)
@@ -255,6 +256,52 @@ func drawChownRoute(res http.ResponseWriter, req *http.Request) {
 	fmt.Fprint(res, jsonStatusOK)
 }
 
+func drawChownAcceptRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if err = op.ID.AcceptChown(gid); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	uid, err := op.Touch()
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, jsonOKUpdateID(uid))
+}
+
+func drawChownCancelRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	opID := wasabee.OperationID(vars["document"])
+
+	if err = opID.CancelChown(gid); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
 func drawStockRoute(res http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	id := vars["document"]
@@ -334,6 +381,12 @@ func drawLinkAssignRoute(res http.ResponseWriter, req *http.Request) {
 		http.Error(res, jsonError(err), http.StatusForbidden)
 		return
 	}
+	if op.ID.IsFrozen() && !op.ID.IsOwner(gid) {
+		err = fmt.Errorf("forbidden: only the owner may change assignments on a frozen op")
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", op.ID)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
 	link := wasabee.LinkID(vars["link"])
 	agent := wasabee.GoogleID(req.FormValue("agent"))
 	uid, err := op.AssignLink(link, agent)
@@ -539,6 +592,12 @@ func drawMarkerAssignRoute(res http.ResponseWriter, req *http.Request) {
 		http.Error(res, jsonError(err), http.StatusForbidden)
 		return
 	}
+	if op.ID.IsFrozen() && !op.ID.IsOwner(gid) {
+		err = fmt.Errorf("forbidden: only the owner may change assignments on a frozen op")
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", op.ID)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
 
 	marker := wasabee.MarkerID(vars["marker"])
 	agent := wasabee.GoogleID(req.FormValue("agent"))
@@ -782,15 +841,51 @@ func drawPortalHardnessRoute(res http.ResponseWriter, req *http.Request) {
 	var op wasabee.Operation
 	op.ID = wasabee.OperationID(vars["document"])
 
-	if op.WriteAccess(gid) {
+	if !op.WriteAccess(gid) {
 		err = fmt.Errorf("write access required to set portal hardness")
 		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", op.ID)
 		http.Error(res, jsonError(err), http.StatusForbidden)
 		return
 	}
 	portalID := wasabee.PortalID(vars["portal"])
-	hardness := req.FormValue("hardness")
-	uid, err := op.PortalHardness(portalID, hardness)
+
+	// the free-form hardness is still accepted from older clients
+	if req.FormValue("bursters") == "" && req.FormValue("shields") == "" && req.FormValue("linkAmps") == "" && req.FormValue("virus") == "" && req.FormValue("owner") == "" {
+		hardness := req.FormValue("hardness")
+		uid, err := op.PortalHardness(portalID, hardness)
+		if err != nil {
+			wasabee.Log.Error(err)
+			http.Error(res, jsonError(err), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(res, jsonOKUpdateID(uid))
+		return
+	}
+
+	d := wasabee.PortalDefense{
+		Virus:    req.FormValue("virus"),
+		Owner:    req.FormValue("owner"),
+		Observed: req.FormValue("observed"),
+	}
+	for k, v := range map[string]*int{"bursters": &d.Bursters, "shields": &d.Shields, "linkAmps": &d.LinkAmps} {
+		f := req.FormValue(k)
+		if f == "" {
+			continue
+		}
+		if *v, err = strconv.Atoi(f); err != nil {
+			err = fmt.Errorf("%s must be a number", k)
+			wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", op.ID, k, f)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+	}
+	if err := d.Validate(); err != nil {
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", op.ID, "portal", portalID)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	uid, err := op.PortalDefense(portalID, d)
 	if err != nil {
 		wasabee.Log.Error(err)
 		http.Error(res, jsonError(err), http.StatusInternalServerError)
@@ -799,6 +894,40 @@ func drawPortalHardnessRoute(res http.ResponseWriter, req *http.Request) {
 	fmt.Fprint(res, jsonOKUpdateID(uid))
 }
 
+func drawTargetsRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var o wasabee.Operation
+	o.ID = wasabee.OperationID(vars["document"])
+
+	if read, _ := o.ReadAccess(gid); !read {
+		err = fmt.Errorf("read access required to view targets")
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", o.ID)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+	if err := o.Populate(gid); err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	j, err := json.Marshal(o.AttackPlan())
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, string(j))
+}
+
 func drawOrderRoute(res http.ResponseWriter, req *http.Request) {
 	res.Header().Set("Content-Type", jsonType)
 	gid, err := getAgentID(req)
@@ -904,6 +1033,30 @@ func drawPortalKeysRoute(res http.ResponseWriter, req *http.Request) {
 	fmt.Fprint(res, jsonOKUpdateID(uid))
 }
 
+// drawKeyShareStopRoute withdraws the agent's key inventory from the op's key list
+func drawKeyShareStopRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	uid, err := op.StopSharingKeys(gid)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(res, jsonOKUpdateID(uid))
+}
+
 func drawMarkerCompleteRoute(res http.ResponseWriter, req *http.Request) {
 	res.Header().Set("Content-Type", jsonType)
 	gid, err := getAgentID(req)
@@ -1018,6 +1171,252 @@ func drawStatRoute(res http.ResponseWriter, req *http.Request) {
 	fmt.Fprint(res, string(data))
 }
 
+func drawSearchRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	var s wasabee.OpSearch
+	s.Name = req.FormValue("name")
+	s.TeamID = wasabee.TeamID(req.FormValue("team"))
+	s.Sort = req.FormValue("sort")
+	s.Descending = req.FormValue("order") == "desc"
+	s.Assigned = req.FormValue("assigned") == "true"
+	s.Template = req.FormValue("template") == "true"
+
+	if owner := req.FormValue("owner"); owner != "" {
+		s.Owner, err = wasabee.ToGid(owner)
+		if err != nil {
+			wasabee.Log.Error(err)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+	}
+
+	for k, t := range map[string]*time.Time{"after": &s.After, "before": &s.Before} {
+		v := req.FormValue(k)
+		if v == "" {
+			continue
+		}
+		if *t, err = time.Parse(time.RFC3339, v); err != nil {
+			wasabee.Log.Warnw(err.Error(), "GID", gid, k, v)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+	}
+
+	if s.BBox, err = parseBBox(req.FormValue("bbox")); err != nil {
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "bbox", req.FormValue("bbox"))
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	if l := req.FormValue("limit"); l != "" {
+		s.Limit, _ = strconv.Atoi(l)
+	}
+	if o := req.FormValue("offset"); o != "" {
+		s.Offset, _ = strconv.Atoi(o)
+	}
+
+	results, err := gid.SearchOperations(s)
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, string(data))
+}
+
+func drawCloneRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	opID := wasabee.OperationID(vars["document"])
+
+	opts := wasabee.CloneOptions{
+		Name:        req.FormValue("name"),
+		Assignments: req.FormValue("assignments") == "true",
+		Completion:  req.FormValue("completion") == "true",
+		Keys:        req.FormValue("keys") == "true",
+		Teams:       req.FormValue("teams") == "true",
+	}
+
+	newID, err := opID.Clone(gid, opts)
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+	fmt.Fprintf(res, "{\"status\":\"ok\", \"ID\": \"%s\"}", newID)
+}
+
+func drawMergeRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	source := wasabee.OperationID(req.FormValue("source"))
+	if source == "" {
+		err = fmt.Errorf("source op not specified")
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", op.ID)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	report, err := op.Merge(gid, source)
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, string(data))
+}
+
+func drawFreezeRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if err := op.ID.SetFrozen(gid, vars["state"] == "true"); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	uid, err := op.Touch()
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, jsonOKUpdateID(uid))
+}
+
+func drawCoOwnerAddRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	agent := req.FormValue("agent")
+	if agent == "" {
+		err = fmt.Errorf("agent not specified")
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", op.ID)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	if err := op.ID.AddCoOwner(gid, agent); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	uid, err := op.Touch()
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, jsonOKUpdateID(uid))
+}
+
+func drawCoOwnerDeleteRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if err := op.ID.RemoveCoOwner(gid, wasabee.GoogleID(vars["gid"])); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	uid, err := op.Touch()
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, jsonOKUpdateID(uid))
+}
+
+func drawTemplateRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if err := op.ID.SetTemplate(gid, vars["state"] == "true"); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	uid, err := op.Touch()
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, jsonOKUpdateID(uid))
+}
+
 func drawMyRouteRoute(res http.ResponseWriter, req *http.Request) {
 	gid, err := getAgentID(req)
 	if err != nil {
@@ -1151,3 +1550,45 @@ func drawPermsDeleteRoute(res http.ResponseWriter, req *http.Request) {
 func jsonOKUpdateID(uid string) string {
 	return fmt.Sprintf("{\"status\":\"ok\", \"updateID\": \"%s\"}", uid)
 }
+
+// parseBBox reads a "south,west,north,east" bounding box, an empty string is no box
+func parseBBox(bbox string) (*wasabee.BoundingBox, error) {
+	if bbox == "" {
+		return nil, nil
+	}
+
+	c := strings.Split(bbox, ",")
+	if len(c) != 4 {
+		return nil, fmt.Errorf("bbox must be south,west,north,east")
+	}
+	var f [4]float64
+	for i := range c {
+		var err error
+		if f[i], err = strconv.ParseFloat(strings.TrimSpace(c[i]), 64); err != nil {
+			return nil, err
+		}
+	}
+	return &wasabee.BoundingBox{South: f[0], West: f[1], North: f[2], East: f[3]}, nil
+}
+
+// drawStatusRoute sets the agent's own status on the op, an empty status clears it
+func drawStatusRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	uid, err := op.SetStatus(gid, req.FormValue("status"), req.FormValue("note"))
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	fmt.Fprint(res, jsonOKUpdateID(uid))
+}
@@ -0,0 +1,157 @@
+package wasabeehttps
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+// historyRange reads the start and end of a location history query, end defaults to now
+func historyRange(req *http.Request) (time.Time, time.Time, error) {
+	var start, end time.Time
+	var err error
+
+	if start, err = time.Parse(time.RFC3339, req.FormValue("start")); err != nil {
+		return start, end, fmt.Errorf("invalid start time")
+	}
+	if e := req.FormValue("end"); e != "" {
+		if end, err = time.Parse(time.RFC3339, e); err != nil {
+			return start, end, fmt.Errorf("invalid end time")
+		}
+	}
+	return start, end, nil
+}
+
+func meLocationHistoryRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	start, end, err := historyRange(req)
+	if err != nil {
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "start", req.FormValue("start"), "end", req.FormValue("end"))
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	points, err := gid.LocationHistory(start, end)
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	j, _ := json.Marshal(points)
+	fmt.Fprint(res, string(j))
+}
+
+func meLocationHistorySettingsRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	s := wasabee.LocationHistorySettings{
+		Enabled: req.FormValue("enabled") == "true",
+	}
+	if d := req.FormValue("days"); d != "" {
+		days, err := strconv.ParseInt(d, 10, 32)
+		if err != nil {
+			err = fmt.Errorf("invalid retention")
+			wasabee.Log.Warnw(err.Error(), "GID", gid, "days", d)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+		s.RetentionDays = int(days)
+	}
+
+	if err := gid.SetLocationHistory(s); err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func teamLocationHistoryRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	teamID := wasabee.TeamID(vars["team"])
+
+	if inteam, _ := gid.AgentInTeam(teamID); !inteam {
+		err = fmt.Errorf("forbidden: not on the team")
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", teamID)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	start, end, err := historyRange(req)
+	if err != nil {
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "start", req.FormValue("start"), "end", req.FormValue("end"))
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	points, err := teamID.LocationHistory(start, end)
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	j, _ := json.Marshal(points)
+	fmt.Fprint(res, string(j))
+}
+
+func drawLocationHistoryRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if read, _ := op.ReadAccess(gid); !read {
+		err = fmt.Errorf("read access required to view location history")
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", op.ID)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	start, end, err := historyRange(req)
+	if err != nil {
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "start", req.FormValue("start"), "end", req.FormValue("end"))
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	points, err := op.LocationHistory(gid, start, end)
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	j, _ := json.Marshal(points)
+	fmt.Fprint(res, string(j))
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
@@ -106,6 +109,64 @@ func meToggleTeamWDLoadRoute(res http.ResponseWriter, req *http.Request) {
 	fmt.Fprint(res, jsonStatusOK)
 }
 
+func meTeamPrecisionRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Add("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	team := wasabee.TeamID(vars["team"])
+	p, err := strconv.Atoi(vars["precision"])
+	if err != nil {
+		err = fmt.Errorf("invalid location precision")
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", team, "precision", vars["precision"])
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	if err = gid.SetLocationPrecision(team, wasabee.LocationPrecision(p)); err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+// meTeamShareWindowRoute turns on location sharing with a team for a number of hours, until the end of an op, or both
+func meTeamShareWindowRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Add("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	team := wasabee.TeamID(vars["team"])
+
+	var until time.Time
+	if h := req.FormValue("hours"); h != "" {
+		hours, err := strconv.ParseInt(h, 10, 32)
+		if err != nil || hours < 1 {
+			err = fmt.Errorf("invalid number of hours")
+			wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", team, "hours", h)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+		until = time.Now().Add(time.Duration(hours) * time.Hour)
+	}
+
+	if err = gid.ShareLocationUntil(team, until, wasabee.OperationID(req.FormValue("op"))); err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
 func meRemoveTeamRoute(res http.ResponseWriter, req *http.Request) {
 	res.Header().Add("Content-Type", jsonType)
 	gid, err := getAgentID(req)
@@ -280,3 +341,118 @@ func meFirebaseGenTokenRoute(res http.ResponseWriter, req *http.Request) {
 	res.Header().Add("Content-Type", "application/jwt")
 	fmt.Fprint(res, token)
 }
+
+func meKeysRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	keys, err := gid.KeyInventory()
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(keys)
+	fmt.Fprint(res, string(data))
+}
+
+// replace the entire key inventory, e.g. from an inventory export
+func meKeysReplaceRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	if !contentTypeIs(req, jsonTypeShort) {
+		err := fmt.Errorf("JSON required")
+		wasabee.Log.Warnw(err.Error(), "GID", gid)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	jBlob, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	var keys []wasabee.KeyInventoryItem
+	if err := json.Unmarshal(jBlob, &keys); err != nil {
+		wasabee.Log.Warnw(err.Error(), "GID", gid)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	if err := gid.ReplaceKeyInventory(keys); err != nil {
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func meKeySetRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	count, err := strconv.ParseInt(req.FormValue("count"), 10, 32)
+	if err != nil { // user supplied non-numeric value
+		count = 0
+	}
+
+	if err := gid.SetKeyCount(wasabee.PortalID(vars["portal"]), req.FormValue("capsule"), int32(count)); err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func meNearbyRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	var radius float64
+	if r := req.FormValue("radius"); r != "" {
+		radius, _ = strconv.ParseFloat(r, 64)
+	}
+	var limit int
+	if l := req.FormValue("limit"); l != "" {
+		limit, _ = strconv.Atoi(l)
+	}
+	var types []string
+	if t := req.FormValue("type"); t != "" {
+		for _, v := range strings.Split(t, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				types = append(types, v)
+			}
+		}
+	}
+
+	tasks, err := gid.NearbyTasks(radius, types, limit)
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	data, _ := json.Marshal(tasks)
+	fmt.Fprint(res, string(data))
+}
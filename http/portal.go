@@ -0,0 +1,127 @@
+package wasabeehttps
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func portalSearchRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	var s wasabee.PortalSearch
+	s.Name = req.FormValue("name")
+	s.Lat = req.FormValue("lat")
+	s.Lon = req.FormValue("lon")
+
+	// validate the location so garbage does not end up in the query
+	if s.Lat != "" || s.Lon != "" {
+		for _, v := range []string{s.Lat, s.Lon} {
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				err = fmt.Errorf("invalid location")
+				wasabee.Log.Warnw(err.Error(), "GID", gid, "lat", s.Lat, "lon", s.Lon)
+				http.Error(res, jsonError(err), http.StatusNotAcceptable)
+				return
+			}
+		}
+	}
+	if r := req.FormValue("radius"); r != "" {
+		s.Radius, _ = strconv.ParseFloat(r, 64)
+	}
+	if l := req.FormValue("limit"); l != "" {
+		s.Limit, _ = strconv.Atoi(l)
+	}
+
+	portals, err := gid.SearchPortals(s)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	j, err := json.Marshal(portals)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, string(j))
+}
+
+func portalGetRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	p, err := gid.RegistryPortal(wasabee.PortalID(vars["portal"]))
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusNotFound)
+		return
+	}
+
+	j, err := json.Marshal(p)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, string(j))
+}
+
+func portalNoteRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	portalID := wasabee.PortalID(vars["portal"])
+	teamID := wasabee.TeamID(req.FormValue("team"))
+	if teamID == "" {
+		err = fmt.Errorf("team required")
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "portal", portalID)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	if err := gid.SetPortalNote(portalID, teamID, req.FormValue("comment"), req.FormValue("hardness")); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func portalNoteDeleteRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	if err := gid.DeletePortalNote(wasabee.PortalID(vars["portal"]), wasabee.TeamID(vars["team"])); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
@@ -75,6 +75,13 @@ func setupRouter() *mux.Router {
 	rocks.MethodNotAllowedHandler = http.HandlerFunc(notFoundJSONRoute)
 	rocks.PathPrefix("/rocks").HandlerFunc(notFoundJSONRoute)
 
+	// /share route -- public, read-only op access; the token is the authorization
+	share := wasabee.Subrouter("/share")
+	share.HandleFunc("/draw/{document}/{token}", shareGetRoute).Methods("GET", "HEAD")
+	share.NotFoundHandler = http.HandlerFunc(notFoundJSONRoute)
+	share.MethodNotAllowedHandler = http.HandlerFunc(notFoundJSONRoute)
+	share.PathPrefix("/share").HandlerFunc(notFoundJSONRoute)
+
 	// /static files
 	static := wasabee.Subrouter("/static")
 	static.PathPrefix("/").Handler(http.FileServer(http.Dir(config.FrontendPath)))
@@ -90,22 +97,46 @@ func setupRouter() *mux.Router {
 func setupAuthRoutes(r *mux.Router) {
 	// This block requires authentication
 	r.HandleFunc("/draw", drawUploadRoute).Methods("POST")
+	r.HandleFunc("/draw", drawSearchRoute).Methods("GET")
 	r.HandleFunc("/draw/{document}", drawGetRoute).Methods("GET", "HEAD")
 	r.HandleFunc("/draw/{document}", drawDeleteRoute).Methods("DELETE")
 	r.HandleFunc("/draw/{document}", drawUpdateRoute).Methods("PUT")
 	r.HandleFunc("/draw/{document}/delete", drawDeleteRoute).Methods("GET", "DELETE")
 	r.HandleFunc("/draw/{document}/chown", drawChownRoute).Methods("GET").Queries("to", "{to}")
+	r.HandleFunc("/draw/{document}/chown/accept", drawChownAcceptRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/chown/cancel", drawChownCancelRoute).Methods("GET")
 	r.HandleFunc("/draw/{document}/stock", drawStockRoute).Methods("GET")
 	r.HandleFunc("/draw/{document}/order", drawOrderRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/info", drawInfoRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/stat", drawStatRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/targets", drawTargetsRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/history", drawLocationHistoryRoute).Methods("GET")
 	// r.HandleFunc("/draw/{document}/perms", drawPermsRoute).Methods("GET")
 	r.HandleFunc("/draw/{document}/perms", drawPermsAddRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/perms", drawPermsDeleteRoute).Methods("DELETE")
 	r.HandleFunc("/draw/{document}/delperm", drawPermsDeleteRoute).Methods("GET") // .Queries("team", "{team}", "role", "{role}")
 	r.HandleFunc("/draw/{document}/myroute", drawMyRouteRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/clone", drawCloneRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/merge", drawMergeRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/template", drawTemplateRoute).Methods("GET").Queries("state", "{state}")
+	r.HandleFunc("/draw/{document}/freeze", drawFreezeRoute).Methods("GET").Queries("state", "{state}")
+	r.HandleFunc("/draw/{document}/coowner", drawCoOwnerAddRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/coowner/{gid}", drawCoOwnerDeleteRoute).Methods("DELETE")
+	r.HandleFunc("/draw/{document}/claimpolicy", drawClaimPolicyRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/claimpolicy", drawClaimPolicySetRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/claims", drawClaimsRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/arrivalpolicy", drawArrivalPolicyRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/arrivalpolicy", drawArrivalPolicySetRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/arrivals", drawArrivalsRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/status", drawStatusRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/claim/{kind}/{task}", drawClaimRevokeRoute).Methods("DELETE")
+	r.HandleFunc("/draw/{document}/keys", drawKeyShareStopRoute).Methods("DELETE")
+	r.HandleFunc("/draw/{document}/share", drawShareListRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/share", drawShareNewRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/share/{token}", drawShareRevokeRoute).Methods("DELETE")
 	r.HandleFunc("/draw/{document}/link/{link}", drawLinkFetch).Methods("GET")
 	r.HandleFunc("/draw/{document}/link/{link}/assign", drawLinkAssignRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/link/{link}/claim", drawLinkClaimRoute).Methods("GET")
 	r.HandleFunc("/draw/{document}/link/{link}/color", drawLinkColorRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/link/{link}/desc", drawLinkDescRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/link/{link}/complete", drawLinkCompleteRoute).Methods("GET")
@@ -114,6 +145,7 @@ func setupAuthRoutes(r *mux.Router) {
 	r.HandleFunc("/draw/{document}/link/{link}/zone", drawLinkZoneRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/marker/{marker}", drawMarkerFetch).Methods("GET")
 	r.HandleFunc("/draw/{document}/marker/{marker}/assign", drawMarkerAssignRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/marker/{marker}/claim", drawMarkerClaimRoute).Methods("GET")
 	r.HandleFunc("/draw/{document}/marker/{marker}/comment", drawMarkerCommentRoute).Methods("POST")
 	// agent acknowledge the assignment
 	r.HandleFunc("/draw/{document}/marker/{marker}/acknowledge", drawMarkerAcknowledgeRoute).Methods("GET")
@@ -127,6 +159,8 @@ func setupAuthRoutes(r *mux.Router) {
 	r.HandleFunc("/draw/{document}/portal/{portal}/comment", drawPortalCommentRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/portal/{portal}/hardness", drawPortalHardnessRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/portal/{portal}/keyonhand", drawPortalKeysRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/portal/{portal}/keyrequest", drawKeyRequestRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/keyrequests", drawKeyRequestsRoute).Methods("GET")
 
 	// manual location post
 	r.HandleFunc("/me", meSetAgentLocationRoute).Methods("GET").Queries("lat", "{lat}", "lon", "{lon}")
@@ -136,12 +170,20 @@ func setupAuthRoutes(r *mux.Router) {
 	// toggle RAID/JEAH polling
 	// r.HandleFunc("/me/settings", meSettingsRoute).Methods("GET")
 	// r.HandleFunc("/me/operations", meOperationsRoute).Methods("GET")
+	r.HandleFunc("/me/nearby", meNearbyRoute).Methods("GET")
+	r.HandleFunc("/me/keys", meKeysRoute).Methods("GET")
+	r.HandleFunc("/me/keys", meKeysReplaceRoute).Methods("PUT")
+	r.HandleFunc("/me/keys/{portal}", meKeySetRoute).Methods("POST")
+	r.HandleFunc("/me/history", meLocationHistoryRoute).Methods("GET")
+	r.HandleFunc("/me/history", meLocationHistorySettingsRoute).Methods("POST")
 	r.HandleFunc("/me/statuslocation", meStatusLocationRoute).Methods("GET").Queries("sl", "{sl}")
 	r.HandleFunc("/me/{team}", meToggleTeamRoute).Methods("GET").Queries("state", "{state}")
 	r.HandleFunc("/me/{team}", meRemoveTeamRoute).Methods("DELETE")
 	r.HandleFunc("/me/{team}/delete", meRemoveTeamRoute).Methods("GET")
 	r.HandleFunc("/me/{team}/wdshare", meToggleTeamWDShareRoute).Methods("GET").Queries("state", "{state}")
 	r.HandleFunc("/me/{team}/wdload", meToggleTeamWDLoadRoute).Methods("GET").Queries("state", "{state}")
+	r.HandleFunc("/me/{team}/precision", meTeamPrecisionRoute).Methods("GET").Queries("p", "{precision}")
+	r.HandleFunc("/me/{team}/sharewindow", meTeamShareWindowRoute).Methods("POST")
 	r.HandleFunc("/me/logout", meLogoutRoute).Methods("GET")
 	r.HandleFunc("/me/firebase", meFirebaseRoute).Methods("POST")        // post a token generated by google
 	r.HandleFunc("/me/firebase", meFirebaseGenTokenRoute).Methods("GET") // generate a custom token
@@ -163,9 +205,21 @@ func setupAuthRoutes(r *mux.Router) {
 	r.HandleFunc("/team/{team}", deleteTeamRoute).Methods("DELETE")
 	r.HandleFunc("/team/{team}/delete", deleteTeamRoute).Methods("GET", "DELETE")
 	r.HandleFunc("/team/{team}/chown", chownTeamRoute).Methods("GET").Queries("to", "{to}")
+	r.HandleFunc("/team/{team}/chown/accept", chownTeamAcceptRoute).Methods("GET")
+	r.HandleFunc("/team/{team}/chown/cancel", chownTeamCancelRoute).Methods("GET")
 	r.HandleFunc("/team/{team}/join/{key}", joinLinkRoute).Methods("GET")
 	r.HandleFunc("/team/{team}/genJoinKey", genJoinKeyRoute).Methods("GET")
 	r.HandleFunc("/team/{team}/delJoinKey", delJoinKeyRoute).Methods("GET")
+	r.HandleFunc("/team/{team}/joinlink", newJoinLinkRoute).Methods("POST")
+	r.HandleFunc("/team/{team}/joinlinks", joinLinksRoute).Methods("GET")
+	r.HandleFunc("/team/{team}/joinlink/{token}", revokeJoinLinkRoute).Methods("DELETE")
+	r.HandleFunc("/team/{team}/roster", exportRosterRoute).Methods("GET")
+	r.HandleFunc("/team/{team}/roster", importRosterRoute).Methods("POST")
+	r.HandleFunc("/team/{team}/policy", teamPolicyRoute).Methods("POST")
+	r.HandleFunc("/team/{team}/parent", teamParentRoute).Methods("POST")
+	r.HandleFunc("/team/{team}/history", teamLocationHistoryRoute).Methods("GET")
+	r.HandleFunc("/team/{team}/joinapproval", joinApprovalTeamRoute).Methods("GET").Queries("state", "{state}")
+	r.HandleFunc("/team/{team}/joinrequests", joinRequestsTeamRoute).Methods("GET")
 	// GUI to do basic edit (owner)
 	// r.HandleFunc("/team/{team}/edit", editTeamRoute).Methods("GET")
 	// (re)import the team from rocks
@@ -180,14 +234,37 @@ func setupAuthRoutes(r *mux.Router) {
 	r.HandleFunc("/team/{team}/{key}", addAgentToTeamRoute).Methods("GET", "POST")
 	r.HandleFunc("/team/{team}/{gid}/squad", setAgentTeamSquadRoute).Methods("POST")
 	r.HandleFunc("/team/{team}/{gid}/displayname", setAgentTeamDisplaynameRoute).Methods("POST")
+	r.HandleFunc("/team/{team}/{gid}/role", setAgentTeamRoleRoute).Methods("POST")
 	r.HandleFunc("/team/{team}/{key}/delete", delAgentFmTeamRoute).Methods("GET")
 	r.HandleFunc("/team/{team}/{key}", delAgentFmTeamRoute).Methods("DELETE")
 
 	r.HandleFunc("/d", getDefensiveKeys).Methods("GET")
 	r.HandleFunc("/d", setDefensiveKey).Methods("POST")
 	r.HandleFunc("/d/bulk", setDefensiveKeyBulk).Methods("POST")
+	r.HandleFunc("/d/portals", getDefensiveKeysByPortal).Methods("GET")
+	r.HandleFunc("/d/alert", defenseAlertsRoute).Methods("GET")
+	r.HandleFunc("/d/alert", defenseAlertNewRoute).Methods("POST")
+	r.HandleFunc("/d/alert/{alert}", defenseAlertCloseRoute).Methods("DELETE")
+	r.HandleFunc("/d/alert/{alert}/claim", defenseAlertClaimRoute).Methods("GET")
+	r.HandleFunc("/d/alert/{alert}/decline", defenseAlertDeclineRoute).Methods("GET")
 	r.HandleFunc("/loc", getAgentsLocation).Methods("GET")
 
+	// team join requests
+	r.HandleFunc("/joinrequest/{request}/approve", joinRequestApproveRoute).Methods("GET")
+	r.HandleFunc("/joinrequest/{request}/deny", joinRequestDenyRoute).Methods("GET")
+
+	// key request board
+	r.HandleFunc("/keyrequest/{request}", keyRequestCancelRoute).Methods("DELETE")
+	r.HandleFunc("/keyrequest/{request}/pledge", keyPledgeRoute).Methods("POST")
+	r.HandleFunc("/keyrequest/{request}/pledge", keyPledgeWithdrawRoute).Methods("DELETE")
+	r.HandleFunc("/keyrequest/{request}/deliver", keyDeliverRoute).Methods("GET")
+
+	// server-wide portal registry
+	r.HandleFunc("/portal", portalSearchRoute).Methods("GET")
+	r.HandleFunc("/portal/{portal}", portalGetRoute).Methods("GET")
+	r.HandleFunc("/portal/{portal}/note", portalNoteRoute).Methods("POST")
+	r.HandleFunc("/portal/{portal}/note/{team}", portalNoteDeleteRoute).Methods("DELETE")
+
 	// server control functions
 	// trigger the server refresh of the template files
 	r.HandleFunc("/templates/refresh", templateUpdateRoute).Methods("GET")
@@ -49,6 +49,7 @@ var config Configuration
 
 const jsonType = "application/json; charset=UTF-8"
 const jsonTypeShort = "application/json"
+const csvTypeShort = "text/csv"
 const jsonStatusOK = `{"status":"ok"}`
 const jsonStatusEmpty = `{"status":"error","error":"Empty JSON"}`
 const me = "/me"
@@ -0,0 +1,147 @@
+package wasabeehttps
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+// no authentication: the token is the authorization
+func shareGetRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	vars := mux.Vars(req)
+
+	var o wasabee.Operation
+	o.ID = wasabee.OperationID(vars["document"])
+	token := wasabee.ShareToken(vars["token"])
+
+	if err := o.PopulateShared(token, req.RemoteAddr); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	lastModified, err := time.ParseInLocation("2006-01-02 15:04:05", o.Modified, time.UTC)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Last-Modified", lastModified.Format(time.RFC1123))
+	res.Header().Set("Cache-Control", "no-store")
+
+	s, err := json.Marshal(o)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, string(s))
+}
+
+func drawShareListRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	opID := wasabee.OperationID(vars["document"])
+
+	shares, err := opID.Shares(gid)
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	data, err := json.Marshal(shares)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, string(data))
+}
+
+func drawShareNewRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	opID := wasabee.OperationID(vars["document"])
+
+	if !opID.IsOwner(gid) {
+		err = fmt.Errorf("permission to share op denied")
+		wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	// unlike team permissions, a share defaults to the whole op
+	zone := wasabee.ZoneAll
+	if z := req.FormValue("zone"); z != "" {
+		zone = wasabee.ZoneFromString(z)
+	}
+
+	// hours until expiration, 0 or unset does not expire
+	var duration time.Duration
+	if h := req.FormValue("hours"); h != "" {
+		hours, err := strconv.ParseInt(h, 10, 32)
+		if err != nil || hours < 0 {
+			err = fmt.Errorf("invalid expiration")
+			wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", opID, "hours", h)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+		duration = time.Duration(hours) * time.Hour
+	}
+
+	share, err := opID.NewShare(gid, zone, duration)
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(share)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, string(data))
+}
+
+func drawShareRevokeRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	opID := wasabee.OperationID(vars["document"])
+	token := wasabee.ShareToken(vars["token"])
+
+	if err := opID.RevokeShare(gid, token); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
@@ -7,7 +7,10 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/wasabee-project/Wasabee-Server"
 	"html"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func getTeamRoute(res http.ResponseWriter, req *http.Request) {
@@ -24,20 +27,13 @@ func getTeamRoute(res http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	team := wasabee.TeamID(vars["team"])
 
-	isowner, err := gid.OwnsTeam(team)
+	role, err := gid.TeamRole(team)
 	if err != nil {
 		wasabee.Log.Error(err)
 		http.Error(res, jsonError(err), http.StatusInternalServerError)
 		return
 	}
-
-	onteam, err := gid.AgentInTeam(team)
-	if err != nil {
-		wasabee.Log.Error(err)
-		http.Error(res, jsonError(err), http.StatusInternalServerError)
-		return
-	}
-	if !isowner && !onteam {
+	if role == "" {
 		err := fmt.Errorf("not on team")
 		wasabee.Log.Infow(err.Error(), "teamID", team, "GID", gid.String(), "message", err.Error())
 		http.Error(res, jsonError(err), http.StatusForbidden)
@@ -56,10 +52,16 @@ func getTeamRoute(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if !isowner {
+	if role != wasabee.TeamRoleOwner {
 		teamList.RocksComm = ""
 		teamList.RocksKey = ""
-		teamList.JoinLinkToken = ""
+		if role != wasabee.TeamRoleAdmin {
+			teamList.JoinLinkToken = ""
+			teamList.JoinRequests = nil
+		}
+		if teamList.PendingOwner != nil && teamList.PendingOwner.To != gid {
+			teamList.PendingOwner = nil
+		}
 	}
 
 	data, _ := json.Marshal(teamList)
@@ -170,6 +172,42 @@ func chownTeamRoute(res http.ResponseWriter, req *http.Request) {
 	fmt.Fprint(res, jsonStatusOK)
 }
 
+func chownTeamAcceptRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Add("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	team := wasabee.TeamID(vars["team"])
+	if err = team.AcceptChown(gid); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func chownTeamCancelRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Add("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	team := wasabee.TeamID(vars["team"])
+	if err = team.CancelChown(gid); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
 func addAgentToTeamRoute(res http.ResponseWriter, req *http.Request) {
 	res.Header().Add("Content-Type", jsonType)
 	gid, err := getAgentID(req)
@@ -183,7 +221,7 @@ func addAgentToTeamRoute(res http.ResponseWriter, req *http.Request) {
 	team := wasabee.TeamID(vars["team"])
 	key := vars["key"]
 
-	safe, err := gid.OwnsTeam(team)
+	safe, err := gid.CanManageTeam(team)
 	if err != nil {
 		wasabee.Log.Error(err)
 		http.Error(res, jsonError(err), http.StatusInternalServerError)
@@ -208,8 +246,7 @@ func addAgentToTeamRoute(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 		if err = team.AddAgent(togid); err != nil {
-			wasabee.Log.Error(err)
-			http.Error(res, jsonError(err), http.StatusInternalServerError)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
 			return
 		}
 	}
@@ -233,20 +270,32 @@ func delAgentFmTeamRoute(res http.ResponseWriter, req *http.Request) {
 		http.Error(res, jsonError(err), http.StatusInternalServerError)
 		return
 	}
-	safe, err := gid.OwnsTeam(team)
+	role, err := gid.TeamRole(team)
 	if err != nil {
 		wasabee.Log.Error(err)
 		http.Error(res, jsonError(err), http.StatusInternalServerError)
 		return
 	}
-	if gid == togid {
+	if role != wasabee.TeamRoleOwner && role != wasabee.TeamRoleAdmin {
+		err := fmt.Errorf("forbidden")
+		wasabee.Log.Warnw(err.Error(), "resource", team, "GID", gid)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+	torole, err := togid.TeamRole(team)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	if torole == wasabee.TeamRoleOwner {
 		err := fmt.Errorf("cannot remove owner")
 		wasabee.Log.Warnw(err.Error(), "resource", team, "GID", gid)
 		http.Error(res, jsonError(err), http.StatusForbidden)
 		return
 	}
-	if !safe {
-		err := fmt.Errorf("forbidden")
+	if torole == wasabee.TeamRoleAdmin && role != wasabee.TeamRoleOwner && togid != gid {
+		err := fmt.Errorf("forbidden: only the team owner can remove admins")
 		wasabee.Log.Warnw(err.Error(), "resource", team, "GID", gid)
 		http.Error(res, jsonError(err), http.StatusForbidden)
 		return
@@ -270,14 +319,14 @@ func announceTeamRoute(res http.ResponseWriter, req *http.Request) {
 
 	vars := mux.Vars(req)
 	team := wasabee.TeamID(vars["team"])
-	safe, err := gid.OwnsTeam(team)
+	safe, err := gid.CanManageTeam(team)
 	if err != nil {
 		wasabee.Log.Error(err)
 		http.Error(res, jsonError(err), http.StatusInternalServerError)
 		return
 	}
 	if !safe {
-		err := fmt.Errorf("forbidden: only team owners can send announcements")
+		err := fmt.Errorf("forbidden: only team owners and admins can send announcements")
 		wasabee.Log.Warnw(err.Error(), "resource", team, "GID", gid)
 		http.Error(res, jsonError(err), http.StatusForbidden)
 		return
@@ -309,8 +358,8 @@ func setAgentTeamSquadRoute(res http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	teamID := wasabee.TeamID(vars["team"])
 
-	if owns, _ := gid.OwnsTeam(teamID); !owns {
-		err = fmt.Errorf("forbidden: only the team owner can set squads")
+	if manages, _ := gid.CanManageTeam(teamID); !manages {
+		err = fmt.Errorf("forbidden: only team owners and admins can set squads")
 		wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
 		http.Error(res, jsonError(err), http.StatusForbidden)
 		return
@@ -340,8 +389,8 @@ func setAgentTeamDisplaynameRoute(res http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	teamID := wasabee.TeamID(vars["team"])
 
-	if owns, _ := gid.OwnsTeam(teamID); !owns {
-		err = fmt.Errorf("forbidden: only the team owner can set display names")
+	if manages, _ := gid.CanManageTeam(teamID); !manages {
+		err = fmt.Errorf("forbidden: only team owners and admins can set display names")
 		wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
 		http.Error(res, jsonError(err), http.StatusForbidden)
 		return
@@ -358,6 +407,35 @@ func setAgentTeamDisplaynameRoute(res http.ResponseWriter, req *http.Request) {
 	fmt.Fprint(res, jsonStatusOK)
 }
 
+func setAgentTeamRoleRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	teamID := wasabee.TeamID(vars["team"])
+
+	if owns, _ := gid.OwnsTeam(teamID); !owns {
+		err = fmt.Errorf("forbidden: only the team owner can set roles")
+		wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	inGid := wasabee.GoogleID(vars["gid"])
+	role := wasabee.TeamRole(req.FormValue("role"))
+	if err = teamID.SetRole(inGid, role); err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
 func renameTeamRoute(res http.ResponseWriter, req *http.Request) {
 	res.Header().Set("Content-Type", jsonType)
 
@@ -406,7 +484,7 @@ func genJoinKeyRoute(res http.ResponseWriter, req *http.Request) {
 	teamID := wasabee.TeamID(vars["team"])
 
 	var key string
-	if owns, _ := gid.OwnsTeam(teamID); owns {
+	if manages, _ := gid.CanManageTeam(teamID); manages {
 		key, err = teamID.GenerateJoinToken()
 		if err != nil {
 			wasabee.Log.Error(err)
@@ -414,7 +492,7 @@ func genJoinKeyRoute(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 	} else {
-		err = fmt.Errorf("forbidden: only the team owner can create join links")
+		err = fmt.Errorf("forbidden: only team owners and admins can create join links")
 		wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
 		http.Error(res, jsonError(err), http.StatusForbidden)
 		return
@@ -446,7 +524,7 @@ func delJoinKeyRoute(res http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	teamID := wasabee.TeamID(vars["team"])
 
-	if owns, _ := gid.OwnsTeam(teamID); owns {
+	if manages, _ := gid.CanManageTeam(teamID); manages {
 		err := teamID.DeleteJoinToken()
 		if err != nil {
 			wasabee.Log.Error(err)
@@ -454,14 +532,350 @@ func delJoinKeyRoute(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 	} else {
-		err = fmt.Errorf("forbidden: only the team owner can remove join links")
+		err = fmt.Errorf("forbidden: only team owners and admins can remove join links")
+		wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func joinApprovalTeamRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	teamID := wasabee.TeamID(vars["team"])
+
+	if owns, _ := gid.OwnsTeam(teamID); !owns {
+		err = fmt.Errorf("forbidden: only the team owner can require join approval")
 		wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
 		http.Error(res, jsonError(err), http.StatusForbidden)
 		return
 	}
+
+	if err := teamID.SetJoinApproval(vars["state"] == "true"); err != nil {
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
 	fmt.Fprint(res, jsonStatusOK)
 }
 
+func joinRequestsTeamRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	teamID := wasabee.TeamID(vars["team"])
+
+	if manages, _ := gid.CanManageTeam(teamID); !manages {
+		err = fmt.Errorf("forbidden: only team owners and admins can view join requests")
+		wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	requests, err := teamID.JoinRequests()
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(requests)
+	fmt.Fprint(res, string(data))
+}
+
+func joinRequestApproveRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	if err := gid.ApproveJoinRequest(vars["request"]); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func joinRequestDenyRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	if err := gid.DenyJoinRequest(vars["request"]); err != nil {
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func newJoinLinkRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	teamID := wasabee.TeamID(vars["team"])
+
+	if manages, _ := gid.CanManageTeam(teamID); !manages {
+		err = fmt.Errorf("forbidden: only team owners and admins can create join links")
+		wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	// hours until expiration, 0 or unset does not expire
+	var duration time.Duration
+	if h := req.FormValue("hours"); h != "" {
+		hours, err := strconv.ParseInt(h, 10, 32)
+		if err != nil || hours < 0 {
+			err = fmt.Errorf("invalid expiration")
+			wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", teamID, "hours", h)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+		duration = time.Duration(hours) * time.Hour
+	}
+
+	// maximum number of agents who may join, 0 or unset is unlimited
+	var uses int64
+	if u := req.FormValue("uses"); u != "" {
+		uses, err = strconv.ParseInt(u, 10, 32)
+		if err != nil || uses < 0 {
+			err = fmt.Errorf("invalid use count")
+			wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", teamID, "uses", u)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+	}
+
+	link, err := teamID.NewJoinLink(gid, duration, int(uses), req.FormValue("squad"), req.FormValue("on") == "true")
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(link)
+	fmt.Fprint(res, string(data))
+}
+
+func joinLinksRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	teamID := wasabee.TeamID(vars["team"])
+
+	if manages, _ := gid.CanManageTeam(teamID); !manages {
+		err = fmt.Errorf("forbidden: only team owners and admins can view join links")
+		wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	links, err := teamID.JoinLinks()
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(links)
+	fmt.Fprint(res, string(data))
+}
+
+func revokeJoinLinkRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	teamID := wasabee.TeamID(vars["team"])
+
+	if manages, _ := gid.CanManageTeam(teamID); !manages {
+		err = fmt.Errorf("forbidden: only team owners and admins can remove join links")
+		wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	if err := teamID.RevokeJoinLink(vars["token"]); err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func teamPolicyRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	teamID := wasabee.TeamID(vars["team"])
+
+	if owns, _ := gid.OwnsTeam(teamID); !owns {
+		err = fmt.Errorf("forbidden: only the team owner can set the membership policy")
+		wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	p := wasabee.TeamPolicy{
+		RequireV:          req.FormValue("requireV") == "true",
+		RequireRocks:      req.FormValue("requireRocks") == "true",
+		RefuseBlacklisted: req.FormValue("refuseBlacklisted") == "true",
+	}
+	if l := req.FormValue("minLevel"); l != "" {
+		level, err := strconv.ParseInt(l, 10, 32)
+		if err != nil {
+			err = fmt.Errorf("invalid minimum level")
+			wasabee.Log.Warnw(err.Error(), "GID", gid, "resource", teamID, "minLevel", l)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+		p.MinLevel = int(level)
+	}
+
+	if err := teamID.SetPolicy(p); err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func importRosterRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	teamID := wasabee.TeamID(vars["team"])
+
+	if manages, _ := gid.CanManageTeam(teamID); !manages {
+		err = fmt.Errorf("forbidden: only team owners and admins can import a roster")
+		wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	var roster []wasabee.RosterEntry
+	switch {
+	case contentTypeIs(req, csvTypeShort):
+		if roster, err = wasabee.ReadRosterCSV(req.Body); err != nil {
+			wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+	case contentTypeIs(req, jsonTypeShort):
+		jBlob, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			wasabee.Log.Error(err)
+			http.Error(res, jsonError(err), http.StatusInternalServerError)
+			return
+		}
+		if string(jBlob) == "" {
+			err := fmt.Errorf("empty JSON for roster import")
+			wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+			http.Error(res, jsonStatusEmpty, http.StatusNotAcceptable)
+			return
+		}
+		jRaw := json.RawMessage(jBlob)
+		if err = json.Unmarshal(jRaw, &roster); err != nil {
+			wasabee.Log.Errorw(err.Error(), "GID", gid, "content", jRaw)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+	default:
+		err = fmt.Errorf("roster must be %s or %s", csvTypeShort, jsonTypeShort)
+		wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	result := teamID.ImportRoster(roster)
+	data, _ := json.Marshal(result)
+	fmt.Fprint(res, string(data))
+}
+
+func exportRosterRoute(res http.ResponseWriter, req *http.Request) {
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	teamID := wasabee.TeamID(vars["team"])
+
+	if manages, _ := gid.CanManageTeam(teamID); !manages {
+		err = fmt.Errorf("forbidden: only team owners and admins can export the roster")
+		wasabee.Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+		http.Error(res, jsonError(err), http.StatusForbidden)
+		return
+	}
+
+	roster, err := teamID.Roster()
+	if err != nil {
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	if req.FormValue("format") == "csv" {
+		res.Header().Set("Content-Type", csvTypeShort)
+		res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.csv\"", teamID))
+		if err := wasabee.WriteRosterCSV(res, roster); err != nil {
+			wasabee.Log.Error(err)
+		}
+		return
+	}
+
+	res.Header().Set("Content-Type", jsonType)
+	data, _ := json.Marshal(roster)
+	fmt.Fprint(res, string(data))
+}
+
 func joinLinkRoute(res http.ResponseWriter, req *http.Request) {
 	// redirects to the app interface for the user to manage the team
 	gid, err := getAgentID(req)
@@ -502,3 +916,25 @@ func getAgentsLocation(res http.ResponseWriter, req *http.Request) {
 
 	fmt.Fprint(res, list)
 }
+
+// teamParentRoute nests a team beneath a parent team, or makes it top-level again if no parent is given
+func teamParentRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	teamID := wasabee.TeamID(vars["team"])
+	parent := wasabee.TeamID(req.FormValue("parent"))
+
+	// ownership of the team and the parent is checked in SetParent
+	if err := teamID.SetParent(gid, parent); err != nil {
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
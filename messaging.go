@@ -54,9 +54,10 @@ func (gid GoogleID) sendMessageVia(message, bus string) (bool, error) {
 
 // CanSendTo checks to see if a message is permitted to be sent between these users
 func (gid GoogleID) CanSendTo(to GoogleID) bool {
-	// sender must own at least one team on which the receiver is enabled
+	// sender must own or be an admin of at least one team on which the receiver is enabled
 	var count int
-	if err := db.QueryRow("SELECT COUNT(x.gid) FROM agentteams=x, team=t WHERE t.teamID = x.teamID AND t.owner = ? AND x.state != 'Off' AND x.gid = ?", gid, to).Scan(&count); err != nil {
+	if err := db.QueryRow("SELECT COUNT(x.gid) FROM agentteams=x, team=t WHERE t.teamID = x.teamID AND x.state != 'Off' AND x.suspended = 0 AND x.gid = ? "+
+		"AND (t.owner = ? OR EXISTS (SELECT 1 FROM agentteams=a WHERE a.teamID = t.teamID AND a.gid = ? AND a.role = 'admin' AND a.suspended = 0))", to, gid, gid).Scan(&count); err != nil {
 		Log.Error(err)
 		return false
 	}
@@ -68,7 +69,7 @@ func (gid GoogleID) CanSendTo(to GoogleID) bool {
 
 // SendAnnounce sends a message to everyone on the team, determining what is the best route per agent
 func (teamID TeamID) SendAnnounce(sender GoogleID, message string) error {
-	if owns, _ := sender.OwnsTeam(teamID); !owns {
+	if manages, _ := sender.CanManageTeam(teamID); !manages {
 		err := fmt.Errorf("permission denied: %s sending to team %s", sender, teamID)
 		Log.Error(err)
 		return err
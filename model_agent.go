@@ -43,11 +43,14 @@ type AgentData struct {
 	RISC          bool
 	ProfileImage  string
 	// XXX owned teams needs to go away, merge into teams
-	OwnedTeams  []AdTeam
-	Teams       []AdTeam
-	Ops         []AdOperation
-	Assignments []Assignment
-	Telegram    struct {
+	OwnedTeams      []AdTeam
+	Teams           []AdTeam
+	Ops             []AdOperation
+	Assignments     []Assignment
+	Transfers       []PendingTransfer
+	KeyRequests     []KeyRequest
+	LocationHistory LocationHistorySettings
+	Telegram        struct {
 		ID        int64
 		Verified  bool
 		Authtoken string
@@ -65,15 +68,20 @@ type AdTeam struct {
 	ShareWD       string
 	LoadWD        string
 	Owner         GoogleID
+	Role          TeamRole
+	Precision     LocationPrecision
+	ShareUntil    string
+	ShareOp       OperationID
 }
 
 // AdOperation is a sub-struct of AgentData
 type AdOperation struct {
-	ID      OperationID
-	Name    string
-	IsOwner bool
-	Color   string
-	TeamID  TeamID
+	ID        OperationID
+	Name      string
+	IsOwner   bool
+	IsCoOwner bool
+	Color     string
+	TeamID    TeamID
 }
 
 // AgentID is anything that can be converted to a GoogleID or a string
@@ -174,6 +182,8 @@ func (gid GoogleID) InitAgent() (bool, error) {
 	}
 
 	if authError {
+		// the refreshed V and enl.rocks status may now fall short of team policies
+		gid.enforceTeamPolicies()
 		return false, fmt.Errorf("access denied")
 	}
 
@@ -224,6 +234,8 @@ func (gid GoogleID) InitAgent() (bool, error) {
 		Log.Warnw(err.Error(), "GID", gid.String())
 		return false, err
 	}
+
+	gid.enforceTeamPolicies()
 	return true, nil
 }
 
@@ -237,7 +249,7 @@ func (gid GoogleID) GetAgentData(ad *AgentData) error {
 	ad.GoogleID = gid
 	var vid, lk, pic sql.NullString
 
-	err := db.QueryRow("SELECT a.iname, a.level, a.lockey, a.VVerified, a.VBlacklisted, a.Vid, a.RocksVerified, a.RAID, a.RISC, e.picurl FROM agent=a LEFT JOIN agentextras=e ON a.gid = e.gid WHERE a.gid = ?", gid).Scan(&ad.IngressName, &ad.Level, &lk, &ad.VVerified, &ad.VBlacklisted, &vid, &ad.RocksVerified, &ad.RAID, &ad.RISC, &pic)
+	err := db.QueryRow("SELECT a.iname, a.level, a.lockey, a.VVerified, a.VBlacklisted, a.Vid, a.RocksVerified, a.RAID, a.RISC, a.lochistory, a.lochistorydays, e.picurl FROM agent=a LEFT JOIN agentextras=e ON a.gid = e.gid WHERE a.gid = ?", gid).Scan(&ad.IngressName, &ad.Level, &lk, &ad.VVerified, &ad.VBlacklisted, &vid, &ad.RocksVerified, &ad.RAID, &ad.RISC, &ad.LocationHistory.Enabled, &ad.LocationHistory.RetentionDays, &pic)
 	if err != nil && err == sql.ErrNoRows {
 		err = fmt.Errorf("unknown GoogleID: %s", gid)
 		return err
@@ -279,21 +291,29 @@ func (gid GoogleID) GetAgentData(ad *AgentData) error {
 		return err
 	}
 
+	if ad.Transfers, err = gid.PendingTransfers(); err != nil {
+		return err
+	}
+
+	if ad.KeyRequests, err = gid.KeyRequests(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (gid GoogleID) adTeams(ad *AgentData) error {
-	rows, err := db.Query("SELECT t.teamID, t.name, x.state, x.shareWD, x.loadWD, t.rockscomm, t.rockskey, t.owner, t.joinLinkToken FROM team=t, agentteams=x WHERE x.gid = ? AND x.teamID = t.teamID ORDER BY t.name", gid)
+	rows, err := db.Query("SELECT t.teamID, t.name, x.state, x.shareWD, x.loadWD, t.rockscomm, t.rockskey, t.owner, t.joinLinkToken, x.role, x.locprecision, x.shareuntil, x.shareop FROM team=t, agentteams=x WHERE x.gid = ? AND x.teamID = t.teamID ORDER BY t.name", gid)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
 
-	var rc, rk, jlt sql.NullString
+	var rc, rk, jlt, until, shareop sql.NullString
 	var adteam AdTeam
 	defer rows.Close()
 	for rows.Next() {
-		err := rows.Scan(&adteam.ID, &adteam.Name, &adteam.State, &adteam.ShareWD, &adteam.LoadWD, &rc, &rk, &adteam.Owner, &jlt)
+		err := rows.Scan(&adteam.ID, &adteam.Name, &adteam.State, &adteam.ShareWD, &adteam.LoadWD, &rc, &rk, &adteam.Owner, &jlt, &adteam.Role, &adteam.Precision, &until, &shareop)
 		if err != nil {
 			Log.Error(err)
 			return err
@@ -314,6 +334,11 @@ func (gid GoogleID) adTeams(ad *AgentData) error {
 		} else {
 			adteam.JoinLinkToken = ""
 		}
+		if adteam.Owner == gid {
+			adteam.Role = TeamRoleOwner
+		}
+		adteam.ShareUntil = until.String
+		adteam.ShareOp = OperationID(shareop.String)
 		ad.Teams = append(ad.Teams, adteam)
 	}
 	return nil
@@ -394,7 +419,30 @@ func (gid GoogleID) adOps(ad *AgentData) error {
 		seen[op.ID] = true
 	}
 
-	rowTeam, err := db.Query("SELECT o.ID, o.Name, o.Color, p.teamID FROM operation=o, agentteams=x, opteams=p WHERE p.opID = o.ID AND x.gid = ? AND x.teamID = p.teamID ORDER BY o.Name", gid)
+	// co-owners have the owner's powers, except delete and chown
+	rowCo, err := db.Query("SELECT o.ID, o.Name, o.Color FROM operation=o, opcoowners=c WHERE c.opID = o.ID AND c.gid = ? ORDER BY o.Name", gid)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	defer rowCo.Close()
+	for rowCo.Next() {
+		var op AdOperation
+		err := rowCo.Scan(&op.ID, &op.Name, &op.Color)
+		if err != nil {
+			Log.Error(err)
+			return err
+		}
+		op.IsOwner = true
+		op.IsCoOwner = true
+		if seen[op.ID] {
+			continue
+		}
+		ad.Ops = append(ad.Ops, op)
+		seen[op.ID] = true
+	}
+
+	rowTeam, err := db.Query("SELECT o.ID, o.Name, o.Color, p.teamID FROM operation=o, agentteams=x, opteams=p WHERE p.opID = o.ID AND x.gid = ? AND x.suspended = 0 AND (x.teamID = p.teamID OR x.teamID IN (SELECT descendant FROM teamtree WHERE ancestor = p.teamID)) ORDER BY o.Name", gid)
 	if err != nil {
 		Log.Error(err)
 		return err
@@ -487,6 +535,8 @@ func (gid GoogleID) AgentLocation(lat, lon string) error {
 		Log.Error(err)
 		return err
 	}
+	gid.recordLocation(point)
+	gid.checkArrivals(flat, flon)
 
 	gid.firebaseAgentLocation()
 	return nil
@@ -591,6 +641,8 @@ func RevalidateEveryone() error {
 		if err = RocksUpdate(gid, &r); err != nil {
 			Log.Error(err)
 		}
+
+		gid.enforceTeamPolicies()
 	}
 	return nil
 }
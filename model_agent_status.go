@@ -0,0 +1,129 @@
+package wasabee
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// AgentStatus is an agent's short operational status on an op, such as en route or blocked, with an optional note
+type AgentStatus struct {
+	Gid     GoogleID    `json:"gid"`
+	OpID    OperationID `json:"opID"`
+	Status  string      `json:"status"`
+	Note    string      `json:"note,omitempty"`
+	Updated string      `json:"updated"`
+}
+
+// The statuses an agent may set, the note carries the details such as "enemy present"
+const (
+	StatusEnRoute   = "enroute"
+	StatusOnSite    = "onsite"
+	StatusBlocked   = "blocked"
+	StatusOutOfGear = "outofgear"
+	StatusDone      = "done"
+)
+
+const maxStatusNote = 255
+
+func validStatus(status string) bool {
+	switch status {
+	case StatusEnRoute, StatusOnSite, StatusBlocked, StatusOutOfGear, StatusDone:
+		return true
+	}
+	return false
+}
+
+// SetStatus sets the agent's status on the op and tells the op's teams, an empty status clears it.
+// The agent must be able to see the op, either fully or their assignments only.
+func (o *Operation) SetStatus(gid GoogleID, status, note string) (string, error) {
+	if read, _ := o.ReadAccess(gid); !read && !o.AssignedOnlyAccess(gid) {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "resource", o.ID)
+		return "", err
+	}
+
+	if status == "" {
+		if _, err := db.Exec("DELETE FROM agentstatus WHERE opID = ? AND gid = ?", o.ID, gid); err != nil {
+			Log.Error(err)
+			return "", err
+		}
+	} else {
+		if !validStatus(status) {
+			err := fmt.Errorf("invalid status: %s", status)
+			Log.Warnw(err.Error(), "GID", gid, "resource", o.ID)
+			return "", err
+		}
+		// the column holds characters, not bytes
+		if r := []rune(note); len(r) > maxStatusNote {
+			note = string(r[:maxStatusNote])
+		}
+		if _, err := db.Exec("REPLACE INTO agentstatus (opID, gid, status, note, updated) VALUES (?, ?, ?, ?, UTC_TIMESTAMP())", o.ID, gid, status, MakeNullString(note)); err != nil {
+			Log.Error(err)
+			return "", err
+		}
+	}
+
+	o.firebaseAgentStatus(AgentStatus{Gid: gid, OpID: o.ID, Status: status, Note: note})
+	return o.Touch()
+}
+
+// Statuses lists the statuses agents have set on the op
+func (opID OperationID) Statuses() ([]AgentStatus, error) {
+	return agentStatuses("s.opID = ?", opID)
+}
+
+// Statuses lists the agent's statuses on all ops
+func (gid GoogleID) Statuses() ([]AgentStatus, error) {
+	return agentStatuses("s.gid = ?", gid)
+}
+
+// teamStatuses lists the statuses the team's members have set on ops shared with the team or the teams above it
+func (teamID TeamID) teamStatuses() ([]AgentStatus, error) {
+	return agentStatuses("s.gid IN (SELECT gid FROM agentteams WHERE teamID = ?) AND s.opID IN (SELECT opID FROM opteams WHERE teamID = ? OR teamID IN (SELECT ancestor FROM teamtree WHERE descendant = ?))",
+		teamID, teamID, teamID)
+}
+
+func agentStatuses(where string, args ...interface{}) ([]AgentStatus, error) {
+	list := make([]AgentStatus, 0)
+
+	rows, err := db.Query("SELECT s.gid, s.opID, s.status, s.note, s.updated FROM agentstatus=s WHERE "+where+" ORDER BY s.updated DESC", args...)
+	if err != nil {
+		Log.Error(err)
+		return list, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s AgentStatus
+		var note sql.NullString
+		if err := rows.Scan(&s.Gid, &s.OpID, &s.Status, &note, &s.Updated); err != nil {
+			Log.Error(err)
+			continue
+		}
+		s.Note = note.String
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// notify the op's teams that an agent's status has changed
+func (o *Operation) firebaseAgentStatus(s AgentStatus) {
+	if !fb.running {
+		return
+	}
+
+	j, _ := json.Marshal(s)
+	if len(o.Teams) == 0 {
+		_ = o.PopulateTeams()
+	}
+	for _, t := range o.Teams {
+		fbPush(FirebaseCmd{
+			Cmd:    FbccAgentStatusChange,
+			TeamID: t.TeamID,
+			OpID:   o.ID,
+			Gid:    s.Gid,
+			Msg:    string(j),
+		})
+	}
+}
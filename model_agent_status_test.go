@@ -0,0 +1,63 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestAgentStatus(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(j, &in); err != nil {
+		t.Error(err.Error())
+	}
+
+	if _, err := in.SetStatus(gid, "lost", ""); err == nil {
+		t.Error("invalid status accepted")
+	}
+	if _, err := in.SetStatus(wasabee.GoogleID("0"), wasabee.StatusEnRoute, ""); err == nil {
+		t.Error("agent without access set a status")
+	}
+	if _, err := in.SetStatus(gid, wasabee.StatusBlocked, "enemy present"); err != nil {
+		t.Error(err.Error())
+	}
+
+	statuses, err := in.ID.Statuses()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(statuses) != 1 || statuses[0].Status != wasabee.StatusBlocked || statuses[0].Note != "enemy present" {
+		t.Error("status not stored")
+	}
+
+	var o wasabee.Operation
+	o.ID = in.ID
+	if err := o.Populate(gid); err != nil {
+		t.Error(err.Error())
+	}
+	if len(o.Statuses) != 1 {
+		t.Error("status not in op payload")
+	}
+
+	if _, err := in.SetStatus(gid, "", ""); err != nil {
+		t.Error(err.Error())
+	}
+	if statuses, _ := in.ID.Statuses(); len(statuses) != 0 {
+		t.Error("status not cleared")
+	}
+
+	if err := in.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
@@ -3,6 +3,7 @@ package wasabee_test
 import (
 	"github.com/wasabee-project/Wasabee-Server"
 	"testing"
+	"time"
 )
 
 func TestInitAgent(t *testing.T) {
@@ -98,6 +99,36 @@ func TestAgentLocation(t *testing.T) {
 	}
 }
 
+func TestLocationHistory(t *testing.T) {
+	if err := gid.SetLocationHistory(wasabee.LocationHistorySettings{Enabled: true, RetentionDays: 365}); err == nil {
+		t.Error("excessive retention accepted")
+	}
+	if err := gid.SetLocationHistory(wasabee.LocationHistorySettings{Enabled: true}); err != nil {
+		t.Error(err.Error())
+	}
+
+	start := time.Now().Add(-1 * time.Minute)
+	if err := gid.AgentLocation("33.149", "-96.788"); err != nil {
+		t.Error(err.Error())
+	}
+	points, err := gid.LocationHistory(start, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(points) == 0 {
+		t.Error("location not recorded")
+	}
+
+	// opting out discards the history
+	if err := gid.SetLocationHistory(wasabee.LocationHistorySettings{}); err != nil {
+		t.Error(err.Error())
+	}
+	points, _ = gid.LocationHistory(start, time.Now().Add(time.Minute))
+	if len(points) != 0 {
+		t.Error("location history kept after opting out")
+	}
+}
+
 func TestAgentDelete(t *testing.T) {
 	// special case google ID that is not really used
 	ngid := wasabee.GoogleID("104743827901423568948")
@@ -0,0 +1,269 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// ArrivalPolicy controls whether the server watches agents' positions for arrival at their assigned tasks in an op
+type ArrivalPolicy struct {
+	Enabled bool `json:"enabled"`
+	Radius  int  `json:"radius"` // meters from the portal
+	Prompt  bool `json:"prompt"` // remind the agent of the task on arrival
+}
+
+// Arrival is an agent reaching the portal of a task assigned to them: a marker's portal or a link's origin
+type Arrival struct {
+	ID         string      `json:"id"`
+	OpID       OperationID `json:"opID"`
+	Kind       string      `json:"kind"` // marker or link
+	TaskID     string      `json:"taskID"`
+	GID        GoogleID    `json:"gid"`
+	PortalName string      `json:"portal"`
+	Arrived    string      `json:"arrived"`
+}
+
+const (
+	defaultArrivalRadius = 40 // action range
+	maxArrivalRadius     = 2000
+)
+
+// SetArrivalPolicy sets the op's arrival detection, only an owner may set it
+func (opID OperationID) SetArrivalPolicy(gid GoogleID, p ArrivalPolicy) error {
+	if !opID.IsOwner(gid) {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+		return err
+	}
+
+	if p.Radius == 0 {
+		p.Radius = defaultArrivalRadius
+	}
+	if p.Radius < 0 || p.Radius > maxArrivalRadius {
+		err := fmt.Errorf("arrival radius must be between 1 and %d meters", maxArrivalRadius)
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID, "radius", p.Radius)
+		return err
+	}
+
+	if _, err := db.Exec("REPLACE INTO oparrivalpolicy (opID, enabled, radius, prompt) VALUES (?, ?, ?, ?)", opID, p.Enabled, p.Radius, p.Prompt); err != nil {
+		Log.Error(err)
+		return err
+	}
+	Log.Infow("arrival policy set", "GID", gid, "resource", opID, "enabled", p.Enabled, "radius", p.Radius)
+	return nil
+}
+
+// ArrivalPolicy returns the op's arrival detection settings, nil if none are set
+func (opID OperationID) ArrivalPolicy() (*ArrivalPolicy, error) {
+	var p ArrivalPolicy
+	err := db.QueryRow("SELECT enabled, radius, prompt FROM oparrivalpolicy WHERE opID = ?", opID).Scan(&p.Enabled, &p.Radius, &p.Prompt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		Log.Error(err)
+		return nil, err
+	}
+	return &p, nil
+}
+
+type arrivalCandidate struct {
+	Arrival
+	detail   string // marker type or link destination
+	lat, lon string
+	radius   int
+	prompt   bool
+}
+
+// checkArrivals compares an agent's new position with the open tasks assigned to them in ops with arrival detection enabled
+func (gid GoogleID) checkArrivals(lat, lon float64) {
+	if lat == 0 && lon == 0 {
+		return
+	}
+
+	var candidates []arrivalCandidate
+	queries := map[string]string{
+		claimMarker: "SELECT m.opID, m.ID, m.type, p.name, Y(p.loc), X(p.loc), a.radius, a.prompt FROM marker=m, portal=p, oparrivalpolicy=a " +
+			"WHERE m.gid = ? AND m.state IN ('assigned','acknowledged') AND a.opID = m.opID AND a.enabled = 1 AND p.opID = m.opID AND p.ID = m.portalID " +
+			"AND NOT EXISTS (SELECT 1 FROM arrival WHERE opID = m.opID AND kind = 'marker' AND taskID = m.ID)",
+		claimLink: "SELECT l.opID, l.ID, t.name, p.name, Y(p.loc), X(p.loc), a.radius, a.prompt FROM link=l, portal=p, portal=t, oparrivalpolicy=a " +
+			"WHERE l.gid = ? AND l.completed = 0 AND a.opID = l.opID AND a.enabled = 1 AND p.opID = l.opID AND p.ID = l.fromPortalID AND t.opID = l.opID AND t.ID = l.toPortalID " +
+			"AND NOT EXISTS (SELECT 1 FROM arrival WHERE opID = l.opID AND kind = 'link' AND taskID = l.ID)",
+	}
+	for kind, q := range queries {
+		rows, err := db.Query(q, gid)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		for rows.Next() {
+			c := arrivalCandidate{Arrival: Arrival{Kind: kind, GID: gid}}
+			if err := rows.Scan(&c.OpID, &c.TaskID, &c.detail, &c.PortalName, &c.lat, &c.lon, &c.radius, &c.prompt); err != nil {
+				Log.Error(err)
+				continue
+			}
+			candidates = append(candidates, c)
+		}
+		rows.Close()
+	}
+
+	slat := strconv.FormatFloat(lat, 'f', 7, 64)
+	slon := strconv.FormatFloat(lon, 'f', 7, 64)
+	for _, c := range candidates {
+		if Distance(slat, slon, c.lat, c.lon) <= float64(c.radius) {
+			c.arrive()
+		}
+	}
+}
+
+// arrive records the arrival, marks the task on site and lets the op's coordinators (and optionally the agent) know
+func (c arrivalCandidate) arrive() {
+	c.ID = GenerateID(16)
+	result, err := db.Exec("INSERT IGNORE INTO arrival (ID, opID, kind, taskID, gid) VALUES (?, ?, ?, ?, ?)", c.ID, c.OpID, c.Kind, c.TaskID, c.GID)
+	if err != nil {
+		Log.Error(err)
+		return
+	}
+	if ra, _ := result.RowsAffected(); ra == 0 {
+		return
+	}
+
+	o := Operation{ID: c.OpID}
+	if c.Kind == claimMarker {
+		if _, err := db.Exec("UPDATE marker SET state = 'onsite' WHERE ID = ? AND opID = ?", c.TaskID, c.OpID); err != nil {
+			Log.Error(err)
+			return
+		}
+		o.firebaseMarkerStatus(MarkerID(c.TaskID), "onsite")
+	}
+	if _, err := o.Touch(); err != nil {
+		Log.Error(err)
+	}
+	Log.Infow("agent arrived at task", "GID", c.GID, "resource", c.OpID, "kind", c.Kind, "task", c.TaskID)
+
+	task := c.detail + " at " + c.PortalName
+	if c.Kind == claimLink {
+		task = "link from " + c.PortalName + " to " + c.detail
+	}
+	var opName string
+	if err := db.QueryRow("SELECT name FROM operation WHERE ID = ?", c.OpID).Scan(&opName); err != nil {
+		Log.Error(err)
+	}
+	name, _ := c.GID.IngressNameOperation(&o)
+
+	coordinators, err := c.OpID.coordinators()
+	if err != nil {
+		return
+	}
+	msg := fmt.Sprintf("%s is on site for %s (%s)", name, task, opName)
+	for _, coord := range coordinators {
+		if coord == c.GID {
+			continue
+		}
+		if _, err := coord.SendMessage(msg); err != nil {
+			Log.Error(err)
+		}
+		coord.FirebaseGenericMessage(msg)
+	}
+
+	if c.prompt {
+		msg := fmt.Sprintf("you have arrived for %s (%s), send /arrivals to mark it complete", task, opName)
+		if _, err := c.GID.SendMessage(msg); err != nil {
+			Log.Error(err)
+		}
+		c.GID.FirebaseGenericMessage(msg)
+	}
+}
+
+// coordinators are the op's owner and co-owners
+func (opID OperationID) coordinators() ([]GoogleID, error) {
+	var list []GoogleID
+
+	rows, err := db.Query("SELECT gid FROM operation WHERE ID = ? UNION SELECT gid FROM opcoowners WHERE opID = ?", opID, opID)
+	if err != nil {
+		Log.Error(err)
+		return list, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var gid GoogleID
+		if err := rows.Scan(&gid); err != nil {
+			Log.Error(err)
+			continue
+		}
+		list = append(list, gid)
+	}
+	return list, nil
+}
+
+// clearArrival forgets an arrival when a task changes hands, so the new assignee's arrival is detected
+func (opID OperationID) clearArrival(kind, taskID string) {
+	if _, err := db.Exec("DELETE FROM arrival WHERE opID = ? AND kind = ? AND taskID = ?", opID, kind, taskID); err != nil {
+		Log.Error(err)
+	}
+}
+
+// Arrivals lists the arrivals recorded in an op, no authorization takes place
+func (opID OperationID) Arrivals() ([]Arrival, error) {
+	return arrivals("r.opID = ?", opID)
+}
+
+// OpenArrivals lists the agent's arrivals at tasks which are not yet complete
+func (gid GoogleID) OpenArrivals() ([]Arrival, error) {
+	return arrivals("r.gid = ? AND ((r.kind = 'marker' AND EXISTS (SELECT 1 FROM marker WHERE ID = r.taskID AND opID = r.opID AND state = 'onsite')) OR "+
+		"(r.kind = 'link' AND EXISTS (SELECT 1 FROM link WHERE ID = r.taskID AND opID = r.opID AND completed = 0)))", gid)
+}
+
+func arrivals(where string, arg interface{}) ([]Arrival, error) {
+	list := make([]Arrival, 0)
+
+	rows, err := db.Query("SELECT r.ID, r.opID, r.kind, r.taskID, r.gid, r.arrived, "+
+		"COALESCE((SELECT p.name FROM marker=m, portal=p WHERE r.kind = 'marker' AND m.ID = r.taskID AND m.opID = r.opID AND p.ID = m.portalID AND p.opID = r.opID), "+
+		"(SELECT p.name FROM link=l, portal=p WHERE r.kind = 'link' AND l.ID = r.taskID AND l.opID = r.opID AND p.ID = l.fromPortalID AND p.opID = r.opID), '') "+
+		"FROM arrival=r WHERE "+where+" ORDER BY r.arrived", arg)
+	if err != nil {
+		Log.Error(err)
+		return list, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Arrival
+		if err := rows.Scan(&a.ID, &a.OpID, &a.Kind, &a.TaskID, &a.GID, &a.Arrived, &a.PortalName); err != nil {
+			Log.Error(err)
+			continue
+		}
+		list = append(list, a)
+	}
+	return list, nil
+}
+
+// CompleteArrival marks the task the agent arrived at as complete
+func (gid GoogleID) CompleteArrival(arrivalID string) error {
+	var a Arrival
+	err := db.QueryRow("SELECT opID, kind, taskID FROM arrival WHERE ID = ? AND gid = ?", arrivalID, gid).Scan(&a.OpID, &a.Kind, &a.TaskID)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("no such arrival")
+		Log.Warnw(err.Error(), "GID", gid, "arrival", arrivalID)
+		return err
+	}
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	o := Operation{ID: a.OpID}
+	if a.Kind == claimMarker {
+		_, err = MarkerID(a.TaskID).Complete(o, gid)
+		return err
+	}
+	if !o.ID.AssignedTo(LinkID(a.TaskID), gid) {
+		err = fmt.Errorf("link assigned to someone else")
+		Log.Warnw(err.Error(), "GID", gid, "resource", a.OpID, "link", a.TaskID)
+		return err
+	}
+	_, err = o.LinkCompleted(LinkID(a.TaskID), true)
+	return err
+}
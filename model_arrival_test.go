@@ -0,0 +1,156 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestArrivals(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(j, &in); err != nil {
+		t.Error(err.Error())
+	}
+	if len(in.Links) == 0 {
+		t.Fatal("no links in test op")
+	}
+
+	if err := in.ID.SetArrivalPolicy(gid, wasabee.ArrivalPolicy{Enabled: true, Radius: 5000}); err == nil {
+		t.Error("excessive arrival radius accepted")
+	}
+	if err := in.ID.SetArrivalPolicy(gid, wasabee.ArrivalPolicy{Enabled: true, Prompt: true}); err != nil {
+		t.Error(err.Error())
+	}
+	p, err := in.ID.ArrivalPolicy()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if p == nil || !p.Enabled || p.Radius != 40 {
+		t.Error("arrival policy not stored")
+	}
+
+	link := in.Links[0]
+	if _, err := in.AssignLink(link.ID, gid); err != nil {
+		t.Error(err.Error())
+	}
+	for _, portal := range in.OpPortals {
+		if portal.ID == link.From {
+			if err := gid.AgentLocation(portal.Lat, portal.Lon); err != nil {
+				t.Error(err.Error())
+			}
+		}
+	}
+
+	open, err := gid.OpenArrivals()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	var arrival string
+	for _, a := range open {
+		if a.OpID == in.ID && a.TaskID == string(link.ID) {
+			arrival = a.ID
+		}
+	}
+	if arrival == "" {
+		t.Fatal("arrival at link origin not detected")
+	}
+
+	if err := gid.CompleteArrival(arrival); err != nil {
+		t.Error(err.Error())
+	}
+	open, _ = gid.OpenArrivals()
+	for _, a := range open {
+		if a.ID == arrival {
+			t.Error("completed task still listed as open arrival")
+		}
+	}
+
+	if err := in.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestMarkerAcknowledge(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test3.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(j, &in); err != nil {
+		t.Error(err.Error())
+	}
+	if len(in.Markers) < 2 {
+		t.Fatal("not enough markers in test op")
+	}
+	if err := in.ID.SetArrivalPolicy(gid, wasabee.ArrivalPolicy{Enabled: true}); err != nil {
+		t.Error(err.Error())
+	}
+
+	state := func(id wasabee.MarkerID) string {
+		var o wasabee.Operation
+		o.ID = in.ID
+		if err := o.Populate(gid); err != nil {
+			t.Error(err.Error())
+		}
+		for _, m := range o.Markers {
+			if m.ID == id {
+				return m.State
+			}
+		}
+		return ""
+	}
+
+	// assigned moves to acknowledged
+	first := in.Markers[0]
+	if _, err := in.AssignMarker(first.ID, gid); err != nil {
+		t.Error(err.Error())
+	}
+	if _, err := first.ID.Acknowledge(&in, gid); err != nil {
+		t.Error(err.Error())
+	}
+	if s := state(first.ID); s != "acknowledged" {
+		t.Errorf("assigned marker acknowledged as %s", s)
+	}
+
+	// on site stays on site
+	second := in.Markers[1]
+	if _, err := in.AssignMarker(second.ID, gid); err != nil {
+		t.Error(err.Error())
+	}
+	for _, portal := range in.OpPortals {
+		if portal.ID == second.PortalID {
+			if err := gid.AgentLocation(portal.Lat, portal.Lon); err != nil {
+				t.Error(err.Error())
+			}
+		}
+	}
+	if s := state(second.ID); s != "onsite" {
+		t.Fatalf("arrival did not mark the marker on site: %s", s)
+	}
+	if _, err := second.ID.Acknowledge(&in, gid); err == nil {
+		t.Error("on site marker acknowledged")
+	}
+	if s := state(second.ID); s != "onsite" {
+		t.Errorf("on site marker moved back to %s", s)
+	}
+
+	if err := in.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
@@ -0,0 +1,242 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// DefenseAlert is a report that a portal is under attack and needs recharging
+type DefenseAlert struct {
+	ID       string         `json:"ID"`
+	PortalID PortalID       `json:"PortalID"`
+	Name     string         `json:"Name"`
+	Reporter GoogleID       `json:"Reporter"`
+	Message  string         `json:"Message"`
+	Created  string         `json:"Created"`
+	Open     bool           `json:"Open"`
+	Claims   []DefenseClaim `json:"Claims"`
+}
+
+// DefenseClaim is a notified agent's response to a DefenseAlert
+type DefenseClaim struct {
+	GID      GoogleID `json:"GID"`
+	Response string   `json:"Response"` // notified, claimed or declined
+	Updated  string   `json:"Updated"`
+}
+
+// ReportAttack notifies every agent who shares defensive keys for the portal with the reporter
+func (gid GoogleID) ReportAttack(portalID PortalID, message string) (DefenseAlert, error) {
+	a := DefenseAlert{
+		ID:       strings.ToLower(GenerateID(8)),
+		PortalID: portalID,
+		Reporter: gid,
+		Message:  message,
+		Open:     true,
+		Claims:   make([]DefenseClaim, 0),
+	}
+
+	var name sql.NullString
+	if err := db.QueryRow("SELECT name FROM portalregistry WHERE ID = ?", portalID).Scan(&name); err != nil && err != sql.ErrNoRows {
+		Log.Error(err)
+		return a, err
+	}
+	a.Name = name.String
+	if a.Name == "" {
+		a.Name = string(portalID)
+	}
+
+	// the same sharing rules as ListDefensiveKeys, from the point of view of the holder
+	rows, err := db.Query("SELECT DISTINCT d.gid FROM defensivekeys=d, keyinventory=k WHERE d.portalID = ? AND k.gid = d.gid AND k.portalID = d.portalID AND k.count > 0 AND d.gid != ? "+
+		"AND d.gid IN (SELECT DISTINCT other.gid FROM agentteams=other, agentteams=me WHERE me.gid = ? AND other.teamID = me.teamID AND other.shareWD = 'On' AND other.suspended = 0 AND me.suspended = 0)", portalID, gid, gid)
+	if err != nil {
+		Log.Error(err)
+		return a, err
+	}
+	defer rows.Close()
+
+	var holders []GoogleID
+	for rows.Next() {
+		var h GoogleID
+		if err := rows.Scan(&h); err != nil {
+			Log.Error(err)
+			continue
+		}
+		holders = append(holders, h)
+	}
+
+	if len(holders) == 0 {
+		err := fmt.Errorf("no agents hold defensive keys for this portal")
+		Log.Infow(err.Error(), "GID", gid, "portal", portalID)
+		return a, err
+	}
+
+	if _, err := db.Exec("INSERT INTO defensealert (ID, portalID, gid, message) VALUES (?, ?, ?, ?)", a.ID, portalID, gid, MakeNullString(message)); err != nil {
+		Log.Error(err)
+		return a, err
+	}
+
+	reporter, _ := gid.IngressName()
+	msg := fmt.Sprintf("%s reports %s is under attack and needs a recharge. Reply /claim %s if you can recharge it or /decline %s if you cannot.", reporter, a.Name, a.ID, a.ID)
+	if message != "" {
+		msg = fmt.Sprintf("%s\n%s", msg, message)
+	}
+
+	for _, h := range holders {
+		if _, err := db.Exec("INSERT INTO defenseclaim (alertID, gid) VALUES (?, ?)", a.ID, h); err != nil {
+			Log.Error(err)
+			continue
+		}
+		if _, err := h.SendMessage(msg); err != nil {
+			Log.Error(err)
+		}
+		h.FirebaseGenericMessage(msg)
+		a.Claims = append(a.Claims, DefenseClaim{GID: h, Response: "notified"})
+	}
+
+	Log.Infow("defense alert", "GID", gid, "portal", portalID, "alert", a.ID, "notified", len(holders))
+	return a, nil
+}
+
+// RespondAttack records a notified agent claiming or declining the recharge, the reporter is told of claims
+func (gid GoogleID) RespondAttack(alertID string, claim bool) error {
+	response := "declined"
+	if claim {
+		response = "claimed"
+	}
+
+	var reporter GoogleID
+	var name sql.NullString
+	var open bool
+	err := db.QueryRow("SELECT a.gid, r.name, a.open FROM defensealert=a LEFT JOIN portalregistry=r ON a.portalID = r.ID WHERE a.ID = ?", alertID).Scan(&reporter, &name, &open)
+	if err == sql.ErrNoRows {
+		err := fmt.Errorf("no such alert")
+		Log.Infow(err.Error(), "GID", gid, "alert", alertID)
+		return err
+	}
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if !open {
+		err := fmt.Errorf("alert is closed")
+		Log.Infow(err.Error(), "GID", gid, "alert", alertID)
+		return err
+	}
+
+	result, err := db.Exec("UPDATE defenseclaim SET response = ?, updated = UTC_TIMESTAMP() WHERE alertID = ? AND gid = ?", response, alertID, gid)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if ra, _ := result.RowsAffected(); ra != 1 {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM defenseclaim WHERE alertID = ? AND gid = ?", alertID, gid).Scan(&count); err != nil || count == 0 {
+			err := fmt.Errorf("not notified of this alert")
+			Log.Warnw(err.Error(), "GID", gid, "alert", alertID)
+			return err
+		}
+	}
+
+	if claim {
+		iname, _ := gid.IngressName()
+		msg := fmt.Sprintf("%s will recharge %s", iname, name.String)
+		if _, err := reporter.SendMessage(msg); err != nil {
+			Log.Error(err)
+		}
+		reporter.FirebaseGenericMessage(msg)
+	}
+	Log.Infow("defense alert response", "GID", gid, "alert", alertID, "response", response)
+	return nil
+}
+
+// CloseAttack marks an alert as handled, only the reporter may close it
+func (gid GoogleID) CloseAttack(alertID string) error {
+	result, err := db.Exec("UPDATE defensealert SET open = 0 WHERE ID = ? AND gid = ?", alertID, gid)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if ra, _ := result.RowsAffected(); ra != 1 {
+		err := fmt.Errorf("no such alert")
+		Log.Infow(err.Error(), "GID", gid, "alert", alertID)
+		return err
+	}
+	return nil
+}
+
+// DefenseAlerts lists the recent alerts an agent reported or was notified of
+func (gid GoogleID) DefenseAlerts() ([]DefenseAlert, error) {
+	alerts := make([]DefenseAlert, 0)
+
+	rows, err := db.Query("SELECT a.ID, a.portalID, r.name, a.gid, a.message, a.created, a.open FROM defensealert=a LEFT JOIN portalregistry=r ON a.portalID = r.ID "+
+		"WHERE a.gid = ? OR a.ID IN (SELECT alertID FROM defenseclaim WHERE gid = ?) ORDER BY a.created DESC", gid, gid)
+	if err != nil {
+		Log.Error(err)
+		return alerts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a DefenseAlert
+		var name, message sql.NullString
+		if err := rows.Scan(&a.ID, &a.PortalID, &name, &a.Reporter, &message, &a.Created, &a.Open); err != nil {
+			Log.Error(err)
+			continue
+		}
+		a.Name = name.String
+		a.Message = message.String
+		a.Claims = make([]DefenseClaim, 0)
+		alerts = append(alerts, a)
+	}
+
+	for i := range alerts {
+		if err := alerts[i].populateClaims(); err != nil {
+			return alerts, err
+		}
+	}
+	return alerts, nil
+}
+
+func (a *DefenseAlert) populateClaims() error {
+	rows, err := db.Query("SELECT gid, response, updated FROM defenseclaim WHERE alertID = ?", a.ID)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c DefenseClaim
+		if err := rows.Scan(&c.GID, &c.Response, &c.Updated); err != nil {
+			Log.Error(err)
+			continue
+		}
+		a.Claims = append(a.Claims, c)
+	}
+	return nil
+}
+
+// DefensivePortalByName finds a portal by name among the defensive keys shared with the agent
+func (gid GoogleID) DefensivePortalByName(name string) (PortalID, error) {
+	var portalID PortalID
+	err := db.QueryRow("SELECT d.portalID FROM defensivekeys=d WHERE d.name LIKE ? "+
+		"AND d.gid IN (SELECT DISTINCT other.gid FROM agentteams=other, agentteams=me WHERE me.gid = ? AND other.teamID = me.teamID AND other.shareWD = 'On' AND other.suspended = 0 AND me.suspended = 0) "+
+		"ORDER BY d.name = ? DESC LIMIT 1", "%"+likeEscape(name)+"%", gid, name).Scan(&portalID)
+	if err == sql.ErrNoRows {
+		err := fmt.Errorf("no defensive keys shared for %s", name)
+		Log.Infow(err.Error(), "GID", gid)
+		return portalID, err
+	}
+	if err != nil {
+		Log.Error(err)
+		return portalID, err
+	}
+	return portalID, nil
+}
+
+func defenseAlertClean() {
+	if _, err := db.Exec("DELETE FROM defensealert WHERE created < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 7 DAY)"); err != nil {
+		Log.Error(err)
+	}
+}
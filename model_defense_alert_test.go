@@ -0,0 +1,27 @@
+package wasabee_test
+
+import (
+	"testing"
+)
+
+func TestDefenseAlert(t *testing.T) {
+	if _, err := gid.ReportAttack("bogus.portal", "help"); err == nil {
+		t.Error("alert raised with no key holders")
+	}
+	if err := gid.RespondAttack("bogus", true); err == nil {
+		t.Error("claimed a nonexistent alert")
+	}
+	if err := gid.CloseAttack("bogus"); err == nil {
+		t.Error("closed a nonexistent alert")
+	}
+
+	alerts, err := gid.DefenseAlerts()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	for _, a := range alerts {
+		if a.Reporter != gid && len(a.Claims) == 0 {
+			t.Error("alert listed without a claim for the agent")
+		}
+	}
+}
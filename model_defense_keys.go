@@ -3,7 +3,9 @@ package wasabee
 import (
 	"database/sql"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,13 +26,93 @@ type DefensiveKey struct {
 	Lon      string   `json:"Lng"`
 }
 
-// ListDefensiveKeys gets all keys an agent is authorized to know about.
-func (gid GoogleID) ListDefensiveKeys() (DefensiveKeyList, error) {
+// DefensiveKeyFilter limits the defensive keys returned; zero values are ignored.
+// Radius is in km and is only used if Lat and Lon are set, MinCount applies to each agent's count.
+type DefensiveKeyFilter struct {
+	BBox      *BoundingBox
+	Lat       string
+	Lon       string
+	Radius    float64
+	PortalIDs []PortalID
+	MinCount  int32
+	Agent     GoogleID
+}
+
+// DefensivePortal is the defensive keys for a single portal, with a per-agent breakdown
+type DefensivePortal struct {
+	PortalID PortalID            `json:"PortalID"`
+	Name     string              `json:"Name"`
+	Lat      string              `json:"Lat"`
+	Lon      string              `json:"Lng"`
+	Count    int32               `json:"Count"`
+	Agents   []DefensiveKeyAgent `json:"Agents"`
+}
+
+// DefensiveKeyAgent is one agent's share of a DefensivePortal
+type DefensiveKeyAgent struct {
+	GID   GoogleID `json:"GID"`
+	CapID string   `json:"CapID"`
+	Count int32    `json:"Count"`
+}
+
+// ListDefensiveKeys gets all keys an agent is authorized to know about, limited by the filter.
+func (gid GoogleID) ListDefensiveKeys(f DefensiveKeyFilter) (DefensiveKeyList, error) {
 	var dkl DefensiveKeyList
 	var name, lat, lon sql.NullString
 
-	rows, err := db.Query("SELECT gid, portalID, capID, count, name, Y(loc) AS lat, X(loc) AS lon FROM defensivekeys WHERE gid IN (SELECT DISTINCT other.gid FROM agentteams=other, agentteams=me WHERE me.gid = ? AND me.loadWD = 'On' AND other.teamID = me.teamID AND other.shareWD = 'On')", gid)
+	var where []string
+	var having []string
+	args := []interface{}{gid}
+	distance := "0"
+
+	if f.Agent != "" {
+		where = append(where, "d.gid = ?")
+		args = append(args, f.Agent)
+	}
+	if len(f.PortalIDs) > 0 {
+		in := strings.TrimSuffix(strings.Repeat("?,", len(f.PortalIDs)), ",")
+		where = append(where, "d.portalID IN ("+in+")")
+		for _, p := range f.PortalIDs {
+			args = append(args, p)
+		}
+	}
+	if f.BBox != nil {
+		if f.BBox.South > f.BBox.North || f.BBox.West > f.BBox.East {
+			err := fmt.Errorf("invalid bounding box")
+			Log.Warnw(err.Error(), "GID", gid, "bbox", f.BBox)
+			return dkl, err
+		}
+		where = append(where, "Y(d.loc) BETWEEN ? AND ? AND X(d.loc) BETWEEN ? AND ?")
+		args = append(args, f.BBox.South, f.BBox.North, f.BBox.West, f.BBox.East)
+	}
+	if f.Lat != "" && f.Lon != "" && f.Radius > 0 {
+		distance = distanceSQL("Y(d.loc)", "X(d.loc)")
+		args = append([]interface{}{f.Lat, f.Lon, f.Lat}, args...)
+		having = append(having, "distance < ?")
+	}
+	if f.MinCount > 0 {
+		having = append(having, "total >= ?")
+	}
 
+	// counts and capsules come from the key inventory, defensivekeys only records which portals are shared
+	q := fmt.Sprintf("SELECT d.gid, d.portalID, COALESCE(GROUP_CONCAT(NULLIF(k.capsule, '') SEPARATOR ','), ''), SUM(k.count) AS total, d.name, Y(d.loc) AS lat, X(d.loc) AS lon, %s AS distance "+
+		"FROM defensivekeys=d JOIN keyinventory=k ON k.gid = d.gid AND k.portalID = d.portalID "+
+		"WHERE d.gid IN (SELECT DISTINCT other.gid FROM agentteams=other, agentteams=me WHERE me.gid = ? AND me.loadWD = 'On' AND other.teamID = me.teamID AND other.shareWD = 'On' AND other.suspended = 0 AND me.suspended = 0)", distance)
+	for _, w := range where {
+		q = q + " AND " + w
+	}
+	q = q + " GROUP BY d.gid, d.portalID"
+	if len(having) > 0 {
+		q = q + " HAVING " + strings.Join(having, " AND ")
+		if distance != "0" {
+			args = append(args, f.Radius)
+		}
+		if f.MinCount > 0 {
+			args = append(args, f.MinCount)
+		}
+	}
+
+	rows, err := db.Query(q, args...)
 	if err != nil && err != sql.ErrNoRows {
 		Log.Error(err)
 		return dkl, err
@@ -38,7 +120,8 @@ func (gid GoogleID) ListDefensiveKeys() (DefensiveKeyList, error) {
 	var dk DefensiveKey
 	defer rows.Close()
 	for rows.Next() {
-		err := rows.Scan(&dk.GID, &dk.PortalID, &dk.CapID, &dk.Count, &name, &lat, &lon)
+		var distance float64
+		err := rows.Scan(&dk.GID, &dk.PortalID, &dk.CapID, &dk.Count, &name, &lat, &lon, &distance)
 		if err != nil {
 			Log.Error(err)
 			continue
@@ -66,9 +149,50 @@ func (gid GoogleID) ListDefensiveKeys() (DefensiveKeyList, error) {
 	return dkl, nil
 }
 
-// InsertDefensiveKey adds a new key to the list
+// ByPortal aggregates the list per portal, portals with the most keys first
+func (dkl DefensiveKeyList) ByPortal() []DefensivePortal {
+	portals := make([]DefensivePortal, 0)
+	index := make(map[PortalID]int)
+
+	for _, dk := range dkl.DefensiveKeys {
+		i, ok := index[dk.PortalID]
+		if !ok {
+			portals = append(portals, DefensivePortal{
+				PortalID: dk.PortalID,
+				Name:     dk.Name,
+				Lat:      dk.Lat,
+				Lon:      dk.Lon,
+				Agents:   make([]DefensiveKeyAgent, 0),
+			})
+			i = len(portals) - 1
+			index[dk.PortalID] = i
+		}
+		portals[i].Count += dk.Count
+		portals[i].Agents = append(portals[i].Agents, DefensiveKeyAgent{
+			GID:   dk.GID,
+			CapID: dk.CapID,
+			Count: dk.Count,
+		})
+	}
+
+	sort.SliceStable(portals, func(i, j int) bool {
+		return portals[i].Count > portals[j].Count
+	})
+	return portals
+}
+
+// InsertDefensiveKey adds a new key to the list, the count is stored in the agent's key inventory under the capsule CapID
 func (gid GoogleID) InsertDefensiveKey(dk DefensiveKey) error {
-	if dk.Count < 1 {
+	if err := gid.SetKeyCount(dk.PortalID, dk.CapID, dk.Count); err != nil {
+		return err
+	}
+
+	total, _, err := gid.keyTotal(dk.PortalID)
+	if err != nil {
+		return err
+	}
+
+	if total < 1 {
 		if _, err := db.Exec("DELETE FROM defensivekeys WHERE gid = ? AND portalID = ?", gid, dk.PortalID); err != nil {
 			Log.Error(err)
 			return err
@@ -90,10 +214,14 @@ func (gid GoogleID) InsertDefensiveKey(dk DefensiveKey) error {
 		}
 		point := fmt.Sprintf("POINT(%s %s)", strconv.FormatFloat(flon, 'f', 7, 64), strconv.FormatFloat(flat, 'f', 7, 64))
 
-		if _, err := db.Exec("INSERT INTO defensivekeys (gid, portalID, capID, count, name, loc) VALUES (?, ?, ?, ?, ?, PointFromText(?)) ON DUPLICATE KEY UPDATE capID = ?, count = ?", gid, dk.PortalID, dk.CapID, dk.Count, dk.Name, point, dk.CapID, dk.Count); err != nil {
+		if _, err := db.Exec("INSERT INTO defensivekeys (gid, portalID, capID, count, name, loc) VALUES (?, ?, ?, ?, ?, PointFromText(?)) ON DUPLICATE KEY UPDATE capID = ?, count = ?", gid, dk.PortalID, dk.CapID, total, dk.Name, point, dk.CapID, total); err != nil {
 			Log.Error(err)
 			return err
 		}
+
+		if flat != 0 || flon != 0 {
+			_ = registerPortal(dk.PortalID, dk.Name, strconv.FormatFloat(flat, 'f', 7, 64), strconv.FormatFloat(flon, 'f', 7, 64))
+		}
 	}
 	return nil
 }
@@ -0,0 +1,32 @@
+package wasabee_test
+
+import (
+	"testing"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestDefensiveKeysByPortal(t *testing.T) {
+	dkl := wasabee.DefensiveKeyList{
+		DefensiveKeys: []wasabee.DefensiveKey{
+			{GID: "a", PortalID: "p1", Count: 2},
+			{GID: "a", PortalID: "p2", Count: 1},
+			{GID: "b", PortalID: "p2", Count: 5},
+		},
+	}
+
+	portals := dkl.ByPortal()
+	if len(portals) != 2 {
+		t.Fatalf("expected 2 portals, got %d", len(portals))
+	}
+	if portals[0].PortalID != "p2" || portals[0].Count != 6 || len(portals[0].Agents) != 2 {
+		t.Errorf("portal not aggregated: %+v", portals[0])
+	}
+
+	if _, err := gid.ListDefensiveKeys(wasabee.DefensiveKeyFilter{BBox: &wasabee.BoundingBox{South: 10, North: 0}}); err == nil {
+		t.Error("invalid bounding box accepted")
+	}
+	if _, err := gid.ListDefensiveKeys(wasabee.DefensiveKeyFilter{Lat: "34.69", Lon: "-93.94", Radius: 5, MinCount: 1}); err != nil {
+		t.Error(err.Error())
+	}
+}
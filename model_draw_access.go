@@ -58,7 +58,7 @@ func (o *Operation) ReadAccess(gid GoogleID) (bool, []Zone) {
 		case opPermRoleAssignedOnly:
 			continue
 		case opPermRoleRead:
-			if inteam, _ := gid.AgentInTeam(t.TeamID); inteam {
+			if inteam, _ := gid.AgentInTeamTree(t.TeamID); inteam {
 				permitted = true
 				zones = append(zones, t.Zone)
 				if t.Zone == ZoneAll {
@@ -66,7 +66,7 @@ func (o *Operation) ReadAccess(gid GoogleID) (bool, []Zone) {
 				}
 			}
 		case opPermRoleWrite:
-			if inteam, _ := gid.AgentInTeam(t.TeamID); inteam {
+			if inteam, _ := gid.AgentInTeamTree(t.TeamID); inteam {
 				permitted = true
 				zones = append(zones, ZoneAll)
 				return permitted, zones // fast-path
@@ -91,17 +91,21 @@ func (o *Operation) WriteAccess(gid GoogleID) bool {
 			continue
 		}
 		// write teams
-		if inteam, _ := gid.AgentInTeam(t.TeamID); inteam {
+		if inteam, _ := gid.AgentInTeamTree(t.TeamID); inteam {
 			return true
 		}
 	}
 	return false
 }
 
-// IsOwner returns a bool value determining if the operation is owned by the specified googleID
+// IsOwner returns a bool value determining if the operation is owned or co-owned by the specified googleID
 func (opID OperationID) IsOwner(gid GoogleID) bool {
+	if opID.IsPrimaryOwner(gid) {
+		return true
+	}
+
 	var c int
-	err := db.QueryRow("SELECT COUNT(*) FROM operation WHERE ID = ? and gid = ?", opID, gid).Scan(&c)
+	err := db.QueryRow("SELECT COUNT(*) FROM opcoowners WHERE opID = ? and gid = ?", opID, gid).Scan(&c)
 	if err != nil {
 		Log.Error(err)
 		return false
@@ -112,33 +116,18 @@ func (opID OperationID) IsOwner(gid GoogleID) bool {
 	return true
 }
 
-// Chown changes an operation's owner
-func (opID OperationID) Chown(gid GoogleID, to string) error {
-	if !opID.IsOwner(gid) {
-		err := fmt.Errorf("permission denied: not current owner")
-		Log.Error(err.Error(), "GID", gid, "resource", opID)
-		return err
-	}
-
-	togid, err := ToGid(to)
+// IsPrimaryOwner returns a bool value determining if the specified googleID is the operation's primary owner; co-owners are not considered
+func (opID OperationID) IsPrimaryOwner(gid GoogleID) bool {
+	var c int
+	err := db.QueryRow("SELECT COUNT(*) FROM operation WHERE ID = ? and gid = ?", opID, gid).Scan(&c)
 	if err != nil {
 		Log.Error(err)
-		return err
-	}
-
-	if x, err := togid.IngressName(); x == "" || err != nil {
-		err := fmt.Errorf("unknown user")
-		Log.Error(err.Error(), "to", to)
-		return err
+		return false
 	}
-
-	_, err = db.Exec("UPDATE operation SET gid = ? WHERE ID = ?", togid, opID)
-	if err != nil {
-		Log.Error(err)
-		return err
+	if c < 1 {
+		return false
 	}
-
-	return nil
+	return true
 }
 
 // AssignedOnlyAccess verifies if an agent has AO access to an op
@@ -154,7 +143,7 @@ func (o *Operation) AssignedOnlyAccess(gid GoogleID) bool {
 		if t.Role != opPermRoleAssignedOnly {
 			continue
 		}
-		if inteam, _ := gid.AgentInTeam(t.TeamID); inteam {
+		if inteam, _ := gid.AgentInTeamTree(t.TeamID); inteam {
 			return true
 		}
 	}
@@ -163,6 +152,10 @@ func (o *Operation) AssignedOnlyAccess(gid GoogleID) bool {
 
 // AddPerm adds a new permission to an op
 func (o *Operation) AddPerm(gid GoogleID, teamID TeamID, perm string, zone Zone) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	if !o.ID.IsOwner(gid) {
 		err := fmt.Errorf("permission denied: not current owner of op")
 		Log.Error(err.Error(), "GID", gid, "resource", o.ID)
@@ -201,6 +194,10 @@ func (o *Operation) AddPerm(gid GoogleID, teamID TeamID, perm string, zone Zone)
 
 // DelPerm removes a permission from an op
 func (o *Operation) DelPerm(gid GoogleID, teamID TeamID, perm OpPermRole, zone Zone) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	if !o.ID.IsOwner(gid) {
 		err := fmt.Errorf("not current owner of op")
 		Log.Error(err.Error(), "GID", gid, "resource", o.ID)
@@ -0,0 +1,389 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ClaimPolicy lets agents without write access assign pending tasks in an op to themselves
+type ClaimPolicy struct {
+	Enabled   bool         `json:"enabled"`
+	Links     bool         `json:"links"`     // links may be claimed, not only markers
+	Types     []MarkerType `json:"types"`     // marker types which may be claimed, empty for all
+	Zones     []Zone       `json:"zones"`     // zones in which tasks may be claimed, empty for all
+	MaxClaims int          `json:"maxClaims"` // open claims per agent, 0 for no limit
+}
+
+// Claim is a task an agent assigned to themselves under the op's claim policy
+type Claim struct {
+	Kind    string   `json:"kind"` // marker or link
+	TaskID  string   `json:"taskID"`
+	GID     GoogleID `json:"gid"`
+	Claimed string   `json:"claimed"`
+}
+
+const (
+	claimMarker = "marker"
+	claimLink   = "link"
+)
+
+// SetClaimPolicy sets the self-service claiming rules for an op, only an owner may set them
+func (opID OperationID) SetClaimPolicy(gid GoogleID, p ClaimPolicy) error {
+	if !opID.IsOwner(gid) {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+		return err
+	}
+
+	if p.MaxClaims < 0 {
+		p.MaxClaims = 0
+	}
+	var types, zones []string
+	for _, t := range p.Types {
+		types = append(types, string(t))
+	}
+	for _, z := range p.Zones {
+		if !z.Valid() {
+			err := fmt.Errorf("invalid zone: %d", z)
+			Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+			return err
+		}
+		zones = append(zones, strconv.Itoa(int(z)))
+	}
+
+	_, err := db.Exec("REPLACE INTO opclaimpolicy (opID, enabled, links, types, zones, maxclaims) VALUES (?, ?, ?, ?, ?, ?)",
+		opID, p.Enabled, p.Links, MakeNullString(strings.Join(types, ",")), MakeNullString(strings.Join(zones, ",")), p.MaxClaims)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	Log.Infow("claim policy set", "GID", gid, "resource", opID, "enabled", p.Enabled)
+	return nil
+}
+
+// ClaimPolicy returns the op's claim policy, nil if none is set
+func (opID OperationID) ClaimPolicy() (*ClaimPolicy, error) {
+	var p ClaimPolicy
+	var types, zones sql.NullString
+	err := db.QueryRow("SELECT enabled, links, types, zones, maxclaims FROM opclaimpolicy WHERE opID = ?", opID).Scan(&p.Enabled, &p.Links, &types, &zones, &p.MaxClaims)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		Log.Error(err)
+		return nil, err
+	}
+
+	p.Types = make([]MarkerType, 0)
+	for _, t := range strings.Split(types.String, ",") {
+		if t != "" {
+			p.Types = append(p.Types, MarkerType(t))
+		}
+	}
+	p.Zones = make([]Zone, 0)
+	for _, z := range strings.Split(zones.String, ",") {
+		if z != "" {
+			p.Zones = append(p.Zones, ZoneFromString(z))
+		}
+	}
+	return &p, nil
+}
+
+// Claims lists the open claims on an op, only an owner may see them
+func (opID OperationID) Claims(gid GoogleID) ([]Claim, error) {
+	claims := make([]Claim, 0)
+	if !opID.IsOwner(gid) {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+		return claims, err
+	}
+
+	rows, err := db.Query("SELECT kind, taskID, gid, claimed FROM opclaims WHERE opID = ? ORDER BY claimed", opID)
+	if err != nil {
+		Log.Error(err)
+		return claims, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Claim
+		if err := rows.Scan(&c.Kind, &c.TaskID, &c.GID, &c.Claimed); err != nil {
+			Log.Error(err)
+			continue
+		}
+		claims = append(claims, c)
+	}
+	return claims, nil
+}
+
+// permits checks the policy allows claiming a task of the given kind, type and zone
+func (p *ClaimPolicy) permits(kind string, t MarkerType, z Zone) bool {
+	if p == nil || !p.Enabled {
+		return false
+	}
+	if kind == claimLink && !p.Links {
+		return false
+	}
+	if kind == claimMarker && len(p.Types) > 0 {
+		found := false
+		for _, pt := range p.Types {
+			if pt == t || NewMarkerType(pt) == NewMarkerType(t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(p.Zones) > 0 && !z.inZones(p.Zones) {
+		return false
+	}
+	return true
+}
+
+// ClaimMarker assigns a pending marker to the agent under the op's claim policy
+func (o *Operation) ClaimMarker(gid GoogleID, markerID MarkerID) (string, error) {
+	var t MarkerType
+	var z Zone
+	var state string
+	var assigned sql.NullString
+	err := db.QueryRow("SELECT type, zone, state, gid FROM marker WHERE ID = ? AND opID = ?", markerID, o.ID).Scan(&t, &z, &state, &assigned)
+	if err == sql.ErrNoRows {
+		err := fmt.Errorf("marker not found")
+		Log.Warnw(err.Error(), "GID", gid, "resource", o.ID, "marker", markerID)
+		return "", err
+	}
+	if err != nil {
+		Log.Error(err)
+		return "", err
+	}
+	if state != "pending" || assigned.Valid {
+		err := fmt.Errorf("marker is not available to claim")
+		Log.Infow(err.Error(), "GID", gid, "resource", o.ID, "marker", markerID)
+		return "", err
+	}
+
+	limit, err := o.checkClaim(gid, claimMarker, t, z)
+	if err != nil {
+		return "", err
+	}
+
+	// only one of several agents claiming at once gets the marker
+	if err := o.claimTask(gid, claimMarker, string(markerID), limit, "UPDATE marker SET gid = ?, state = 'assigned' WHERE ID = ? AND opID = ? AND gid IS NULL AND state = 'pending'"); err != nil {
+		return "", err
+	}
+	o.notifyClaim(gid, claimMarker, string(markerID))
+	o.ID.clearArrival(claimMarker, string(markerID))
+	o.ID.firebaseAssignMarker(gid, markerID)
+	return o.Touch()
+}
+
+// ClaimLink assigns an unassigned, incomplete link to the agent under the op's claim policy
+func (o *Operation) ClaimLink(gid GoogleID, linkID LinkID) (string, error) {
+	var z Zone
+	var completed bool
+	var assigned sql.NullString
+	err := db.QueryRow("SELECT zone, completed, gid FROM link WHERE ID = ? AND opID = ?", linkID, o.ID).Scan(&z, &completed, &assigned)
+	if err == sql.ErrNoRows {
+		err := fmt.Errorf("link not found")
+		Log.Warnw(err.Error(), "GID", gid, "resource", o.ID, "link", linkID)
+		return "", err
+	}
+	if err != nil {
+		Log.Error(err)
+		return "", err
+	}
+	if completed || assigned.Valid {
+		err := fmt.Errorf("link is not available to claim")
+		Log.Infow(err.Error(), "GID", gid, "resource", o.ID, "link", linkID)
+		return "", err
+	}
+
+	limit, err := o.checkClaim(gid, claimLink, "", z)
+	if err != nil {
+		return "", err
+	}
+
+	if err := o.claimTask(gid, claimLink, string(linkID), limit, "UPDATE link SET gid = ? WHERE ID = ? AND opID = ? AND gid IS NULL AND completed = 0"); err != nil {
+		return "", err
+	}
+	o.notifyClaim(gid, claimLink, string(linkID))
+	o.ID.clearArrival(claimLink, string(linkID))
+	o.ID.firebaseAssignLink(gid, linkID)
+	return o.Touch()
+}
+
+// RevokeClaim removes an agent's claim on a task and unassigns it, only an owner may revoke claims
+func (o *Operation) RevokeClaim(gid GoogleID, kind, taskID string) (string, error) {
+	if !o.ID.IsOwner(gid) {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "resource", o.ID)
+		return "", err
+	}
+
+	if kind != claimMarker && kind != claimLink {
+		err := fmt.Errorf("unknown claim kind: %s", kind)
+		Log.Warnw(err.Error(), "GID", gid, "resource", o.ID)
+		return "", err
+	}
+
+	var claimant GoogleID
+	err := db.QueryRow("SELECT gid FROM opclaims WHERE opID = ? AND kind = ? AND taskID = ?", o.ID, kind, taskID).Scan(&claimant)
+	if err == sql.ErrNoRows {
+		err := fmt.Errorf("no such claim")
+		Log.Infow(err.Error(), "GID", gid, "resource", o.ID, "task", taskID)
+		return "", err
+	}
+	if err != nil {
+		Log.Error(err)
+		return "", err
+	}
+
+	if _, err := db.Exec("DELETE FROM opclaims WHERE opID = ? AND kind = ? AND taskID = ?", o.ID, kind, taskID); err != nil {
+		Log.Error(err)
+		return "", err
+	}
+
+	var uid string
+	if kind == claimLink {
+		uid, err = o.AssignLink(LinkID(taskID), "")
+	} else {
+		uid, err = o.AssignMarker(MarkerID(taskID), "")
+		if err == nil {
+			_, err = db.Exec("UPDATE marker SET state = 'pending' WHERE ID = ? AND opID = ?", taskID, o.ID)
+		}
+	}
+	if err != nil {
+		Log.Error(err)
+		return "", err
+	}
+
+	s, _ := o.ID.Stat()
+	msg := fmt.Sprintf("your claim on a %s in %s was revoked by the owner", kind, s.Name)
+	if _, err := claimant.SendMessage(msg); err != nil {
+		Log.Error(err)
+	}
+	claimant.FirebaseGenericMessage(msg)
+	Log.Infow("claim revoked", "GID", gid, "resource", o.ID, "task", taskID, "claimant", claimant)
+	return uid, nil
+}
+
+// checkClaim verifies the agent may claim a task of this kind, type and zone in the op, returning the agent's claim limit, 0 for none
+func (o *Operation) checkClaim(gid GoogleID, kind string, t MarkerType, z Zone) (int, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return 0, err
+	}
+
+	// agents with write access can assign anything, including to themselves
+	if o.WriteAccess(gid) {
+		return 0, nil
+	}
+
+	read, zones := o.ReadAccess(gid)
+	if !read && !o.AssignedOnlyAccess(gid) {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "resource", o.ID)
+		return 0, err
+	}
+	if read && !z.inZones(zones) {
+		err := fmt.Errorf("task not in a zone you can see")
+		Log.Warnw(err.Error(), "GID", gid, "resource", o.ID)
+		return 0, err
+	}
+
+	p, err := o.ID.ClaimPolicy()
+	if err != nil {
+		return 0, err
+	}
+	if !p.permits(kind, t, z) {
+		err := fmt.Errorf("the op does not allow claiming this task")
+		Log.Infow(err.Error(), "GID", gid, "resource", o.ID, "kind", kind, "type", t, "zone", z)
+		return 0, err
+	}
+	return p.MaxClaims, nil
+}
+
+// claimTask assigns the task with the conditional update, which takes the gid, task ID and op ID, and records the claim.
+// The limit check runs in the same transaction with the agent's claims locked, so parallel claims cannot pass it together.
+func (o *Operation) claimTask(gid GoogleID, kind, taskID string, limit int, update string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && err != sql.ErrTxDone {
+			Log.Error(err)
+		}
+	}()
+
+	if limit > 0 {
+		// the agent row queues claims by an agent who has none yet, there are no claim rows to lock
+		var locked GoogleID
+		if err := tx.QueryRow("SELECT gid FROM agent WHERE gid = ? FOR UPDATE", gid).Scan(&locked); err != nil {
+			Log.Error(err)
+			return err
+		}
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM opclaims=c WHERE c.opID = ? AND c.gid = ? AND ("+
+			"(c.kind = 'marker' AND EXISTS (SELECT 1 FROM marker WHERE ID = c.taskID AND opID = c.opID AND gid = c.gid AND state != 'completed')) OR "+
+			"(c.kind = 'link' AND EXISTS (SELECT 1 FROM link WHERE ID = c.taskID AND opID = c.opID AND gid = c.gid AND completed = 0))) FOR UPDATE", o.ID, gid).Scan(&count); err != nil {
+			Log.Error(err)
+			return err
+		}
+		if count >= limit {
+			err := fmt.Errorf("claim limit of %d reached", limit)
+			Log.Infow(err.Error(), "GID", gid, "resource", o.ID)
+			return err
+		}
+	}
+
+	result, err := tx.Exec(update, gid, taskID, o.ID)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if ra, _ := result.RowsAffected(); ra != 1 {
+		err := fmt.Errorf("%s is not available to claim", kind)
+		Log.Infow(err.Error(), "GID", gid, "resource", o.ID, kind, taskID)
+		return err
+	}
+
+	if _, err := tx.Exec("REPLACE INTO opclaims (opID, kind, taskID, gid) VALUES (?, ?, ?, ?)", o.ID, kind, taskID, gid); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// clearClaim forgets a claim when the task is assigned by other means
+func (opID OperationID) clearClaim(kind, taskID string) {
+	if _, err := db.Exec("DELETE FROM opclaims WHERE opID = ? AND kind = ? AND taskID = ?", opID, kind, taskID); err != nil {
+		Log.Error(err)
+	}
+}
+
+// notifyClaim tells the owner about a new claim
+func (o *Operation) notifyClaim(gid GoogleID, kind, taskID string) {
+	s, err := o.ID.Stat()
+	if err != nil {
+		return
+	}
+	if s.Gid != gid {
+		iname, _ := gid.IngressName()
+		msg := fmt.Sprintf("%s claimed a %s in %s", iname, kind, s.Name)
+		if _, err := s.Gid.SendMessage(msg); err != nil {
+			Log.Error(err)
+		}
+		s.Gid.FirebaseGenericMessage(msg)
+	}
+	Log.Infow("task claimed", "GID", gid, "resource", o.ID, "kind", kind, "task", taskID)
+}
@@ -0,0 +1,78 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestClaims(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(j, &in); err != nil {
+		t.Error(err.Error())
+	}
+	if len(in.Links) == 0 {
+		t.Fatal("no links in test op")
+	}
+
+	p := wasabee.ClaimPolicy{
+		Enabled:   true,
+		Links:     true,
+		Zones:     []wasabee.Zone{wasabee.ZoneAll},
+		MaxClaims: 1,
+	}
+	if err := in.ID.SetClaimPolicy(wasabee.GoogleID("0"), p); err == nil {
+		t.Error("non-owner able to set claim policy")
+	}
+	if err := in.ID.SetClaimPolicy(gid, p); err != nil {
+		t.Error(err.Error())
+	}
+	got, err := in.ID.ClaimPolicy()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if got == nil || !got.Enabled || !got.Links || got.MaxClaims != 1 || len(got.Zones) != 1 {
+		t.Error("claim policy not stored")
+	}
+
+	link := string(in.Links[0].ID)
+	if _, err := in.ClaimLink(gid, in.Links[0].ID); err != nil {
+		t.Error(err.Error())
+	}
+	if _, err := in.ClaimLink(gid, in.Links[0].ID); err == nil {
+		t.Error("assigned link claimed twice")
+	}
+
+	claims, err := in.ID.Claims(gid)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(claims) != 1 || claims[0].TaskID != link || claims[0].GID != gid {
+		t.Error("claim not recorded")
+	}
+
+	if _, err := in.RevokeClaim(wasabee.GoogleID("0"), "link", link); err == nil {
+		t.Error("non-owner able to revoke claim")
+	}
+	if _, err := in.RevokeClaim(gid, "link", link); err != nil {
+		t.Error(err.Error())
+	}
+	if claims, _ := in.ID.Claims(gid); len(claims) != 0 {
+		t.Error("claim not revoked")
+	}
+
+	if err := in.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
@@ -0,0 +1,197 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// CloneOptions control what is carried over when an operation is cloned
+type CloneOptions struct {
+	Name        string
+	Assignments bool
+	Completion  bool
+	Keys        bool
+	Teams       bool
+}
+
+// SetTemplate flags an op as a template which any agent with read access may clone
+func (opID OperationID) SetTemplate(gid GoogleID, template bool) error {
+	if !opID.IsOwner(gid) {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+		return err
+	}
+
+	if _, err := db.Exec("UPDATE operation SET template = ? WHERE ID = ?", template, opID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// IsTemplate reports if an op has been flagged as a template
+func (opID OperationID) IsTemplate() bool {
+	var template bool
+	if err := db.QueryRow("SELECT template FROM operation WHERE ID = ?", opID).Scan(&template); err != nil {
+		if err != sql.ErrNoRows {
+			Log.Error(err)
+		}
+		return false
+	}
+	return template
+}
+
+// Clone copies an operation into a new operation owned by gid, with fresh link and marker IDs.
+// Write access is required unless the op is a template, then read access is sufficient.
+func (opID OperationID) Clone(gid GoogleID, opts CloneOptions) (OperationID, error) {
+	var o Operation
+	o.ID = opID
+
+	writer := o.WriteAccess(gid)
+	if writer {
+		if err := o.populateComplete(); err != nil {
+			Log.Error(err)
+			return "", err
+		}
+	} else {
+		read, _ := o.ReadAccess(gid)
+		if !read || !opID.IsTemplate() {
+			err := fmt.Errorf("permission to clone op denied")
+			Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+			return "", err
+		}
+		// readers only get the zones they can see
+		if err := o.Populate(gid); err != nil {
+			Log.Error(err)
+			return "", err
+		}
+	}
+	teams := o.Teams
+
+	o.ID = OperationID(GenerateID(40))
+	if opts.Name != "" {
+		o.Name = opts.Name
+	} else {
+		o.Name = fmt.Sprintf("%s (copy)", o.Name)
+	}
+
+	for i := range o.Links {
+		l := &o.Links[i]
+		l.ID = LinkID(GenerateID(32))
+		if !opts.Assignments {
+			l.AssignedTo = ""
+		}
+		if !opts.Completion {
+			l.Completed = false
+		}
+	}
+
+	for i := range o.Markers {
+		m := &o.Markers[i]
+		m.ID = MarkerID(GenerateID(32))
+		if !opts.Completion && m.State == "completed" {
+			m.State = "assigned"
+			m.CompletedID = ""
+		}
+		if !opts.Assignments {
+			m.AssignedTo = ""
+		}
+		if m.AssignedTo == "" && m.State != "completed" {
+			m.State = "pending"
+		}
+	}
+
+	// key counts live in the agents' inventories, cloning copies who shares them instead
+	o.Keys = nil
+
+	if err := drawOpInsertWorker(o, gid); err != nil {
+		Log.Error(err)
+		return "", err
+	}
+
+	// readers cloning a template only carry over their own inventory
+	if opts.Keys {
+		q := "INSERT IGNORE INTO opkeyshare (opID, gid) SELECT ?, gid FROM opkeyshare WHERE opID = ?"
+		args := []interface{}{o.ID, opID}
+		if !writer {
+			q += " AND gid = ?"
+			args = append(args, gid)
+		}
+		if _, err := db.Exec(q, args...); err != nil {
+			Log.Error(err)
+		}
+	}
+
+	// insertMarker does not carry the completing agent
+	if opts.Completion {
+		for _, m := range o.Markers {
+			if m.CompletedID == "" {
+				continue
+			}
+			if _, err := db.Exec("UPDATE marker SET completedby = ? WHERE ID = ? AND opID = ?", m.CompletedID, m.ID, o.ID); err != nil {
+				Log.Error(err)
+			}
+		}
+	}
+
+	// same rule as AddPerm: only teams the agent is on
+	if opts.Teams {
+		for _, t := range teams {
+			if inteam, _ := gid.AgentInTeam(t.TeamID); !inteam {
+				Log.Infow("not on team, permission not cloned", "GID", gid, "resource", o.ID, "team", t.TeamID)
+				continue
+			}
+			if _, err := db.Exec("INSERT INTO opteams VALUES (?,?,?,?)", t.TeamID, o.ID, t.Role, t.Zone); err != nil {
+				Log.Error(err)
+			}
+		}
+	}
+
+	Log.Infow("op cloned", "GID", gid, "resource", o.ID, "source", opID)
+	return o.ID, nil
+}
+
+// populateComplete fills in the entire operation without any permission checks or zone filtering
+func (o *Operation) populateComplete() error {
+	var comment sql.NullString
+	err := db.QueryRow("SELECT name, gid, color, modified, comment FROM operation WHERE ID = ?", o.ID).Scan(&o.Name, &o.Gid, &o.Color, &o.Modified, &comment)
+	if err != nil && err == sql.ErrNoRows {
+		err = fmt.Errorf("operation not found")
+		Log.Warnw(err.Error(), "resource", o.ID)
+		return err
+	}
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if comment.Valid {
+		o.Comment = comment.String
+	}
+
+	zones := []Zone{ZoneAll}
+	if err := o.PopulateTeams(); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if err := o.populatePortals(); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if err := o.populateMarkers(zones, ""); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if err := o.populateLinks(zones, ""); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if err := o.populateKeys(); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if err := o.populateZones(); err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestOpClone(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test3.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(j, &in); err != nil {
+		t.Error(err.Error())
+	}
+
+	newID, err := in.ID.Clone(gid, wasabee.CloneOptions{Name: "cloned"})
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	var src, clone wasabee.Operation
+	src.ID = in.ID
+	clone.ID = newID
+	if err := src.Populate(gid); err != nil {
+		t.Error(err.Error())
+	}
+	if err := clone.Populate(gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	if clone.Name != "cloned" {
+		t.Error("clone name not set")
+	}
+	if len(clone.Links) != len(src.Links) || len(clone.Markers) != len(src.Markers) || len(clone.OpPortals) != len(src.OpPortals) {
+		t.Error("clone contents do not match source")
+	}
+	for _, m := range clone.Markers {
+		if m.AssignedTo != "" || m.State != "pending" {
+			t.Error("clone kept marker assignment")
+		}
+	}
+
+	if len(clone.Keys) != 0 {
+		t.Error("clone shares keys without the keys option")
+	}
+
+	// the source op has the agent's keys shared with it, cloning with keys carries that over
+	if _, err := src.KeyOnHand(gid, src.OpPortals[0].ID, 3, ""); err != nil {
+		t.Error(err.Error())
+	}
+	keyID, err := in.ID.Clone(gid, wasabee.CloneOptions{Keys: true})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	var keyClone wasabee.Operation
+	keyClone.ID = keyID
+	if err := keyClone.Populate(gid); err != nil {
+		t.Error(err.Error())
+	}
+	if len(keyClone.Keys) != 1 || keyClone.Keys[0].Onhand != 3 {
+		t.Errorf("clone did not share keys: %+v", keyClone.Keys)
+	}
+	if err := keyClone.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+	if err := gid.SetKeyCount(src.OpPortals[0].ID, "", 0); err != nil {
+		t.Error(err.Error())
+	}
+
+	if err := src.ID.SetTemplate(gid, true); err != nil {
+		t.Error(err.Error())
+	}
+	if !src.ID.IsTemplate() {
+		t.Error("template flag not set")
+	}
+
+	if err := clone.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+	if err := src.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
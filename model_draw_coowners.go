@@ -0,0 +1,77 @@
+package wasabee
+
+import (
+	"fmt"
+)
+
+// AddCoOwner grants an agent the same powers as the owner, except deleting the op or changing the primary owner
+func (opID OperationID) AddCoOwner(gid GoogleID, to string) error {
+	if !opID.IsOwner(gid) {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+		return err
+	}
+
+	togid, err := ToGid(to)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	if x, err := togid.IngressName(); x == "" || err != nil {
+		err := fmt.Errorf("unknown user")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID, "to", to)
+		return err
+	}
+
+	if opID.IsPrimaryOwner(togid) {
+		err := fmt.Errorf("agent is already the owner")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID, "to", togid)
+		return err
+	}
+
+	if _, err := db.Exec("INSERT IGNORE INTO opcoowners (opID, gid) VALUES (?, ?)", opID, togid); err != nil {
+		Log.Error(err)
+		return err
+	}
+	Log.Infow("op co-owner added", "GID", gid, "resource", opID, "coowner", togid)
+	return nil
+}
+
+// RemoveCoOwner revokes an agent's co-ownership of an op, the primary owner cannot be removed
+func (opID OperationID) RemoveCoOwner(gid GoogleID, coowner GoogleID) error {
+	if !opID.IsOwner(gid) {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+		return err
+	}
+
+	if _, err := db.Exec("DELETE FROM opcoowners WHERE opID = ? AND gid = ?", opID, coowner); err != nil {
+		Log.Error(err)
+		return err
+	}
+	Log.Infow("op co-owner removed", "GID", gid, "resource", opID, "coowner", coowner)
+	return nil
+}
+
+// CoOwners lists the co-owners of an op
+func (opID OperationID) CoOwners() ([]GoogleID, error) {
+	coowners := make([]GoogleID, 0)
+
+	rows, err := db.Query("SELECT gid FROM opcoowners WHERE opID = ?", opID)
+	if err != nil {
+		Log.Error(err)
+		return coowners, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var gid GoogleID
+		if err := rows.Scan(&gid); err != nil {
+			Log.Error(err)
+			continue
+		}
+		coowners = append(coowners, gid)
+	}
+	return coowners, nil
+}
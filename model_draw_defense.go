@@ -0,0 +1,203 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// PortalDefense is the observed defensive state of a portal, used to plan destroy markers
+type PortalDefense struct {
+	Bursters int    `json:"bursters"` // estimated bursters required to destroy
+	Shields  int    `json:"shields"`
+	LinkAmps int    `json:"linkAmps"`
+	Virus    string `json:"virus"` // "", "ADA" or "JARVIS"
+	Owner    string `json:"owner"` // ingress name of the owning (enemy) agent
+	Observed string `json:"observed"`
+}
+
+// AttackTarget is a destroy marker's portal and its defense
+type AttackTarget struct {
+	MarkerID MarkerID       `json:"markerID"`
+	PortalID PortalID       `json:"portalID"`
+	Name     string         `json:"name"`
+	Defense  *PortalDefense `json:"defense"`
+}
+
+// AttackPlan is the total resources needed for an op's destroy markers, targets sorted hardest first
+type AttackPlan struct {
+	Bursters int            `json:"bursters"`
+	ADA      int            `json:"ada"`
+	JARVIS   int            `json:"jarvis"`
+	Unknown  int            `json:"unknown"`
+	Targets  []AttackTarget `json:"targets"`
+}
+
+const (
+	defenseMaxMods     = 4
+	defenseMaxBursters = 1000
+)
+
+// Validate checks a PortalDefense for sane values, setting Observed to now if it is not set
+func (d *PortalDefense) Validate() error {
+	if d.Bursters < 0 || d.Bursters > defenseMaxBursters {
+		return fmt.Errorf("bursters must be between 0 and %d", defenseMaxBursters)
+	}
+	if d.Shields < 0 || d.LinkAmps < 0 || d.Shields+d.LinkAmps > defenseMaxMods {
+		return fmt.Errorf("a portal has at most %d mods", defenseMaxMods)
+	}
+	switch d.Virus {
+	case "", "ADA", "JARVIS":
+	default:
+		return fmt.Errorf("unknown virus: %s", d.Virus)
+	}
+	if len(d.Owner) > 64 {
+		return fmt.Errorf("owner name too long")
+	}
+
+	if d.Observed == "" {
+		d.Observed = time.Now().UTC().Format(time.RFC3339)
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, d.Observed)
+	if err != nil {
+		return fmt.Errorf("observed must be RFC3339")
+	}
+	d.Observed = t.UTC().Format(time.RFC3339)
+	return nil
+}
+
+// PortalDefense sets the observed defense for a portal in the op
+func (o *Operation) PortalDefense(portalID PortalID, d PortalDefense) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
+	if err := d.Validate(); err != nil {
+		Log.Warnw(err.Error(), "resource", o.ID, "portal", portalID)
+		return "", err
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM portal WHERE ID = ? AND opID = ?", portalID, o.ID).Scan(&count); err != nil {
+		Log.Error(err)
+		return "", err
+	}
+	if count != 1 {
+		err := fmt.Errorf("portal %s not in op", portalID)
+		Log.Warnw(err.Error(), "resource", o.ID, "portal", portalID)
+		return "", err
+	}
+
+	if err := o.ID.updateDefense(db, portalID, &d); err != nil {
+		return "", err
+	}
+	return o.Touch()
+}
+
+func (opID OperationID) updateDefense(ex execer, portalID PortalID, d *PortalDefense) error {
+	if err := d.Validate(); err != nil {
+		Log.Warnw(err.Error(), "resource", opID, "portal", portalID)
+		return err
+	}
+
+	observed, _ := time.Parse(time.RFC3339, d.Observed)
+	_, err := ex.Exec("REPLACE INTO portaldefense (opID, portalID, bursters, shields, linkamps, virus, owner, observed) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		opID, portalID, d.Bursters, d.Shields, d.LinkAmps, MakeNullString(d.Virus), MakeNullString(d.Owner), observed.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+func (opID OperationID) deleteDefense(portalID PortalID) error {
+	if _, err := db.Exec("DELETE FROM portaldefense WHERE opID = ? AND portalID = ?", opID, portalID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// populateDefense fills in the Defense for each portal in the op
+func (o *Operation) populateDefense() error {
+	rows, err := db.Query("SELECT portalID, bursters, shields, linkamps, virus, owner, observed FROM portaldefense WHERE opID = ?", o.ID)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	defer rows.Close()
+
+	defenses := make(map[PortalID]*PortalDefense)
+	for rows.Next() {
+		var portalID PortalID
+		var virus, owner sql.NullString
+		var observed string
+		d := PortalDefense{}
+		if err := rows.Scan(&portalID, &d.Bursters, &d.Shields, &d.LinkAmps, &virus, &owner, &observed); err != nil {
+			Log.Error(err)
+			continue
+		}
+		d.Virus = virus.String
+		d.Owner = owner.String
+		if t, err := time.Parse("2006-01-02 15:04:05", observed); err == nil {
+			d.Observed = t.Format(time.RFC3339)
+		}
+		defenses[portalID] = &d
+	}
+
+	for i := range o.OpPortals {
+		o.OpPortals[i].Defense = defenses[o.OpPortals[i].ID]
+	}
+	return nil
+}
+
+// AttackPlan totals the resources needed for the destroy markers in a populated op.
+// Targets are sorted hardest first, portals with no observed defense are listed last.
+func (o *Operation) AttackPlan() AttackPlan {
+	plan := AttackPlan{
+		Targets: make([]AttackTarget, 0),
+	}
+
+	for _, m := range o.Markers {
+		if m.Type != "DestroyPortalAlert" && m.Type != "destroy" {
+			continue
+		}
+		p, _ := o.getPortal(m.PortalID)
+		t := AttackTarget{
+			MarkerID: m.ID,
+			PortalID: m.PortalID,
+			Name:     p.Name,
+			Defense:  p.Defense,
+		}
+		plan.Targets = append(plan.Targets, t)
+
+		if p.Defense == nil {
+			plan.Unknown++
+			continue
+		}
+		plan.Bursters += p.Defense.Bursters
+		switch p.Defense.Virus {
+		case "ADA":
+			plan.ADA++
+		case "JARVIS":
+			plan.JARVIS++
+		}
+	}
+
+	sort.SliceStable(plan.Targets, func(i, j int) bool {
+		a, b := plan.Targets[i].Defense, plan.Targets[j].Defense
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		if a.Bursters != b.Bursters {
+			return a.Bursters > b.Bursters
+		}
+		if a.Shields != b.Shields {
+			return a.Shields > b.Shields
+		}
+		return a.LinkAmps > b.LinkAmps
+	})
+	return plan
+}
@@ -0,0 +1,50 @@
+package wasabee_test
+
+import (
+	"testing"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestPortalDefense(t *testing.T) {
+	bad := []wasabee.PortalDefense{
+		{Bursters: -1},
+		{Shields: 3, LinkAmps: 2},
+		{Virus: "FLU"},
+		{Observed: "yesterday"},
+	}
+	for _, d := range bad {
+		if err := d.Validate(); err == nil {
+			t.Errorf("invalid defense accepted: %+v", d)
+		}
+	}
+
+	good := wasabee.PortalDefense{Bursters: 12, Shields: 2, LinkAmps: 2, Virus: "JARVIS"}
+	if err := good.Validate(); err != nil {
+		t.Error(err.Error())
+	}
+	if good.Observed == "" {
+		t.Error("observed not defaulted")
+	}
+
+	o := wasabee.Operation{
+		OpPortals: []wasabee.Portal{
+			{ID: "easy", Name: "easy", Defense: &wasabee.PortalDefense{Bursters: 4}},
+			{ID: "hard", Name: "hard", Defense: &good},
+			{ID: "unknown", Name: "unknown"},
+		},
+		Markers: []wasabee.Marker{
+			{ID: "m1", PortalID: "unknown", Type: "DestroyPortalAlert"},
+			{ID: "m2", PortalID: "easy", Type: "DestroyPortalAlert"},
+			{ID: "m3", PortalID: "hard", Type: "DestroyPortalAlert"},
+			{ID: "m4", PortalID: "hard", Type: "CapturePortalMarker"},
+		},
+	}
+	plan := o.AttackPlan()
+	if plan.Bursters != 16 || plan.JARVIS != 1 || plan.Unknown != 1 || len(plan.Targets) != 3 {
+		t.Errorf("attack plan totals wrong: %+v", plan)
+	}
+	if plan.Targets[0].PortalID != "hard" || plan.Targets[2].PortalID != "unknown" {
+		t.Error("attack plan not sorted by difficulty")
+	}
+}
@@ -1,11 +1,5 @@
 package wasabee
 
-import (
-	"database/sql"
-	"fmt"
-	"strings"
-)
-
 // KeyOnHand describes the already in possession for the op
 type KeyOnHand struct {
 	ID      PortalID `json:"portalId"`
@@ -26,54 +20,69 @@ func (o *Operation) insertKey(k KeyOnHand) (string, error) {
 		return "", nil
 	}
 
-	if k.Onhand == 0 {
-		if _, err = db.Exec("DELETE FROM opkeys WHERE opID = ? AND portalID = ? and gid = ?", o.ID, k.ID, k.Gid); err != nil {
-			Log.Info(err)
-			err := fmt.Errorf("unable to remove key count for portal")
-			return "", err
+	if err := k.Gid.SetKeyCount(k.ID, k.Capsule, k.Onhand); err != nil {
+		return "", err
+	}
+	// reporting keys to an op shares the agent's inventory with it
+	if _, err := db.Exec("INSERT IGNORE INTO opkeyshare (opID, gid) VALUES (?, ?)", o.ID, k.Gid); err != nil {
+		Log.Error(err)
+		return "", err
+	}
+	return o.Touch()
+}
+
+// uploadKeys stores the uploading agent's own counts from keysonhand in their key inventory and shares it with the op.
+// Counts for other agents are what the op was populated with; those agents report their own keys.
+func (o *Operation) uploadKeys(gid GoogleID, portalMap map[PortalID]Portal) {
+	shared := false
+	for _, k := range o.Keys {
+		if k.Gid != gid && k.Gid != "" {
+			continue
 		}
-	} else {
-		_, err = db.Exec("INSERT INTO opkeys (opID, portalID, gid, onhand, capsule) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE onhand = ?, capsule = ?",
-			o.ID, k.ID, k.Gid, k.Onhand, MakeNullString(k.Capsule), k.Onhand, MakeNullString(k.Capsule))
-		if err != nil && strings.Contains(err.Error(), "Error 1452") {
-			Log.Info(err)
-			err := fmt.Errorf("unable to record keys, ensure the op on the server is up-to-date")
-			return "", err
+		if _, ok := portalMap[k.ID]; !ok {
+			Log.Infow("attempt to assign key count to portal not in op", "GID", gid, "resource", o.ID, "portal", k.ID)
+			continue
 		}
-		if err != nil {
-			Log.Error(err)
-			return "", err
+		if err := gid.SetKeyCount(k.ID, k.Capsule, k.Onhand); err != nil {
+			continue
 		}
+		shared = true
+	}
+	if !shared {
+		return
+	}
+	if _, err := db.Exec("INSERT IGNORE INTO opkeyshare (opID, gid) VALUES (?, ?)", o.ID, gid); err != nil {
+		Log.Error(err)
 	}
-	return o.Touch()
 }
 
-// PopulateKeys fills in the Keys on hand list for the Operation. No authorization takes place.
+// opReaders is the set of agents who can read an op: the owner, co-owners and members of the op's teams and their sub-teams, it takes the opID three times
+const opReaders = "SELECT gid FROM operation WHERE ID = ? UNION SELECT gid FROM opcoowners WHERE opID = ? UNION SELECT x.gid FROM agentteams=x, opteams=t WHERE t.opID = ? AND x.suspended = 0 AND (x.teamID = t.teamID OR x.teamID IN (SELECT descendant FROM teamtree WHERE ancestor = t.teamID))"
+
+// PopulateKeys fills in the Keys on hand list for the Operation, one entry per capsule, from the key inventories of agents
+// who can see the op and have shared their keys with it. No authorization takes place.
 func (o *Operation) populateKeys() error {
 	var k KeyOnHand
-	rows, err := db.Query("SELECT portalID, gid, onhand, capsule FROM opkeys WHERE opID = ?", o.ID)
+	rows, err := db.Query("SELECT k.portalID, k.gid, k.count, k.capsule FROM keyinventory=k, portal=p, opkeyshare=s "+
+		"WHERE p.opID = ? AND k.portalID = p.ID AND s.opID = p.opID AND s.gid = k.gid AND k.gid IN ("+opReaders+")", o.ID, o.ID, o.ID, o.ID)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
 
-	var cap sql.NullString
 	defer rows.Close()
 	for rows.Next() {
-		err := rows.Scan(&k.ID, &k.Gid, &k.Onhand, &cap)
+		err := rows.Scan(&k.ID, &k.Gid, &k.Onhand, &k.Capsule)
 		if err != nil {
 			Log.Error(err)
 			continue
 		}
-		if cap.Valid {
-			k.Capsule = cap.String
-		}
 		o.Keys = append(o.Keys, k)
 	}
 	return nil
 }
 
-// KeyOnHand updates a user's key-count for linking
+// KeyOnHand updates a user's key-count for linking, the count is stored in the agent's key inventory
 func (o *Operation) KeyOnHand(gid GoogleID, portalID PortalID, count int32, capsule string) (string, error) {
 	k := KeyOnHand{
 		ID:      portalID,
@@ -84,3 +93,12 @@ func (o *Operation) KeyOnHand(gid GoogleID, portalID PortalID, count int32, caps
 
 	return o.insertKey(k)
 }
+
+// StopSharingKeys withdraws the agent's key inventory from the op, the inventory itself is left untouched
+func (o *Operation) StopSharingKeys(gid GoogleID) (string, error) {
+	if _, err := db.Exec("DELETE FROM opkeyshare WHERE opID = ? AND gid = ?", o.ID, gid); err != nil {
+		Log.Error(err)
+		return "", err
+	}
+	return o.Touch()
+}
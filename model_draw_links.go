@@ -23,10 +23,11 @@ type Link struct {
 	Completed  bool     `json:"completed"`
 	Color      string   `json:"color"`
 	Zone       Zone     `json:"zone"`
+	OnSite     bool     `json:"onsite,omitempty"` // the assignee has arrived at the origin portal
 }
 
 // insertLink adds a link to the database
-func (opID OperationID) insertLink(l Link) error {
+func (opID OperationID) insertLink(ex execer, l Link) error {
 	if l.To == l.From {
 		Log.Infow("source and destination the same, ignoring link", "resource", opID)
 		return nil
@@ -36,7 +37,7 @@ func (opID OperationID) insertLink(l Link) error {
 		l.Zone = zonePrimary
 	}
 
-	_, err := db.Exec("INSERT INTO link (ID, fromPortalID, toPortalID, opID, description, gid, throworder, completed, color, zone) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	_, err := ex.Exec("INSERT INTO link (ID, fromPortalID, toPortalID, opID, description, gid, throworder, completed, color, zone) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		l.ID, l.From, l.To, opID, MakeNullString(l.Desc), MakeNullString(l.AssignedTo), l.ThrowOrder, l.Completed, l.Color, l.Zone)
 	if err != nil {
 		Log.Error(err)
@@ -81,14 +82,14 @@ func (o *Operation) populateLinks(zones []Zone, inGid GoogleID) error {
 
 	var err error
 	var rows *sql.Rows
-	rows, err = db.Query("SELECT l.ID, l.fromPortalID, l.toPortalID, l.description, l.gid, l.throworder, l.completed, a.iname, l.color, l.zone FROM link=l LEFT JOIN agent=a ON l.gid=a.gid WHERE l.opID = ? ORDER BY l.throworder", o.ID)
+	rows, err = db.Query("SELECT l.ID, l.fromPortalID, l.toPortalID, l.description, l.gid, l.throworder, l.completed, a.iname, l.color, l.zone, EXISTS (SELECT 1 FROM arrival WHERE opID = l.opID AND kind = 'link' AND taskID = l.ID) FROM link=l LEFT JOIN agent=a ON l.gid=a.gid WHERE l.opID = ? ORDER BY l.throworder", o.ID)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		err := rows.Scan(&tmpLink.ID, &tmpLink.From, &tmpLink.To, &description, &gid, &tmpLink.ThrowOrder, &tmpLink.Completed, &iname, &tmpLink.Color, &tmpLink.Zone)
+		err := rows.Scan(&tmpLink.ID, &tmpLink.From, &tmpLink.To, &description, &gid, &tmpLink.ThrowOrder, &tmpLink.Completed, &iname, &tmpLink.Color, &tmpLink.Zone, &tmpLink.OnSite)
 		if err != nil {
 			Log.Error(err)
 			continue
@@ -139,6 +140,8 @@ func (o *Operation) AssignLink(linkID LinkID, gid GoogleID) (string, error) {
 		Log.Debugw("AssignLink rows changed", "rows", ra, "resource", o.ID, "GID", gid, "link", linkID)
 		return "", nil
 	}
+	o.ID.clearArrival(claimLink, string(linkID))
+	o.ID.clearClaim(claimLink, string(linkID))
 
 	if gid != "" {
 		o.ID.firebaseAssignLink(gid, linkID)
@@ -148,6 +151,10 @@ func (o *Operation) AssignLink(linkID LinkID, gid GoogleID) (string, error) {
 
 // LinkDescription updates the description for a link
 func (o *Operation) LinkDescription(linkID LinkID, desc string) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	_, err := db.Exec("UPDATE link SET description = ? WHERE ID = ? AND opID = ?", MakeNullString(desc), linkID, o.ID)
 	if err != nil {
 		Log.Error(err)
@@ -184,6 +191,10 @@ func (opID OperationID) AssignedTo(link LinkID, gid GoogleID) bool {
 
 // LinkOrder changes the order of the throws for an operation
 func (o *Operation) LinkOrder(order string, gid GoogleID) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	// check isowner (already done in http/pdraw.go, but there may be other callers in the future
 
 	stmt, err := db.Prepare("UPDATE link SET throworder = ? WHERE opID = ? AND ID = ?")
@@ -209,6 +220,10 @@ func (o *Operation) LinkOrder(order string, gid GoogleID) (string, error) {
 
 // LinkColor changes the color of a link in an operation
 func (o *Operation) LinkColor(link LinkID, color string) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	_, err := db.Exec("UPDATE link SET color = ? WHERE ID = ? and opID = ?", color, link, o.ID)
 	if err != nil {
 		Log.Error(err)
@@ -219,6 +234,10 @@ func (o *Operation) LinkColor(link LinkID, color string) (string, error) {
 
 // LinkSwap changes the direction of a link in an operation
 func (o *Operation) LinkSwap(link LinkID) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	var tmpLink Link
 
 	err := db.QueryRow("SELECT fromPortalID, toPortalID FROM link WHERE opID = ? AND ID = ?", o.ID, link).Scan(&tmpLink.From, &tmpLink.To)
@@ -291,6 +310,10 @@ func (o *Operation) getLink(linkID LinkID) (Link, error) {
 
 // SetZone sets a link's zone -- caller must authorize
 func (l LinkID) SetZone(o *Operation, z Zone) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	if _, err := db.Exec("UPDATE link SET zone = ? WHERE ID = ? AND opID = ?", z, l, o.ID); err != nil {
 		Log.Error(err)
 		return "", err
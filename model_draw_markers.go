@@ -29,7 +29,7 @@ type Marker struct {
 }
 
 // insertMarkers adds a marker to the database
-func (opID OperationID) insertMarker(m Marker) error {
+func (opID OperationID) insertMarker(ex execer, m Marker) error {
 	if m.State == "" {
 		m.State = "pending"
 	}
@@ -38,7 +38,7 @@ func (opID OperationID) insertMarker(m Marker) error {
 		m.Zone = zonePrimary
 	}
 
-	_, err := db.Exec("INSERT INTO marker (ID, opID, PortalID, type, gid, comment, state, oporder, zone) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	_, err := ex.Exec("INSERT INTO marker (ID, opID, PortalID, type, gid, comment, state, oporder, zone) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		m.ID, opID, m.PortalID, m.Type, MakeNullString(m.AssignedTo), MakeNullString(m.Comment), m.State, m.Order, m.Zone)
 	if err != nil {
 		Log.Error(err)
@@ -162,6 +162,8 @@ func (o *Operation) AssignMarker(markerID MarkerID, gid GoogleID) (string, error
 		Log.Error(err)
 		return "", err
 	}
+	o.ID.clearArrival(claimMarker, string(markerID))
+	o.ID.clearClaim(claimMarker, string(markerID))
 
 	if gid.String() != "" {
 		o.ID.firebaseAssignMarker(gid, markerID)
@@ -184,6 +186,10 @@ func (o *Operation) GetMarker(markerID MarkerID) (Marker, error) {
 
 // MarkerComment updates the comment on a marker
 func (o *Operation) MarkerComment(markerID MarkerID, comment string) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	if _, err := db.Exec("UPDATE marker SET comment = ? WHERE ID = ? AND opID = ?", MakeNullString(comment), markerID, o.ID); err != nil {
 		Log.Error(err)
 		return "", err
@@ -193,6 +199,10 @@ func (o *Operation) MarkerComment(markerID MarkerID, comment string) (string, er
 
 // Zone updates the marker's zone
 func (m MarkerID) Zone(o *Operation, z Zone) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	if _, err := db.Exec("UPDATE marker SET zone = ? WHERE ID = ? AND opID = ?", z, m, o.ID); err != nil {
 		Log.Error(err)
 		return "", err
@@ -204,7 +214,8 @@ func (m MarkerID) Zone(o *Operation, z Zone) (string, error) {
 // gid must be the assigned agent.
 func (m MarkerID) Acknowledge(o *Operation, gid GoogleID) (string, error) {
 	var ns sql.NullString
-	err := db.QueryRow("SELECT gid FROM marker WHERE ID = ? and opID = ?", m, o.ID).Scan(&ns)
+	var state string
+	err := db.QueryRow("SELECT gid, state FROM marker WHERE ID = ? and opID = ?", m, o.ID).Scan(&ns, &state)
 	if err != nil && err != sql.ErrNoRows {
 		Log.Info(err)
 		return "", err
@@ -225,10 +236,17 @@ func (m MarkerID) Acknowledge(o *Operation, gid GoogleID) (string, error) {
 		Log.Warnw(err.Error(), "resource", o.ID, "marker", m)
 		return "", err
 	}
-	if _, err = db.Exec("UPDATE marker SET state = ? WHERE ID = ? AND opID = ?", "acknowledged", m, o.ID); err != nil {
+	// an agent already on site or done does not go back to acknowledged
+	result, err := db.Exec("UPDATE marker SET state = ? WHERE ID = ? AND opID = ? AND state = 'assigned'", "acknowledged", m, o.ID)
+	if err != nil {
 		Log.Error(err)
 		return "", err
 	}
+	if ra, _ := result.RowsAffected(); ra != 1 {
+		err = fmt.Errorf("marker is already %s", state)
+		Log.Infow(err.Error(), "GID", gid, "resource", o.ID, "marker", m)
+		return "", err
+	}
 	o.firebaseMarkerStatus(m, "acknowledged")
 	return o.Touch()
 }
@@ -267,7 +285,8 @@ func (m MarkerID) Incomplete(o Operation, gid GoogleID) (string, error) {
 // gid must be the assigned agent.
 func (m MarkerID) Reject(o *Operation, gid GoogleID) (string, error) {
 	var ns sql.NullString
-	err := db.QueryRow("SELECT gid FROM marker WHERE ID = ? and opID = ?", m, o.ID).Scan(&ns)
+	var state string
+	err := db.QueryRow("SELECT gid, state FROM marker WHERE ID = ? and opID = ?", m, o.ID).Scan(&ns, &state)
 	if err != nil && err != sql.ErrNoRows {
 		Log.Error(err)
 		return "", err
@@ -292,12 +311,17 @@ func (m MarkerID) Reject(o *Operation, gid GoogleID) (string, error) {
 		Log.Error(err)
 		return "", err
 	}
+	o.ID.clearArrival(claimMarker, string(m))
 	o.firebaseMarkerStatus(m, "pending")
 	return o.Touch()
 }
 
 // MarkerOrder changes the order of the throws for an operation
 func (o *Operation) MarkerOrder(order string, gid GoogleID) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	stmt, err := db.Prepare("UPDATE marker SET oporder = ? WHERE opID = ? AND ID = ?")
 	if err != nil {
 		Log.Error(err)
@@ -321,6 +345,10 @@ func (o *Operation) MarkerOrder(order string, gid GoogleID) (string, error) {
 
 // SetZone sets a marker's zone -- caller must authorize
 func (m MarkerID) SetZone(o *Operation, z Zone) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	if _, err := db.Exec("UPDATE marker SET zone = ? WHERE ID = ? AND opID = ?", z, m, o.ID); err != nil {
 		Log.Error(err)
 		return "", err
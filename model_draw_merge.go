@@ -0,0 +1,241 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// MergeReport describes the outcome of merging one op into another
+type MergeReport struct {
+	Source           OperationID           `json:"source"`
+	PortalsAdded     int                   `json:"portalsAdded"`
+	PortalsDuplicate int                   `json:"portalsDuplicate"`
+	LinksAdded       int                   `json:"linksAdded"`
+	LinksDuplicate   int                   `json:"linksDuplicate"`
+	MarkersAdded     int                   `json:"markersAdded"`
+	MarkersDuplicate int                   `json:"markersDuplicate"`
+	LinkIDs          map[LinkID]LinkID     `json:"linkIDs"`
+	MarkerIDs        map[MarkerID]MarkerID `json:"markerIDs"`
+	Zones            map[Zone]Zone         `json:"zones"`
+	UpdateID         string                `json:"updateID"`
+}
+
+// Merge copies the portals, links and markers of the source op (as visible to gid) into this op.
+// Portals are deduplicated by ID, links with the same endpoints and markers of the same type on the same portal are skipped.
+// Colliding link and marker IDs are replaced and reported. Source zones are matched to target zones by name.
+func (o *Operation) Merge(gid GoogleID, source OperationID) (MergeReport, error) {
+	r := MergeReport{
+		Source:    source,
+		LinkIDs:   make(map[LinkID]LinkID),
+		MarkerIDs: make(map[MarkerID]MarkerID),
+		Zones:     make(map[Zone]Zone),
+	}
+
+	if source == o.ID {
+		err := fmt.Errorf("cannot merge an op into itself")
+		Log.Warnw(err.Error(), "GID", gid, "resource", o.ID)
+		return r, err
+	}
+
+	if err := o.ID.checkFrozen(); err != nil {
+		return r, err
+	}
+
+	if !o.WriteAccess(gid) {
+		err := fmt.Errorf("write access denied to op: %s", o.ID)
+		Log.Warnw(err.Error(), "GID", gid, "resource", o.ID)
+		return r, err
+	}
+
+	var src Operation
+	src.ID = source
+	if read, _ := src.ReadAccess(gid); !read {
+		err := fmt.Errorf("read access denied to op: %s", source)
+		Log.Warnw(err.Error(), "GID", gid, "resource", source)
+		return r, err
+	}
+	if err := src.Populate(gid); err != nil {
+		Log.Error(err)
+		return r, err
+	}
+
+	if err := o.populateComplete(); err != nil {
+		Log.Error(err)
+		return r, err
+	}
+
+	// the merge is applied in full or not at all
+	tx, err := db.Begin()
+	if err != nil {
+		Log.Error(err)
+		return r, err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && err != sql.ErrTxDone {
+			Log.Error(err)
+		}
+	}()
+
+	if err := o.mergeZones(tx, &src, &r); err != nil {
+		return r, err
+	}
+
+	portals := make(map[PortalID]bool)
+	for _, p := range o.OpPortals {
+		portals[p.ID] = true
+	}
+	for _, p := range src.OpPortals {
+		if portals[p.ID] {
+			r.PortalsDuplicate++
+			continue
+		}
+		if err := o.ID.insertPortal(tx, p); err != nil {
+			return r, err
+		}
+		portals[p.ID] = true
+		r.PortalsAdded++
+	}
+
+	// links are the same if they connect the same portals, in either direction
+	linkIDs := make(map[LinkID]bool)
+	linkEnds := make(map[string]bool)
+	var throworder int32
+	for _, l := range o.Links {
+		linkIDs[l.ID] = true
+		linkEnds[linkKey(l.From, l.To)] = true
+		if l.ThrowOrder > throworder {
+			throworder = l.ThrowOrder
+		}
+	}
+	for _, l := range src.Links {
+		if linkEnds[linkKey(l.From, l.To)] {
+			r.LinksDuplicate++
+			continue
+		}
+		if linkIDs[l.ID] {
+			newID := LinkID(GenerateID(32))
+			r.LinkIDs[l.ID] = newID
+			l.ID = newID
+		}
+		l.Zone = r.zone(l.Zone)
+		throworder++
+		l.ThrowOrder = throworder
+		if err := o.ID.insertLink(tx, l); err != nil {
+			return r, err
+		}
+		linkIDs[l.ID] = true
+		linkEnds[linkKey(l.From, l.To)] = true
+		r.LinksAdded++
+	}
+
+	markerIDs := make(map[MarkerID]bool)
+	markerTasks := make(map[string]bool)
+	var order int
+	for _, m := range o.Markers {
+		markerIDs[m.ID] = true
+		markerTasks[string(m.PortalID)+string(m.Type)] = true
+		if m.Order > order {
+			order = m.Order
+		}
+	}
+	for _, m := range src.Markers {
+		if markerTasks[string(m.PortalID)+string(m.Type)] {
+			r.MarkersDuplicate++
+			continue
+		}
+		if markerIDs[m.ID] {
+			newID := MarkerID(GenerateID(32))
+			r.MarkerIDs[m.ID] = newID
+			m.ID = newID
+		}
+		m.Zone = r.zone(m.Zone)
+		if m.Order > 0 {
+			m.Order += order
+		}
+		if err := o.ID.insertMarker(tx, m); err != nil {
+			return r, err
+		}
+		markerIDs[m.ID] = true
+		markerTasks[string(m.PortalID)+string(m.Type)] = true
+		r.MarkersAdded++
+	}
+
+	if err := tx.Commit(); err != nil {
+		Log.Error(err)
+		return r, err
+	}
+
+	uid, err := o.Touch()
+	if err != nil {
+		Log.Error(err)
+		return r, err
+	}
+	r.UpdateID = uid
+	Log.Infow("op merged", "GID", gid, "resource", o.ID, "source", source, "links", r.LinksAdded, "markers", r.MarkersAdded)
+	return r, nil
+}
+
+// zone returns the target zone for a source zone, the primary zone if the source zone was not mapped
+func (r *MergeReport) zone(z Zone) Zone {
+	if t, ok := r.Zones[z]; ok {
+		return t
+	}
+	return zonePrimary
+}
+
+// mergeZones maps each source zone to a target zone with the same name, adding zones to the target as needed
+func (o *Operation) mergeZones(tx *sql.Tx, src *Operation, r *MergeReport) error {
+	byName := make(map[string]Zone)
+	used := make(map[Zone]bool)
+	for _, z := range o.Zones {
+		byName[z.Name] = z.Zone
+		used[z.Zone] = true
+	}
+
+	persisted := false
+	for _, z := range src.Zones {
+		if t, ok := byName[z.Name]; ok {
+			r.Zones[z.Zone] = t
+			continue
+		}
+
+		var t Zone
+		for i := zonePrimary; i <= zoneMax; i++ {
+			if !used[i] {
+				t = i
+				break
+			}
+		}
+		if t == ZoneAll {
+			Log.Infow("no free zones in target op, using primary", "resource", o.ID, "zone", z.Name)
+			r.Zones[z.Zone] = zonePrimary
+			continue
+		}
+
+		// ops without stored zones use the defaults, store them before adding to them
+		if !persisted {
+			for _, tz := range o.Zones {
+				if err := o.insertZone(tx, tz); err != nil {
+					return err
+				}
+			}
+			persisted = true
+		}
+		if err := o.insertZone(tx, ZoneListElement{Zone: t, Name: z.Name}); err != nil {
+			return err
+		}
+		byName[z.Name] = t
+		used[t] = true
+		r.Zones[z.Zone] = t
+	}
+	return nil
+}
+
+// linkKey identifies a link by its endpoints regardless of direction
+func linkKey(a, b PortalID) string {
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%s:%s", a, b)
+}
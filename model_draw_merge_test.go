@@ -0,0 +1,74 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestOpMerge(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test3.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(j, &in); err != nil {
+		t.Error(err.Error())
+	}
+
+	newID, err := in.ID.Clone(gid, wasabee.CloneOptions{Name: "merge target"})
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	var src, target wasabee.Operation
+	src.ID = in.ID
+	target.ID = newID
+	if err := src.Populate(gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	if _, err := src.Merge(gid, src.ID); err == nil {
+		t.Error("merged an op into itself")
+	}
+
+	// the clone already has everything, so nothing is added
+	r, err := target.Merge(gid, src.ID)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if r.PortalsAdded != 0 || r.LinksAdded != 0 || r.MarkersAdded != 0 {
+		t.Error("merge added duplicates")
+	}
+	if r.PortalsDuplicate != len(src.OpPortals) || r.LinksDuplicate != len(src.Links) {
+		t.Error("merge did not report duplicates")
+	}
+	for _, z := range r.Zones {
+		if !z.Valid() || z == wasabee.ZoneAll {
+			t.Errorf("source zone mapped to invalid zone %d", z)
+		}
+	}
+
+	var merged wasabee.Operation
+	merged.ID = newID
+	if err := merged.Populate(gid); err != nil {
+		t.Error(err.Error())
+	}
+	if len(merged.Links) != len(src.Links) || len(merged.OpPortals) != len(src.OpPortals) {
+		t.Error("merge changed the target op")
+	}
+
+	if err := merged.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+	if err := src.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
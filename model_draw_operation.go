@@ -28,14 +28,23 @@ type Operation struct {
 	Keys      []KeyOnHand       `json:"keysonhand"`
 	Fetched   string            `json:"fetched"`
 	Zones     []ZoneListElement `json:"zones"`
+	Template  bool              `json:"template"`
+	Frozen    bool              `json:"frozen"`
+	CoOwners  []GoogleID        `json:"coowners"`
+	Pending   *PendingTransfer  `json:"pendingOwner,omitempty"`
+	Statuses  []AgentStatus     `json:"agentStatus,omitempty"`
 }
 
 // OpStat is a minimal struct to determine if the op has been updated
 type OpStat struct {
-	ID       OperationID `json:"ID"`
-	Name     string      `json:"name"`
-	Gid      GoogleID    `json:"creator"`
-	Modified string      `json:"modified"`
+	ID       OperationID      `json:"ID"`
+	Name     string           `json:"name"`
+	Gid      GoogleID         `json:"creator"`
+	Modified string           `json:"modified"`
+	Template bool             `json:"template"`
+	Frozen   bool             `json:"frozen"`
+	CoOwners []GoogleID       `json:"coowners"`
+	Pending  *PendingTransfer `json:"pendingOwner,omitempty"`
 }
 
 // DrawInsert parses a raw op sent from the IITC plugin and stores it in the database
@@ -79,7 +88,7 @@ func drawOpInsertWorker(o Operation, gid GoogleID) error {
 	portalMap := make(map[PortalID]Portal)
 	for _, p := range o.OpPortals {
 		portalMap[p.ID] = p
-		if err = o.ID.insertPortal(p); err != nil {
+		if err = o.ID.insertPortal(db, p); err != nil {
 			Log.Error(err)
 			continue
 		}
@@ -91,7 +100,7 @@ func drawOpInsertWorker(o Operation, gid GoogleID) error {
 			Log.Warnw("portalID missing from portal list", "portal", m.PortalID, "resource", o.ID)
 			continue
 		}
-		if err = o.ID.insertMarker(m); err != nil {
+		if err = o.ID.insertMarker(db, m); err != nil {
 			Log.Error(err)
 			continue
 		}
@@ -108,25 +117,20 @@ func drawOpInsertWorker(o Operation, gid GoogleID) error {
 			Log.Warnw("destination portal missing from portal list", "portal", l.To, "resource", o.ID)
 			continue
 		}
-		if err = o.ID.insertLink(l); err != nil {
+		if err = o.ID.insertLink(db, l); err != nil {
 			Log.Error(err)
 			continue
 		}
 	}
 
-	for _, k := range o.Keys {
-		if _, err = o.insertKey(k); err != nil {
-			Log.Error(err)
-			continue
-		}
-	}
+	o.uploadKeys(gid, portalMap)
 
 	// pre 0.18 clients do not send zone data
 	if len(o.Zones) == 0 {
 		o.Zones = defaultZones()
 	}
 	for _, z := range o.Zones {
-		if err = o.insertZone(z); err != nil {
+		if err = o.insertZone(db, z); err != nil {
 			Log.Error(err)
 			continue
 		}
@@ -140,6 +144,10 @@ func drawOpInsertWorker(o Operation, gid GoogleID) error {
 // Markers are added/removed as necessary -- assignments _are_ overwritten
 // Key count data is left untouched (unless the portal is no longer listed in the portals list).
 func DrawUpdate(opID OperationID, op json.RawMessage, gid GoogleID) (string, error) {
+	if err := opID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	var o Operation
 	if err := json.Unmarshal(op, &o); err != nil {
 		Log.Error(err)
@@ -162,14 +170,14 @@ func DrawUpdate(opID OperationID, op json.RawMessage, gid GoogleID) (string, err
 		return "", err
 	}
 
-	if err := drawOpUpdateWorker(o); err != nil {
+	if err := drawOpUpdateWorker(o, gid); err != nil {
 		Log.Error(err)
 		return "", err
 	}
 	return o.Touch()
 }
 
-func drawOpUpdateWorker(o Operation) error {
+func drawOpUpdateWorker(o Operation, gid GoogleID) error {
 	_, err := db.Exec("UPDATE operation SET name = ?, color = ?, comment = ? WHERE ID = ?",
 		o.Name, o.Color, MakeNullString(o.Comment), o.ID)
 	if err != nil {
@@ -298,20 +306,20 @@ func drawOpUpdateWorker(o Operation) error {
 	}
 	// update and insert are the saem
 	for _, z := range o.Zones {
-		if err = o.insertZone(z); err != nil {
+		if err = o.insertZone(db, z); err != nil {
 			Log.Error(err)
 			continue
 		}
 	}
 
-	// XXX TBD remove unused opkey portals?
+	o.uploadKeys(gid, portalMap)
 
 	return nil
 }
 
 // Delete removes an operation and all associated data
 func (o *Operation) Delete(gid GoogleID) error {
-	if !o.ID.IsOwner(gid) {
+	if !o.ID.IsPrimaryOwner(gid) {
 		err := fmt.Errorf("permission denied")
 		Log.Error(err)
 		return err
@@ -337,7 +345,7 @@ func (o *Operation) Delete(gid GoogleID) error {
 	_, _ = db.Exec("DELETE FROM portal WHERE opID = ?", o.ID)
 	// XXX not needed going forward, but leaving for now
 	_, _ = db.Exec("DELETE FROM anchor WHERE opID = ?", o.ID)
-	_, _ = db.Exec("DELETE FROM opkeys WHERE opID = ?", o.ID)
+	_, _ = db.Exec("DELETE FROM opkeyshare WHERE opID = ?", o.ID)
 	_, _ = db.Exec("DELETE FROM opteams WHERE opID = ?", o.ID)
 
 	return nil
@@ -363,8 +371,8 @@ func (opID OperationID) IsDeletedOp() bool {
 func (o *Operation) Populate(gid GoogleID) error {
 	var comment sql.NullString
 	// permission check and populate Operation top level
-	r := db.QueryRow("SELECT name, gid, color, modified, comment FROM operation WHERE ID = ?", o.ID)
-	err := r.Scan(&o.Name, &o.Gid, &o.Color, &o.Modified, &comment)
+	r := db.QueryRow("SELECT name, gid, color, modified, comment, template, frozen FROM operation WHERE ID = ?", o.ID)
+	err := r.Scan(&o.Name, &o.Gid, &o.Color, &o.Modified, &comment, &o.Template, &o.Frozen)
 
 	if err != nil && err == sql.ErrNoRows {
 		err = fmt.Errorf("operation not found")
@@ -384,6 +392,15 @@ func (o *Operation) Populate(gid GoogleID) error {
 		return err
 	}
 
+	if o.CoOwners, err = o.ID.CoOwners(); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if o.Pending, err = o.ID.PendingChown(); err != nil {
+		Log.Error(err)
+		return err
+	}
+
 	read, zones := o.ReadAccess(gid)
 	if !read {
 		if o.AssignedOnlyAccess(gid) {
@@ -449,6 +466,11 @@ func (o *Operation) Populate(gid GoogleID) error {
 		return err
 	}
 
+	if o.Statuses, err = o.ID.Statuses(); err != nil {
+		Log.Error(err)
+		return err
+	}
+
 	return nil
 }
 
@@ -459,6 +481,10 @@ type objectID interface {
 
 // SetInfo changes the description of an operation
 func (o *Operation) SetInfo(info string, gid GoogleID) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	// check isowner (already done in http/pdraw.go, but there may be other callers in the future
 	_, err := db.Exec("UPDATE operation SET comment = ? WHERE ID = ?", info, o.ID)
 	if err != nil {
@@ -485,7 +511,7 @@ func (o *Operation) Touch() (string, error) {
 func (opID OperationID) Stat() (OpStat, error) {
 	var s OpStat
 	s.ID = opID
-	err := db.QueryRow("SELECT name, gid, modified FROM operation WHERE ID = ?", opID).Scan(&s.Name, &s.Gid, &s.Modified)
+	err := db.QueryRow("SELECT name, gid, modified, template, frozen FROM operation WHERE ID = ?", opID).Scan(&s.Name, &s.Gid, &s.Modified, &s.Template, &s.Frozen)
 	if err != nil && err != sql.ErrNoRows {
 		Log.Error(err)
 		return s, err
@@ -495,6 +521,14 @@ func (opID OperationID) Stat() (OpStat, error) {
 		Log.Warnw(err.Error(), "resource", opID)
 		return s, err
 	}
+	if s.CoOwners, err = opID.CoOwners(); err != nil {
+		Log.Error(err)
+		return s, err
+	}
+	if s.Pending, err = opID.PendingChown(); err != nil {
+		Log.Error(err)
+		return s, err
+	}
 	return s, nil
 }
 
@@ -519,3 +553,41 @@ func (opID OperationID) Rename(gid GoogleID, name string) error {
 	}
 	return nil
 }
+
+// SetFrozen locks or unlocks an op; while frozen only state changes are permitted
+func (opID OperationID) SetFrozen(gid GoogleID, frozen bool) error {
+	if !opID.IsOwner(gid) {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+		return err
+	}
+
+	if _, err := db.Exec("UPDATE operation SET frozen = ? WHERE ID = ?", frozen, opID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	Log.Infow("op freeze state changed", "GID", gid, "resource", opID, "frozen", frozen)
+	return nil
+}
+
+// IsFrozen reports if the op owner has frozen the op
+func (opID OperationID) IsFrozen() bool {
+	var frozen bool
+	if err := db.QueryRow("SELECT frozen FROM operation WHERE ID = ?", opID).Scan(&frozen); err != nil {
+		if err != sql.ErrNoRows {
+			Log.Error(err)
+		}
+		return false
+	}
+	return frozen
+}
+
+// checkFrozen returns an error if structural changes to the op are not permitted
+func (opID OperationID) checkFrozen() error {
+	if opID.IsFrozen() {
+		err := fmt.Errorf("operation is frozen")
+		Log.Warnw(err.Error(), "resource", opID)
+		return err
+	}
+	return nil
+}
@@ -10,23 +10,29 @@ type PortalID string
 
 // Portal is defined by the Wasabee IITC plugin.
 type Portal struct {
-	ID       PortalID `json:"id"`
-	Name     string   `json:"name"`
-	Lat      string   `json:"lat"` // passing these as strings saves me parsing them
-	Lon      string   `json:"lng"`
-	Comment  string   `json:"comment"`
-	Hardness string   `json:"hardness"` // string for now, enum in the future
+	ID       PortalID       `json:"id"`
+	Name     string         `json:"name"`
+	Lat      string         `json:"lat"` // passing these as strings saves me parsing them
+	Lon      string         `json:"lng"`
+	Comment  string         `json:"comment"`
+	Hardness string         `json:"hardness"` // free-form, see Defense for the structured data
+	Defense  *PortalDefense `json:"defense,omitempty"`
 }
 
 // insertPortal adds a portal to the database
-func (opID OperationID) insertPortal(p Portal) error {
-	_, err := db.Exec("INSERT IGNORE INTO portal (ID, opID, name, loc, comment, hardness) VALUES (?, ?, ?, POINT(?, ?), ?, ?)",
+func (opID OperationID) insertPortal(ex execer, p Portal) error {
+	_, err := ex.Exec("INSERT IGNORE INTO portal (ID, opID, name, loc, comment, hardness) VALUES (?, ?, ?, POINT(?, ?), ?, ?)",
 		p.ID, opID, p.Name, p.Lon, p.Lat, MakeNullString(p.Comment), MakeNullString(p.Hardness))
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	return nil
+	if p.Defense != nil {
+		if err := opID.updateDefense(ex, p.ID, p.Defense); err != nil {
+			return err
+		}
+	}
+	return registerPortal(p.ID, p.Name, p.Lat, p.Lon)
 }
 
 func (opID OperationID) updatePortal(p Portal) error {
@@ -36,7 +42,12 @@ func (opID OperationID) updatePortal(p Portal) error {
 		Log.Error(err)
 		return err
 	}
-	return nil
+	if p.Defense != nil {
+		if err := opID.updateDefense(db, p.ID, p.Defense); err != nil {
+			return err
+		}
+	}
+	return registerPortal(p.ID, p.Name, p.Lat, p.Lon)
 }
 
 func (opID OperationID) deletePortal(p PortalID) error {
@@ -45,7 +56,7 @@ func (opID OperationID) deletePortal(p PortalID) error {
 		Log.Error(err)
 		return err
 	}
-	return nil
+	return opID.deleteDefense(p)
 }
 
 // PopulatePortals fills in the OpPortals list for the Operation. No authorization takes place.
@@ -79,7 +90,7 @@ func (o *Operation) populatePortals() error {
 
 		o.OpPortals = append(o.OpPortals, tmpPortal)
 	}
-	return nil
+	return o.populateDefense()
 }
 
 // reduce the portal list to keys, links and makers in this zone
@@ -133,6 +144,10 @@ func (p PortalID) String() string {
 
 // PortalHardness updates the comment on a portal
 func (o *Operation) PortalHardness(portalID PortalID, hardness string) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	result, err := db.Exec("UPDATE portal SET hardness = ? WHERE ID = ? AND opID = ?", MakeNullString(hardness), portalID, o.ID)
 	if err != nil {
 		Log.Error(err)
@@ -151,6 +166,10 @@ func (o *Operation) PortalHardness(portalID PortalID, hardness string) (string,
 
 // PortalComment updates the comment on a portal
 func (o *Operation) PortalComment(portalID PortalID, comment string) (string, error) {
+	if err := o.ID.checkFrozen(); err != nil {
+		return "", err
+	}
+
 	result, err := db.Exec("UPDATE portal SET comment = ? WHERE ID = ? AND opID = ?", MakeNullString(comment), portalID, o.ID)
 	if err != nil {
 		Log.Error(err)
@@ -194,7 +213,12 @@ func (o *Operation) PortalDetails(portalID PortalID, gid GoogleID) (Portal, erro
 	if hardness.Valid {
 		p.Hardness = hardness.String
 	}
-	return p, nil
+
+	tmp := Operation{ID: o.ID, OpPortals: []Portal{p}}
+	if err := tmp.populateDefense(); err != nil {
+		return p, err
+	}
+	return tmp.OpPortals[0], nil
 }
 
 // lookup and return a populated Portal from an ID
@@ -0,0 +1,171 @@
+package wasabee
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OpSearch is the set of filters for an operation search; zero values are ignored
+type OpSearch struct {
+	Name       string
+	Owner      GoogleID
+	TeamID     TeamID
+	After      time.Time
+	Before     time.Time
+	Assigned   bool
+	Template   bool
+	BBox       *BoundingBox
+	Sort       string
+	Descending bool
+	Limit      int
+	Offset     int
+}
+
+// BoundingBox is a simple lat/lon rectangle, it does not handle the antimeridian
+type BoundingBox struct {
+	South float64
+	West  float64
+	North float64
+	East  float64
+}
+
+// OpSearchResult is an OpStat with progress counts
+type OpSearchResult struct {
+	OpStat
+	Markers          int `json:"markers"`
+	MarkersCompleted int `json:"markersCompleted"`
+	Links            int `json:"links"`
+	LinksCompleted   int `json:"linksCompleted"`
+}
+
+// OpSearchResults is a page of search results
+type OpSearchResults struct {
+	Total  int              `json:"total"`
+	Offset int              `json:"offset"`
+	Limit  int              `json:"limit"`
+	Ops    []OpSearchResult `json:"ops"`
+}
+
+const (
+	opSearchDefaultLimit = 50
+	opSearchMaxLimit     = 200
+)
+
+// the only columns a search may be sorted by
+var opSearchSort = map[string]string{
+	"name":     "o.name",
+	"modified": "o.modified",
+	"owner":    "o.gid",
+}
+
+// SearchOperations lists the operations an agent can read, filtered by the search parameters
+func (gid GoogleID) SearchOperations(s OpSearch) (OpSearchResults, error) {
+	var r OpSearchResults
+
+	// owned, co-owned, or shared with a team the agent is on
+	where := []string{"(o.gid = ? OR o.ID IN (SELECT opID FROM opcoowners WHERE gid = ?) OR o.ID IN (SELECT p.opID FROM opteams=p, agentteams=x WHERE x.gid = ? AND x.suspended = 0 AND (x.teamID = p.teamID OR x.teamID IN (SELECT descendant FROM teamtree WHERE ancestor = p.teamID))))"}
+	args := []interface{}{gid, gid, gid}
+
+	if s.Name != "" {
+		where = append(where, "o.name LIKE ?")
+		args = append(args, "%"+likeEscape(s.Name)+"%")
+	}
+	if s.Owner != "" {
+		where = append(where, "o.gid = ?")
+		args = append(args, s.Owner)
+	}
+	if s.TeamID != "" {
+		where = append(where, "o.ID IN (SELECT opID FROM opteams WHERE teamID = ?)")
+		args = append(args, s.TeamID)
+	}
+	if !s.After.IsZero() {
+		where = append(where, "o.modified >= ?")
+		args = append(args, s.After.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if !s.Before.IsZero() {
+		where = append(where, "o.modified <= ?")
+		args = append(args, s.Before.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if s.Assigned {
+		where = append(where, "(o.ID IN (SELECT opID FROM marker WHERE gid = ?) OR o.ID IN (SELECT opID FROM link WHERE gid = ?))")
+		args = append(args, gid, gid)
+	}
+	if s.Template {
+		where = append(where, "o.template = 1")
+	}
+	if s.BBox != nil {
+		if s.BBox.South > s.BBox.North || s.BBox.West > s.BBox.East {
+			err := fmt.Errorf("invalid bounding box")
+			Log.Warnw(err.Error(), "GID", gid, "bbox", s.BBox)
+			return r, err
+		}
+		where = append(where, "o.ID IN (SELECT opID FROM portal WHERE Y(loc) BETWEEN ? AND ? AND X(loc) BETWEEN ? AND ?)")
+		args = append(args, s.BBox.South, s.BBox.North, s.BBox.West, s.BBox.East)
+	}
+
+	sort, ok := opSearchSort[s.Sort]
+	if !ok {
+		sort = "o.modified"
+		if s.Sort == "" {
+			s.Descending = true
+		}
+	}
+	order := "ASC"
+	if s.Descending {
+		order = "DESC"
+	}
+
+	if s.Limit <= 0 {
+		s.Limit = opSearchDefaultLimit
+	}
+	if s.Limit > opSearchMaxLimit {
+		s.Limit = opSearchMaxLimit
+	}
+	if s.Offset < 0 {
+		s.Offset = 0
+	}
+	r.Limit = s.Limit
+	r.Offset = s.Offset
+	r.Ops = make([]OpSearchResult, 0)
+
+	filter := strings.Join(where, " AND ")
+
+	err := db.QueryRow("SELECT COUNT(*) FROM operation=o WHERE "+filter, args...).Scan(&r.Total)
+	if err != nil {
+		Log.Error(err)
+		return r, err
+	}
+
+	// sort and order come only from the whitelist above
+	q := fmt.Sprintf("SELECT o.ID, o.name, o.gid, o.modified, o.template, o.frozen, "+
+		"(SELECT COUNT(*) FROM marker WHERE opID = o.ID), "+
+		"(SELECT COUNT(*) FROM marker WHERE opID = o.ID AND state = 'completed'), "+
+		"(SELECT COUNT(*) FROM link WHERE opID = o.ID), "+
+		"(SELECT COUNT(*) FROM link WHERE opID = o.ID AND completed = 1) "+
+		"FROM operation=o WHERE %s ORDER BY %s %s, o.ID LIMIT ? OFFSET ?", filter, sort, order)
+	args = append(args, s.Limit, s.Offset)
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		Log.Error(err)
+		return r, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o OpSearchResult
+		if err := rows.Scan(&o.ID, &o.Name, &o.Gid, &o.Modified, &o.Template, &o.Frozen, &o.Markers, &o.MarkersCompleted, &o.Links, &o.LinksCompleted); err != nil {
+			Log.Error(err)
+			continue
+		}
+		r.Ops = append(r.Ops, o)
+	}
+	return r, nil
+}
+
+// likeEscape escapes the LIKE wildcards in user input
+func likeEscape(in string) string {
+	r := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return r.Replace(in)
+}
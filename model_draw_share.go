@@ -0,0 +1,222 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ShareToken is a public, read-only access key for a single operation
+type ShareToken string
+
+// OpShare describes a share token as displayed to the op owner
+type OpShare struct {
+	Token      ShareToken `json:"token"`
+	Zone       Zone       `json:"zone"`
+	Created    string     `json:"created"`
+	Expires    string     `json:"expires,omitempty"`
+	Accesses   int        `json:"accesses"`
+	LastAccess string     `json:"lastAccess,omitempty"`
+}
+
+// String returns the string version of a ShareToken
+func (st ShareToken) String() string {
+	return string(st)
+}
+
+// NewShare creates a read-only share token for an op; a zero duration never expires
+func (opID OperationID) NewShare(gid GoogleID, zone Zone, duration time.Duration) (OpShare, error) {
+	var s OpShare
+
+	if !opID.IsOwner(gid) {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+		return s, err
+	}
+
+	if !zone.Valid() {
+		err := fmt.Errorf("invalid zone")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID, "zone", zone)
+		return s, err
+	}
+
+	if duration < 0 {
+		err := fmt.Errorf("invalid expiration")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+		return s, err
+	}
+
+	token, err := GenerateSafeName()
+	if err != nil {
+		Log.Error(err)
+		return s, err
+	}
+
+	var expires sql.NullString
+	now := time.Now().UTC()
+	if duration > 0 {
+		expires.Valid = true
+		expires.String = now.Add(duration).Format("2006-01-02 15:04:05")
+	}
+
+	_, err = db.Exec("INSERT INTO opshare (token, opID, gid, zone, created, expires) VALUES (?, ?, ?, ?, ?, ?)",
+		token, opID, gid, zone, now.Format("2006-01-02 15:04:05"), expires)
+	if err != nil {
+		Log.Error(err)
+		return s, err
+	}
+
+	s.Token = ShareToken(token)
+	s.Zone = zone
+	s.Created = now.Format("2006-01-02 15:04:05")
+	s.Expires = expires.String
+	Log.Infow("op share created", "GID", gid, "resource", opID, "zone", zone, "expires", s.Expires)
+	return s, nil
+}
+
+// Shares lists the share tokens for an op, including access counts
+func (opID OperationID) Shares(gid GoogleID) ([]OpShare, error) {
+	var shares []OpShare
+
+	if !opID.IsOwner(gid) {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+		return shares, err
+	}
+
+	rows, err := db.Query("SELECT s.token, s.zone, s.created, s.expires, COUNT(l.token), MAX(l.accessed) FROM opshare=s LEFT JOIN opsharelog=l ON s.token = l.token WHERE s.opID = ? GROUP BY s.token, s.zone, s.created, s.expires ORDER BY s.created", opID)
+	if err != nil {
+		Log.Error(err)
+		return shares, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s OpShare
+		var expires, last sql.NullString
+		if err := rows.Scan(&s.Token, &s.Zone, &s.Created, &expires, &s.Accesses, &last); err != nil {
+			Log.Error(err)
+			continue
+		}
+		s.Expires = expires.String
+		s.LastAccess = last.String
+		shares = append(shares, s)
+	}
+	return shares, nil
+}
+
+// RevokeShare removes a share token from an op
+func (opID OperationID) RevokeShare(gid GoogleID, token ShareToken) error {
+	if !opID.IsOwner(gid) {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+		return err
+	}
+
+	_, err := db.Exec("DELETE FROM opshare WHERE opID = ? AND token = ?", opID, token)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	Log.Infow("op share revoked", "GID", gid, "resource", opID, "token", token)
+	return nil
+}
+
+// shareZone verifies a token is valid for an op and returns the zone to which it is limited
+func (opID OperationID) shareZone(token ShareToken) (Zone, error) {
+	var zone Zone
+
+	err := db.QueryRow("SELECT zone FROM opshare WHERE opID = ? AND token = ? AND (expires IS NULL OR expires > UTC_TIMESTAMP())", opID, token).Scan(&zone)
+	if err != nil && err == sql.ErrNoRows {
+		err = fmt.Errorf("invalid or expired share token")
+		Log.Warnw(err.Error(), "resource", opID, "token", token)
+		return zone, err
+	}
+	if err != nil {
+		Log.Error(err)
+		return zone, err
+	}
+	return zone, nil
+}
+
+// PopulateShared fills in an operation for public display via a share token.
+// Agent GoogleIDs, team lists and key counts are not included.
+func (o *Operation) PopulateShared(token ShareToken, remote string) error {
+	zone, err := o.ID.shareZone(token)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("INSERT INTO opsharelog (token, accessed, remote) VALUES (?, UTC_TIMESTAMP(), ?)", token, MakeNullString(remote)); err != nil {
+		Log.Error(err)
+	}
+	Log.Infow("shared op accessed", "resource", o.ID, "token", token, "remote", remote)
+
+	var comment sql.NullString
+	err = db.QueryRow("SELECT name, color, modified, comment FROM operation WHERE ID = ?", o.ID).Scan(&o.Name, &o.Color, &o.Modified, &comment)
+	if err != nil && err == sql.ErrNoRows {
+		err = fmt.Errorf("operation not found")
+		Log.Warnw(err.Error(), "resource", o.ID, "token", token)
+		return err
+	}
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if comment.Valid {
+		o.Comment = comment.String
+	}
+	o.Fetched = fmt.Sprint(time.Now().UTC().Format(time.RFC1123))
+
+	zones := []Zone{zone}
+	// no agent will ever match this, so nothing outside the zone leaks through as "assigned to me"
+	nobody := GoogleID("0")
+
+	if err = o.populatePortals(); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if err = o.populateMarkers(zones, nobody); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if err = o.populateLinks(zones, nobody); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if err = o.populateAnchors(); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if zone != ZoneAll {
+		if err = o.filterPortals(); err != nil {
+			Log.Error(err)
+			return err
+		}
+	}
+	if err = o.populateZones(); err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	o.redact()
+	return nil
+}
+
+// redact strips agent identifying information from a populated op
+func (o *Operation) redact() {
+	o.Gid = ""
+	o.Teams = nil
+	o.Keys = nil
+	for i := range o.Markers {
+		o.Markers[i].AssignedTo = ""
+		o.Markers[i].AssignedTeam = ""
+		o.Markers[i].CompletedID = ""
+		o.Markers[i].IngressName = ""
+		o.Markers[i].CompletedBy = ""
+	}
+	for i := range o.Links {
+		o.Links[i].AssignedTo = ""
+		o.Links[i].Iname = ""
+	}
+}
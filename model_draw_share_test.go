@@ -0,0 +1,72 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestOpShare(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(j, &in); err != nil {
+		t.Error(err.Error())
+	}
+
+	share, err := in.ID.NewShare(gid, wasabee.ZoneAll, time.Hour)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if _, err := in.ID.NewShare(wasabee.GoogleID("0"), wasabee.ZoneAll, 0); err == nil {
+		t.Error("non-owner able to share op")
+	}
+
+	var o wasabee.Operation
+	o.ID = in.ID
+	if err := o.PopulateShared(share.Token, "127.0.0.1"); err != nil {
+		t.Error(err.Error())
+	}
+	if o.Gid != "" || len(o.Keys) != 0 || len(o.Teams) != 0 {
+		t.Error("shared op not redacted")
+	}
+	for _, l := range o.Links {
+		if l.AssignedTo != "" {
+			t.Error("shared op link assignment not redacted")
+		}
+	}
+	if len(o.Links) != len(in.Links) {
+		t.Error("wrong link count in shared op")
+	}
+
+	shares, err := in.ID.Shares(gid)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(shares) != 1 || shares[0].Accesses != 1 {
+		t.Error("share access not logged")
+	}
+
+	if err := in.ID.RevokeShare(gid, share.Token); err != nil {
+		t.Error(err.Error())
+	}
+	var r wasabee.Operation
+	r.ID = in.ID
+	if err := r.PopulateShared(share.Token, "127.0.0.1"); err == nil {
+		t.Error("revoked share token still valid")
+	}
+
+	if err := in.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
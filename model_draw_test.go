@@ -197,3 +197,121 @@ func TestDamagedOperation(t *testing.T) {
 	}
 	wasabee.Log.Info("TestDamageOperation completed")
 }
+
+func TestOpFreeze(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(j, &in); err != nil {
+		t.Error(err.Error())
+	}
+	opp := &in
+
+	if err := opp.ID.SetFrozen(gid, true); err != nil {
+		t.Error(err.Error())
+	}
+	if _, err := wasabee.DrawUpdate(opp.ID, j, gid); err == nil {
+		t.Error("update permitted on frozen op")
+	}
+	if _, err := opp.SetInfo("frozen", gid); err == nil {
+		t.Error("info change permitted on frozen op")
+	}
+	if len(opp.Links) > 0 {
+		if _, err := opp.LinkCompleted(opp.Links[0].ID, true); err != nil {
+			t.Error(err.Error())
+		}
+	}
+
+	s, err := opp.ID.Stat()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if !s.Frozen {
+		t.Error("frozen state not reported in stat")
+	}
+
+	if err := opp.ID.SetFrozen(gid, false); err != nil {
+		t.Error(err.Error())
+	}
+	if _, err := wasabee.DrawUpdate(opp.ID, j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	if err := opp.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestOpCoOwners(t *testing.T) {
+	coowner := wasabee.GoogleID("104743827901423568948")
+	if err := (wasabee.AgentData{GoogleID: coowner, IngressName: "coowner", Level: 8}).Save(); err != nil {
+		t.Error(err.Error())
+	}
+
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(j, &in); err != nil {
+		t.Error(err.Error())
+	}
+	opp := &in
+
+	if err := opp.ID.AddCoOwner(coowner, string(gid)); err == nil {
+		t.Error("non-owner added a co-owner")
+	}
+	if err := opp.ID.AddCoOwner(gid, string(gid)); err == nil {
+		t.Error("owner added as co-owner")
+	}
+	if err := opp.ID.AddCoOwner(gid, string(coowner)); err != nil {
+		t.Error(err.Error())
+	}
+	coowners, err := opp.ID.CoOwners()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(coowners) != 1 || coowners[0] != coowner {
+		t.Error("co-owner not listed")
+	}
+	if !opp.ID.IsOwner(coowner) || opp.ID.IsPrimaryOwner(coowner) {
+		t.Error("co-owner has the wrong ownership")
+	}
+	if !opp.WriteAccess(coowner) {
+		t.Error("co-owner denied write access")
+	}
+
+	// co-owners cannot delete or give away the op
+	if err := opp.Delete(coowner); err == nil {
+		t.Error("co-owner deleted the op")
+	}
+	if err := opp.ID.Chown(coowner, string(coowner)); err == nil {
+		t.Error("co-owner changed the op's owner")
+	}
+
+	if err := opp.ID.RemoveCoOwner(gid, coowner); err != nil {
+		t.Error(err.Error())
+	}
+	if opp.ID.IsOwner(coowner) {
+		t.Error("co-owner not removed")
+	}
+
+	if err := opp.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+	if err := coowner.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
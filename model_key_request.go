@@ -0,0 +1,406 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// KeyRequest is an agent asking teammates for keys to a portal in an op
+type KeyRequest struct {
+	ID        string      `json:"ID"`
+	OpID      OperationID `json:"opID"`
+	PortalID  PortalID    `json:"portalID"`
+	Name      string      `json:"name"`
+	Requester GoogleID    `json:"requester"`
+	Count     int32       `json:"count"`
+	Pledged   int32       `json:"pledged"`
+	Delivered int32       `json:"delivered"`
+	State     string      `json:"state"` // open, fulfilled or cancelled
+	Created   string      `json:"created"`
+	Pledges   []KeyPledge `json:"pledges"`
+}
+
+// KeyPledge is a teammate's promise of keys for a KeyRequest
+type KeyPledge struct {
+	GID     GoogleID `json:"gid"`
+	Count   int32    `json:"count"`
+	State   string   `json:"state"` // pledged, delivered or withdrawn
+	Updated string   `json:"updated"`
+}
+
+// RequestKeys asks the other agents on the op for keys to a portal, agents who hold keys for it are notified
+func (o *Operation) RequestKeys(gid GoogleID, portalID PortalID, count int32) (KeyRequest, error) {
+	r := KeyRequest{
+		ID:        GenerateID(16),
+		OpID:      o.ID,
+		PortalID:  portalID,
+		Requester: gid,
+		Count:     count,
+		State:     "open",
+		Pledges:   make([]KeyPledge, 0),
+	}
+
+	if count < 1 || count > keyMaxCount {
+		err := fmt.Errorf("invalid key count")
+		Log.Warnw(err.Error(), "GID", gid, "resource", o.ID, "portal", portalID, "count", count)
+		return r, err
+	}
+
+	// PortalDetails checks read access
+	p, err := o.PortalDetails(portalID, gid)
+	if err != nil {
+		return r, err
+	}
+	r.Name = p.Name
+
+	if _, err := db.Exec("INSERT INTO keyrequest (ID, opID, portalID, gid, count) VALUES (?, ?, ?, ?, ?)", r.ID, o.ID, portalID, gid, count); err != nil {
+		Log.Error(err)
+		return r, err
+	}
+
+	rows, err := db.Query("SELECT DISTINCT gid FROM keyinventory WHERE portalID = ? AND count > 0 AND gid != ? AND gid IN ("+opReaders+")", portalID, gid, o.ID, o.ID, o.ID)
+	if err != nil {
+		Log.Error(err)
+		return r, err
+	}
+	defer rows.Close()
+
+	iname, _ := gid.IngressName()
+	msg := fmt.Sprintf("%s needs %d keys to %s. Pledge keys from the key requests in Wasabee.", iname, count, p.Name)
+	for rows.Next() {
+		var holder GoogleID
+		if err := rows.Scan(&holder); err != nil {
+			Log.Error(err)
+			continue
+		}
+		if _, err := holder.SendMessage(msg); err != nil {
+			Log.Error(err)
+		}
+		holder.FirebaseGenericMessage(msg)
+	}
+
+	Log.Infow("key request", "GID", gid, "resource", o.ID, "portal", portalID, "count", count)
+	return r, nil
+}
+
+// PledgeKeys promises keys toward a request, replacing any earlier pledge by the agent
+func (gid GoogleID) PledgeKeys(requestID string, count int32) error {
+	r, err := keyRequest(requestID)
+	if err != nil {
+		return err
+	}
+	if err := r.checkOpen(gid); err != nil {
+		return err
+	}
+	if r.Requester == gid {
+		err := fmt.Errorf("cannot pledge keys to your own request")
+		Log.Warnw(err.Error(), "GID", gid, "request", requestID)
+		return err
+	}
+	if count < 1 || count > r.Count {
+		err := fmt.Errorf("invalid key count")
+		Log.Warnw(err.Error(), "GID", gid, "request", requestID, "count", count)
+		return err
+	}
+
+	var o Operation
+	o.ID = r.OpID
+	if read, _ := o.ReadAccess(gid); !read {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "request", requestID)
+		return err
+	}
+
+	// a new pledge replaces a pending or withdrawn one, but delivered keys stay counted
+	result, err := db.Exec("UPDATE keypledge SET count = ?, state = 'pledged', updated = UTC_TIMESTAMP() WHERE requestID = ? AND gid = ? AND state != 'delivered'", count, requestID, gid)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if ra, _ := result.RowsAffected(); ra == 0 {
+		result, err = db.Exec("INSERT IGNORE INTO keypledge (requestID, gid, count, state, updated) VALUES (?, ?, ?, 'pledged', UTC_TIMESTAMP())", requestID, gid, count)
+		if err != nil {
+			Log.Error(err)
+			return err
+		}
+		if ra, _ := result.RowsAffected(); ra == 0 {
+			err := fmt.Errorf("keys already delivered to this request")
+			Log.Infow(err.Error(), "GID", gid, "request", requestID)
+			return err
+		}
+	}
+
+	iname, _ := gid.IngressName()
+	msg := fmt.Sprintf("%s pledged %d keys to %s", iname, count, r.Name)
+	if _, err := r.Requester.SendMessage(msg); err != nil {
+		Log.Error(err)
+	}
+	r.Requester.FirebaseGenericMessage(msg)
+	return nil
+}
+
+// WithdrawPledge takes back an agent's undelivered pledge
+func (gid GoogleID) WithdrawPledge(requestID string) error {
+	result, err := db.Exec("UPDATE keypledge SET state = 'withdrawn', updated = UTC_TIMESTAMP() WHERE requestID = ? AND gid = ? AND state = 'pledged'", requestID, gid)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if ra, _ := result.RowsAffected(); ra != 1 {
+		err := fmt.Errorf("no pledge to withdraw")
+		Log.Infow(err.Error(), "GID", gid, "request", requestID)
+		return err
+	}
+	return nil
+}
+
+// DeliverKeys records that an agent dropped the keys they pledged.
+// The keys move from the giver's inventory to the requester's, and the request is fulfilled once enough keys are delivered.
+func (gid GoogleID) DeliverKeys(requestID string) error {
+	r, err := keyRequest(requestID)
+	if err != nil {
+		return err
+	}
+	if err := r.checkOpen(gid); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && err != sql.ErrTxDone {
+			Log.Error(err)
+		}
+	}()
+
+	// lock the request so concurrent deliveries see each other's keys
+	if err := tx.QueryRow("SELECT state FROM keyrequest WHERE ID = ? FOR UPDATE", requestID).Scan(&r.State); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if err := r.checkOpen(gid); err != nil {
+		return err
+	}
+
+	var count int32
+	err = tx.QueryRow("SELECT count FROM keypledge WHERE requestID = ? AND gid = ? AND state = 'pledged' FOR UPDATE", requestID, gid).Scan(&count)
+	if err == sql.ErrNoRows {
+		err := fmt.Errorf("no pledge to deliver")
+		Log.Infow(err.Error(), "GID", gid, "request", requestID)
+		return err
+	}
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE keypledge SET state = 'delivered', updated = UTC_TIMESTAMP() WHERE requestID = ? AND gid = ?", requestID, gid); err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	if err := gid.takeKeys(tx, r.PortalID, count); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO keyinventory (gid, portalID, capsule, count) VALUES (?, ?, '', ?) ON DUPLICATE KEY UPDATE count = LEAST(count + ?, ?)",
+		r.Requester, r.PortalID, count, count, keyMaxCount); err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	var delivered int32
+	if err := tx.QueryRow("SELECT COALESCE(SUM(count), 0) FROM keypledge WHERE requestID = ? AND state = 'delivered'", requestID).Scan(&delivered); err != nil {
+		Log.Error(err)
+		return err
+	}
+	fulfilled := delivered >= r.Count
+	if fulfilled {
+		if _, err := tx.Exec("UPDATE keyrequest SET state = 'fulfilled' WHERE ID = ?", requestID); err != nil {
+			Log.Error(err)
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	var o Operation
+	o.ID = r.OpID
+	if _, err := o.Touch(); err != nil {
+		Log.Error(err)
+	}
+
+	iname, _ := gid.IngressName()
+	msg := fmt.Sprintf("%s dropped %d keys to %s for you", iname, count, r.Name)
+	if fulfilled {
+		msg = fmt.Sprintf("%s, your request for %s is fulfilled", msg, r.Name)
+	}
+	if _, err := r.Requester.SendMessage(msg); err != nil {
+		Log.Error(err)
+	}
+	r.Requester.FirebaseGenericMessage(msg)
+	return nil
+}
+
+// CancelKeyRequest closes a request, only the requester may cancel it
+func (gid GoogleID) CancelKeyRequest(requestID string) error {
+	result, err := db.Exec("UPDATE keyrequest SET state = 'cancelled' WHERE ID = ? AND gid = ? AND state = 'open'", requestID, gid)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if ra, _ := result.RowsAffected(); ra != 1 {
+		err := fmt.Errorf("no open request to cancel")
+		Log.Infow(err.Error(), "GID", gid, "request", requestID)
+		return err
+	}
+	return nil
+}
+
+// KeyRequests lists the open key requests on ops the agent can see, including the agent's own
+func (gid GoogleID) KeyRequests() ([]KeyRequest, error) {
+	return keyRequests("r.state = 'open' AND r.opID IN (SELECT ID FROM operation WHERE gid = ? UNION SELECT opID FROM opcoowners WHERE gid = ? UNION SELECT t.opID FROM opteams=t, agentteams=x WHERE x.gid = ? AND x.suspended = 0 AND (x.teamID = t.teamID OR x.teamID IN (SELECT descendant FROM teamtree WHERE ancestor = t.teamID)))", gid, gid, gid)
+}
+
+// KeyRequests lists the open key requests for an op, no authorization takes place
+func (opID OperationID) KeyRequests() ([]KeyRequest, error) {
+	return keyRequests("r.state = 'open' AND r.opID = ?", opID)
+}
+
+func keyRequests(where string, args ...interface{}) ([]KeyRequest, error) {
+	requests := make([]KeyRequest, 0)
+
+	rows, err := db.Query("SELECT r.ID, r.opID, r.portalID, p.name, r.gid, r.count, r.state, r.created FROM keyrequest=r LEFT JOIN portal=p ON p.ID = r.portalID AND p.opID = r.opID WHERE "+where+" ORDER BY r.created", args...)
+	if err != nil {
+		Log.Error(err)
+		return requests, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r KeyRequest
+		var name sql.NullString
+		if err := rows.Scan(&r.ID, &r.OpID, &r.PortalID, &name, &r.Requester, &r.Count, &r.State, &r.Created); err != nil {
+			Log.Error(err)
+			continue
+		}
+		r.Name = name.String
+		requests = append(requests, r)
+	}
+
+	for i := range requests {
+		if err := requests[i].populatePledges(); err != nil {
+			return requests, err
+		}
+	}
+	return requests, nil
+}
+
+func keyRequest(requestID string) (*KeyRequest, error) {
+	requests, err := keyRequests("r.ID = ?", requestID)
+	if err != nil {
+		return nil, err
+	}
+	if len(requests) != 1 {
+		err := fmt.Errorf("no such key request")
+		Log.Infow(err.Error(), "request", requestID)
+		return nil, err
+	}
+	return &requests[0], nil
+}
+
+func (r *KeyRequest) checkOpen(gid GoogleID) error {
+	if r.State != "open" {
+		err := fmt.Errorf("key request is %s", r.State)
+		Log.Infow(err.Error(), "GID", gid, "request", r.ID)
+		return err
+	}
+	return nil
+}
+
+func (r *KeyRequest) populatePledges() error {
+	r.Pledges = make([]KeyPledge, 0)
+	rows, err := db.Query("SELECT gid, count, state, updated FROM keypledge WHERE requestID = ?", r.ID)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p KeyPledge
+		if err := rows.Scan(&p.GID, &p.Count, &p.State, &p.Updated); err != nil {
+			Log.Error(err)
+			continue
+		}
+		switch p.State {
+		case "pledged":
+			r.Pledged += p.Count
+		case "delivered":
+			r.Delivered += p.Count
+		}
+		r.Pledges = append(r.Pledges, p)
+	}
+	return nil
+}
+
+// takeKeys removes count keys from the agent's inventory, from the main inventory first and then from capsules,
+// refusing if the agent does not hold enough
+func (gid GoogleID) takeKeys(tx *sql.Tx, portalID PortalID, count int32) error {
+	type stack struct {
+		capsule string
+		count   int32
+	}
+	var stacks []stack
+	var total int32
+
+	rows, err := tx.Query("SELECT capsule, count FROM keyinventory WHERE gid = ? AND portalID = ? ORDER BY capsule != '', capsule FOR UPDATE", gid, portalID)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	for rows.Next() {
+		var s stack
+		if err := rows.Scan(&s.capsule, &s.count); err != nil {
+			Log.Error(err)
+			continue
+		}
+		stacks = append(stacks, s)
+		total += s.count
+	}
+	rows.Close()
+
+	if total < count {
+		err := fmt.Errorf("not enough keys to deliver: holding %d of %d", total, count)
+		Log.Infow(err.Error(), "GID", gid, "portal", portalID)
+		return err
+	}
+
+	for _, s := range stacks {
+		if count == 0 {
+			break
+		}
+		take := s.count
+		if take > count {
+			take = count
+		}
+		count -= take
+
+		if take == s.count {
+			_, err = tx.Exec("DELETE FROM keyinventory WHERE gid = ? AND portalID = ? AND capsule = ?", gid, portalID, s.capsule)
+		} else {
+			_, err = tx.Exec("UPDATE keyinventory SET count = ? WHERE gid = ? AND portalID = ? AND capsule = ?", s.count-take, gid, portalID, s.capsule)
+		}
+		if err != nil {
+			Log.Error(err)
+			return err
+		}
+	}
+	return nil
+}
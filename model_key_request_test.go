@@ -0,0 +1,64 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestKeyRequest(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(j, &in); err != nil {
+		t.Error(err.Error())
+	}
+	if len(in.OpPortals) == 0 {
+		t.Fatal("no portals in test op")
+	}
+
+	if _, err := in.RequestKeys(gid, in.OpPortals[0].ID, 0); err == nil {
+		t.Error("zero key request allowed")
+	}
+	r, err := in.RequestKeys(gid, in.OpPortals[0].ID, 3)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	open, err := gid.KeyRequests()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	found := false
+	for _, o := range open {
+		if o.ID == r.ID {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("key request not listed")
+	}
+
+	if err := gid.PledgeKeys(r.ID, 1); err == nil {
+		t.Error("pledged to own request")
+	}
+	if err := gid.CancelKeyRequest(r.ID); err != nil {
+		t.Error(err.Error())
+	}
+	if err := gid.CancelKeyRequest(r.ID); err == nil {
+		t.Error("cancelled request twice")
+	}
+
+	if err := in.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
@@ -0,0 +1,141 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// KeyInventoryItem is a count of keys for a portal held in one location; an empty Capsule is the agent's main inventory
+type KeyInventoryItem struct {
+	PortalID PortalID `json:"portalId"`
+	Name     string   `json:"name,omitempty"`
+	Lat      string   `json:"lat,omitempty"`
+	Lon      string   `json:"lng,omitempty"`
+	Capsule  string   `json:"capsule"`
+	Count    int32    `json:"count"`
+}
+
+// keyMaxCount is above the per-agent limit, because Niantic will Niantic
+const keyMaxCount = 3000
+
+// KeyInventory returns an agent's full key inventory
+func (gid GoogleID) KeyInventory() ([]KeyInventoryItem, error) {
+	items := make([]KeyInventoryItem, 0)
+
+	rows, err := db.Query("SELECT k.portalID, r.name, Y(r.loc), X(r.loc), k.capsule, k.count FROM keyinventory=k LEFT JOIN portalregistry=r ON k.portalID = r.ID WHERE k.gid = ? ORDER BY r.name, k.capsule", gid)
+	if err != nil {
+		Log.Error(err)
+		return items, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k KeyInventoryItem
+		var name, lat, lon sql.NullString
+		if err := rows.Scan(&k.PortalID, &name, &lat, &lon, &k.Capsule, &k.Count); err != nil {
+			Log.Error(err)
+			continue
+		}
+		k.Name = name.String
+		k.Lat = lat.String
+		k.Lon = lon.String
+		items = append(items, k)
+	}
+	return items, nil
+}
+
+// SetKeyCount sets the number of keys an agent holds for a portal in one location, a count of zero removes the entry
+func (gid GoogleID) SetKeyCount(portalID PortalID, capsule string, count int32) error {
+	if count < 0 {
+		count = 0
+	}
+	if count > keyMaxCount {
+		count = keyMaxCount
+	}
+	if len(capsule) > 16 {
+		err := fmt.Errorf("capsule name too long")
+		Log.Warnw(err.Error(), "GID", gid, "portal", portalID, "capsule", capsule)
+		return err
+	}
+
+	if count == 0 {
+		if _, err := db.Exec("DELETE FROM keyinventory WHERE gid = ? AND portalID = ? AND capsule = ?", gid, portalID, capsule); err != nil {
+			Log.Error(err)
+			return err
+		}
+		return nil
+	}
+
+	if _, err := db.Exec("INSERT INTO keyinventory (gid, portalID, capsule, count) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE count = ?", gid, portalID, capsule, count, count); err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// ReplaceKeyInventory discards the agent's current inventory and loads the one supplied, e.g. from an inventory export
+func (gid GoogleID) ReplaceKeyInventory(items []KeyInventoryItem) error {
+	// collapse duplicates, the exports list each key stack separately
+	counts := make(map[PortalID]map[string]int32)
+	for _, k := range items {
+		if k.PortalID == "" || k.Count < 1 {
+			continue
+		}
+		if len(k.Capsule) > 16 {
+			err := fmt.Errorf("capsule name too long")
+			Log.Warnw(err.Error(), "GID", gid, "portal", k.PortalID, "capsule", k.Capsule)
+			return err
+		}
+		if _, ok := counts[k.PortalID]; !ok {
+			counts[k.PortalID] = make(map[string]int32)
+		}
+		counts[k.PortalID][k.Capsule] += k.Count
+		_ = registerPortal(k.PortalID, k.Name, k.Lat, k.Lon)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && err != sql.ErrTxDone {
+			Log.Error(err)
+		}
+	}()
+
+	if _, err := tx.Exec("DELETE FROM keyinventory WHERE gid = ?", gid); err != nil {
+		Log.Error(err)
+		return err
+	}
+	for portalID, capsules := range counts {
+		for capsule, count := range capsules {
+			if count > keyMaxCount {
+				count = keyMaxCount
+			}
+			if _, err := tx.Exec("INSERT INTO keyinventory (gid, portalID, capsule, count) VALUES (?, ?, ?, ?)", gid, portalID, capsule, count); err != nil {
+				Log.Error(err)
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		Log.Error(err)
+		return err
+	}
+	Log.Infow("key inventory replaced", "GID", gid, "portals", len(counts))
+	return nil
+}
+
+// keyTotal returns the total keys an agent holds for a portal and the capsules they are in
+func (gid GoogleID) keyTotal(portalID PortalID) (int32, string, error) {
+	var count sql.NullInt64
+	var capsules sql.NullString
+	err := db.QueryRow("SELECT SUM(count), GROUP_CONCAT(NULLIF(capsule, '') SEPARATOR ',') FROM keyinventory WHERE gid = ? AND portalID = ?", gid, portalID).Scan(&count, &capsules)
+	if err != nil {
+		Log.Error(err)
+		return 0, "", err
+	}
+	return int32(count.Int64), capsules.String, nil
+}
@@ -0,0 +1,87 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestKeyInventory(t *testing.T) {
+	in := []wasabee.KeyInventoryItem{
+		{PortalID: "keytest.1", Name: "Key Test One", Lat: "34.69075", Lon: "-93.942638", Count: 3},
+		{PortalID: "keytest.1", Name: "Key Test One", Lat: "34.69075", Lon: "-93.942638", Count: 2},
+		{PortalID: "keytest.1", Capsule: "ABCD1234", Count: 10},
+		{PortalID: "keytest.2", Count: 0},
+	}
+	if err := gid.ReplaceKeyInventory(in); err != nil {
+		t.Error(err.Error())
+	}
+
+	keys, err := gid.KeyInventory()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	counts := make(map[string]int32)
+	for _, k := range keys {
+		counts[string(k.PortalID)+"/"+k.Capsule] = k.Count
+	}
+	if counts["keytest.1/"] != 5 || counts["keytest.1/ABCD1234"] != 10 {
+		t.Errorf("inventory not stored correctly: %v", counts)
+	}
+	if _, ok := counts["keytest.2/"]; ok {
+		t.Error("zero count stored")
+	}
+
+	if err := gid.SetKeyCount("keytest.1", "ABCD1234", 0); err != nil {
+		t.Error(err.Error())
+	}
+	keys, _ = gid.KeyInventory()
+	for _, k := range keys {
+		if k.Capsule == "ABCD1234" {
+			t.Error("capsule not cleared")
+		}
+	}
+
+	if err := gid.ReplaceKeyInventory(nil); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestOpKeysUpload(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test3.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	var in wasabee.Operation
+	if err := json.Unmarshal(content, &in); err != nil {
+		t.Error(err.Error())
+	}
+	portal := in.OpPortals[0].ID
+	in.Keys = []wasabee.KeyOnHand{
+		{ID: portal, Gid: gid, Onhand: 4},
+		// counts for other agents are not the uploader's to set
+		{ID: portal, Gid: "104743827901423568948", Onhand: 9},
+	}
+	j, _ := json.Marshal(in)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var o wasabee.Operation
+	o.ID = in.ID
+	if err := o.Populate(gid); err != nil {
+		t.Error(err.Error())
+	}
+	if len(o.Keys) != 1 || o.Keys[0].Gid != gid || o.Keys[0].Onhand != 4 {
+		t.Errorf("uploaded keys not stored: %+v", o.Keys)
+	}
+
+	if err := o.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+	if err := gid.ReplaceKeyInventory(nil); err != nil {
+		t.Error(err.Error())
+	}
+}
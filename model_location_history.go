@@ -0,0 +1,132 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// LocationPoint is one recorded position in an agent's location history
+type LocationPoint struct {
+	Gid      GoogleID `json:"gid"`
+	Lat      float64  `json:"lat"`
+	Lon      float64  `json:"lng"`
+	Recorded string   `json:"recorded"`
+}
+
+// LocationHistorySettings is an agent's opt-in to location history and how long to keep it
+type LocationHistorySettings struct {
+	Enabled       bool `json:"enabled"`
+	RetentionDays int  `json:"retentionDays"`
+}
+
+const (
+	defaultLocationRetentionDays = 7
+	maxLocationRetentionDays     = 90
+)
+
+// LocationHistorySettings returns the agent's location history settings
+func (gid GoogleID) LocationHistorySettings() (LocationHistorySettings, error) {
+	var s LocationHistorySettings
+	err := db.QueryRow("SELECT lochistory, lochistorydays FROM agent WHERE gid = ?", gid).Scan(&s.Enabled, &s.RetentionDays)
+	if err != nil && err != sql.ErrNoRows {
+		Log.Error(err)
+	}
+	return s, err
+}
+
+// SetLocationHistory turns location history on or off for the agent, turning it off discards the recorded history
+func (gid GoogleID) SetLocationHistory(s LocationHistorySettings) error {
+	if s.RetentionDays == 0 {
+		s.RetentionDays = defaultLocationRetentionDays
+	}
+	if s.RetentionDays < 1 || s.RetentionDays > maxLocationRetentionDays {
+		err := fmt.Errorf("retention must be between 1 and %d days", maxLocationRetentionDays)
+		Log.Warnw(err.Error(), "GID", gid, "days", s.RetentionDays)
+		return err
+	}
+
+	if _, err := db.Exec("UPDATE agent SET lochistory = ?, lochistorydays = ? WHERE gid = ?", s.Enabled, s.RetentionDays, gid); err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	if !s.Enabled {
+		if _, err := db.Exec("DELETE FROM locationhistory WHERE gid = ?", gid); err != nil {
+			Log.Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// recordLocation adds a point to the agent's history if they have opted in
+func (gid GoogleID) recordLocation(point string) {
+	if _, err := db.Exec("INSERT INTO locationhistory (gid, loc, recorded) SELECT gid, PointFromText(?), UTC_TIMESTAMP() FROM agent WHERE gid = ? AND lochistory = 1", point, gid); err != nil {
+		Log.Error(err)
+	}
+}
+
+// LocationHistory returns the agent's own recorded locations between start and end
+func (gid GoogleID) LocationHistory(start, end time.Time) ([]LocationPoint, error) {
+	return locationHistory("SELECT h.gid, 0, Y(h.loc), X(h.loc), h.recorded FROM locationhistory=h WHERE h.gid = ? AND h.recorded BETWEEN ? AND ?", start, end, gid)
+}
+
+// LocationHistory returns the recorded locations of the team's members who are sharing their location with the team, at the precision they share it.
+// Only points recorded since the agent last turned sharing on are included. No authorization takes place.
+func (teamID TeamID) LocationHistory(start, end time.Time) ([]LocationPoint, error) {
+	return locationHistory("SELECT h.gid, x.locprecision, Y(h.loc), X(h.loc), h.recorded FROM locationhistory=h, agentteams=x WHERE x.teamID = ? AND x.gid = h.gid AND x.suspended = 0 AND "+locationShared+" AND h.recorded >= x.sharesince AND h.recorded BETWEEN ? AND ?", start, end, teamID)
+}
+
+// LocationHistory returns the recorded locations of agents sharing their location with one of the op's teams (including sub-teams) which the viewer is also on,
+// at the finest precision they share with those teams, since they last turned sharing on with that team.
+// Agents who only share with teams the viewer is not on are left out. Op access is not checked.
+func (o *Operation) LocationHistory(gid GoogleID, start, end time.Time) ([]LocationPoint, error) {
+	return locationHistory("SELECT h.gid, (SELECT MIN(x.locprecision) FROM agentteams=x, agentteams=me, opteams=t WHERE t.opID = ? AND me.gid = ? AND me.teamID = x.teamID AND me.suspended = 0 AND x.gid = h.gid AND (x.teamID = t.teamID OR x.teamID IN (SELECT descendant FROM teamtree WHERE ancestor = t.teamID)) AND x.suspended = 0 AND "+locationShared+" AND h.recorded >= x.sharesince) AS p, "+
+		"Y(h.loc), X(h.loc), h.recorded FROM locationhistory=h WHERE h.recorded BETWEEN ? AND ? HAVING p IS NOT NULL", start, end, o.ID, gid)
+}
+
+// locationHistory runs a query selecting gid, precision, lat, lon and time, taking args then the start and end of the range
+func locationHistory(query string, start, end time.Time, args ...interface{}) ([]LocationPoint, error) {
+	points := make([]LocationPoint, 0)
+
+	if end.IsZero() {
+		end = time.Now()
+	}
+	if start.IsZero() || !start.Before(end) {
+		err := fmt.Errorf("invalid time range")
+		Log.Warnw(err.Error(), "start", start, "end", end)
+		return points, err
+	}
+
+	args = append(args, start.UTC().Format("2006-01-02 15:04:05"), end.UTC().Format("2006-01-02 15:04:05"))
+	rows, err := db.Query(query+" ORDER BY h.gid, h.recorded", args...)
+	if err != nil {
+		Log.Error(err)
+		return points, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p LocationPoint
+		var lat, lon string
+		var precision LocationPrecision
+		if err := rows.Scan(&p.Gid, &precision, &lat, &lon, &p.Recorded); err != nil {
+			Log.Error(err)
+			continue
+		}
+		p.Lat, _ = strconv.ParseFloat(lat, 64)
+		p.Lon, _ = strconv.ParseFloat(lon, 64)
+		p.Lat, p.Lon = precision.Blur(p.Lat, p.Lon)
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// locationHistoryClean purges points older than each agent's retention
+func locationHistoryClean() {
+	if _, err := db.Exec("DELETE FROM locationhistory WHERE recorded < DATE_SUB(UTC_TIMESTAMP(), INTERVAL (SELECT lochistorydays FROM agent WHERE agent.gid = locationhistory.gid) DAY)"); err != nil {
+		Log.Error(err)
+	}
+}
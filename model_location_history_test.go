@@ -0,0 +1,94 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestOpLocationHistory(t *testing.T) {
+	reader := wasabee.GoogleID("104743827901423568948")
+	if err := (wasabee.AgentData{GoogleID: reader, IngressName: "historyreader", Level: 8}).Save(); err != nil {
+		t.Error(err.Error())
+	}
+
+	teamA, err := gid.NewTeam("History Team A")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	teamB, err := gid.NewTeam("History Team B")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if err := teamA.AddAgent(reader); err != nil {
+		t.Error(err.Error())
+	}
+
+	content, err := ioutil.ReadFile("testdata/test3.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+	var op wasabee.Operation
+	if err := json.Unmarshal(j, &op); err != nil {
+		t.Error(err.Error())
+	}
+	for _, team := range []wasabee.TeamID{teamA, teamB} {
+		if _, err := op.AddPerm(gid, team, "read", wasabee.ZoneAll); err != nil {
+			t.Error(err.Error())
+		}
+	}
+
+	// only share with team B, which the reader is not on
+	if err := gid.SetLocationHistory(wasabee.LocationHistorySettings{Enabled: true}); err != nil {
+		t.Error(err.Error())
+	}
+	if err := gid.SetTeamState(teamA, "Off"); err != nil {
+		t.Error(err.Error())
+	}
+	if err := gid.SetTeamState(teamB, "On"); err != nil {
+		t.Error(err.Error())
+	}
+	start := time.Now().Add(-1 * time.Minute)
+	if err := gid.AgentLocation("33.149", "-96.788"); err != nil {
+		t.Error(err.Error())
+	}
+	end := time.Now().Add(time.Minute)
+
+	points, err := op.LocationHistory(gid, start, end)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(points) == 0 {
+		t.Error("team B member cannot see shared history")
+	}
+
+	points, err = op.LocationHistory(reader, start, end)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	for _, p := range points {
+		if p.Gid == gid {
+			t.Error("team A reader sees history shared only with team B")
+		}
+	}
+
+	if err := gid.SetLocationHistory(wasabee.LocationHistorySettings{}); err != nil {
+		t.Error(err.Error())
+	}
+	if err := op.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+	if err := teamA.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+	if err := teamB.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
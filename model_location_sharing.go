@@ -0,0 +1,108 @@
+package wasabee
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// LocationPrecision is how exactly an agent's location is shown to a team
+type LocationPrecision int
+
+// The coarser precisions snap the location to the center of a grid cell
+const (
+	PrecisionExact  LocationPrecision = 0
+	PrecisionApprox LocationPrecision = 1 // 0.01 degree cells, about 1 km
+	PrecisionRegion LocationPrecision = 2 // 0.1 degree cells, about 10 km
+)
+
+// sharingWindowOpen is true for agentteams=x rows with no sharing window or one that is still open.
+// An op window closes when the op is frozen or deleted.
+const sharingWindowOpen = "(x.shareuntil IS NULL OR x.shareuntil > UTC_TIMESTAMP()) AND (x.shareop IS NULL OR x.shareop IN (SELECT ID FROM operation WHERE frozen = 0))"
+
+// locationShared limits agentteams=x to agents currently sharing their location with the team
+const locationShared = "x.state = 'On' AND x.role != 'observer' AND " + sharingWindowOpen
+
+// shareSince keeps the start time of an agentteams row that is still sharing, or starts sharing now.
+// It reads the old state and window, so it must come first in the SET list.
+const shareSince = "sharesince = IF(state = 'On' AND sharesince IS NOT NULL AND (shareuntil IS NULL OR shareuntil > UTC_TIMESTAMP()) AND (shareop IS NULL OR shareop IN (SELECT ID FROM operation WHERE frozen = 0)), sharesince, UTC_TIMESTAMP())"
+
+// Valid reports whether the precision is one the server knows
+func (p LocationPrecision) Valid() bool {
+	return p >= PrecisionExact && p <= PrecisionRegion
+}
+
+func (p LocationPrecision) cellSize() float64 {
+	switch p {
+	case PrecisionApprox:
+		return 0.01
+	case PrecisionRegion:
+		return 0.1
+	}
+	return 0
+}
+
+// Blur reduces a location to the precision, exact locations are returned unchanged
+func (p LocationPrecision) Blur(lat, lon float64) (float64, float64) {
+	cell := p.cellSize()
+	if cell == 0 {
+		return lat, lon
+	}
+	return math.Floor(lat/cell)*cell + cell/2, math.Floor(lon/cell)*cell + cell/2
+}
+
+// SetLocationPrecision sets how exactly the agent's location is shown to a team
+func (gid GoogleID) SetLocationPrecision(teamID TeamID, p LocationPrecision) error {
+	if !p.Valid() {
+		err := fmt.Errorf("invalid location precision: %d", p)
+		Log.Warnw(err.Error(), "GID", gid, "resource", teamID)
+		return err
+	}
+
+	if _, err := db.Exec("UPDATE agentteams SET locprecision = ? WHERE gid = ? AND teamID = ?", p, gid, teamID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// ShareLocationUntil turns on location sharing with a team for a limited time, until an op ends, or whichever comes first.
+// At least one of until and opID must be set; SetTeamState turns sharing on or off indefinitely.
+func (gid GoogleID) ShareLocationUntil(teamID TeamID, until time.Time, opID OperationID) error {
+	if until.IsZero() && opID == "" {
+		err := fmt.Errorf("sharing window requires an end time or an operation")
+		Log.Warnw(err.Error(), "GID", gid, "resource", teamID)
+		return err
+	}
+	if !until.IsZero() && until.Before(time.Now()) {
+		err := fmt.Errorf("sharing window already ended")
+		Log.Warnw(err.Error(), "GID", gid, "resource", teamID, "until", until)
+		return err
+	}
+	if opID != "" {
+		var op Operation
+		op.ID = opID
+		if read, _ := op.ReadAccess(gid); !read {
+			err := fmt.Errorf("cannot share location until the end of an operation the agent cannot see")
+			Log.Warnw(err.Error(), "GID", gid, "resource", teamID, "opID", opID)
+			return err
+		}
+	}
+
+	var u interface{}
+	if !until.IsZero() {
+		u = until.UTC().Format("2006-01-02 15:04:05")
+	}
+	if _, err := db.Exec("UPDATE agentteams SET "+shareSince+", state = 'On', shareuntil = ?, shareop = ? WHERE gid = ? AND teamID = ? AND suspended = 0", u, MakeNullString(string(opID)), gid, teamID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// locationShareClean turns off sharing whose window has closed, so agents see the current state on their team lists
+func locationShareClean() {
+	if _, err := db.Exec("UPDATE agentteams SET state = 'Off', shareuntil = NULL, shareop = NULL WHERE (shareuntil IS NOT NULL AND shareuntil <= UTC_TIMESTAMP()) OR (shareop IS NOT NULL AND shareop NOT IN (SELECT ID FROM operation WHERE frozen = 0))"); err != nil {
+		Log.Error(err)
+	}
+}
@@ -0,0 +1,61 @@
+package wasabee_test
+
+import (
+	"github.com/wasabee-project/Wasabee-Server"
+	"testing"
+	"time"
+)
+
+func TestLocationPrecisionBlur(t *testing.T) {
+	lat, lon := wasabee.PrecisionExact.Blur(33.14812, -96.78734)
+	if lat != 33.14812 || lon != -96.78734 {
+		t.Error("exact location changed")
+	}
+
+	lat, lon = wasabee.PrecisionRegion.Blur(33.14812, -96.78734)
+	if lat < 33.1 || lat > 33.2 || lon < -96.8 || lon > -96.7 {
+		t.Errorf("region cell wrong: %f %f", lat, lon)
+	}
+	// every point in the cell is reported the same
+	if l2, o2 := wasabee.PrecisionRegion.Blur(33.19, -96.71); l2 != lat || o2 != lon {
+		t.Error("points in the same cell reported differently")
+	}
+
+	if wasabee.LocationPrecision(7).Valid() {
+		t.Error("unknown precision accepted")
+	}
+}
+
+func TestShareLocationUntil(t *testing.T) {
+	teamID, err := gid.NewTeam("Sharing Team")
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if err := gid.SetLocationPrecision(teamID, wasabee.PrecisionApprox); err != nil {
+		t.Error(err.Error())
+	}
+	if err := gid.ShareLocationUntil(teamID, time.Time{}, ""); err == nil {
+		t.Error("sharing window without an end accepted")
+	}
+	if err := gid.ShareLocationUntil(teamID, time.Now().Add(-1*time.Hour), ""); err == nil {
+		t.Error("sharing window in the past accepted")
+	}
+	if err := gid.ShareLocationUntil(teamID, time.Now().Add(time.Hour), ""); err != nil {
+		t.Error(err.Error())
+	}
+
+	var td wasabee.TeamData
+	if err := teamID.FetchTeam(&td); err != nil {
+		t.Error(err.Error())
+	}
+	for _, a := range td.Agent {
+		if a.Gid == gid && (!a.State || a.Precision != wasabee.PrecisionApprox) {
+			t.Error("sharing window or precision not applied")
+		}
+	}
+
+	if err := teamID.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
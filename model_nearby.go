@@ -0,0 +1,188 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NearbyTask is an unassigned or self-assigned marker or link near the agent
+type NearbyTask struct {
+	OpID       OperationID `json:"opID"`
+	OpName     string      `json:"opName"`
+	Kind       string      `json:"kind"` // marker or link
+	ID         string      `json:"ID"`
+	Type       MarkerType  `json:"type,omitempty"`
+	PortalID   PortalID    `json:"portalID"`
+	PortalName string      `json:"portalName"`
+	Lat        string      `json:"lat"`
+	Lon        string      `json:"lng"`
+	Distance   float64     `json:"distance"` // km
+	AssignedTo GoogleID    `json:"assignedTo"`
+	Zone       Zone        `json:"zone"`
+	CanAssign  bool        `json:"canAssign"`
+	Assign     string      `json:"assign,omitempty"` // API path to self-assign, if permitted
+}
+
+const (
+	nearbyDefaultRadius = 5.0
+	nearbyMaxRadius     = 100.0
+	nearbyDefaultLimit  = 50
+	nearbyMaxLimit      = 200
+)
+
+// NearbyTasks finds open markers and links within radius km of the agent's last location, across every op the agent can see.
+// Only markers of the listed types are returned if types are given, links are only returned if no types are given or "link" is listed.
+func (gid GoogleID) NearbyTasks(radius float64, types []string, limit int) ([]NearbyTask, error) {
+	tasks := make([]NearbyTask, 0)
+
+	var lat, lon string
+	err := db.QueryRow("SELECT Y(loc), X(loc) FROM locations WHERE gid = ?", gid).Scan(&lat, &lon)
+	if err == sql.ErrNoRows || (err == nil && lat == "0" && lon == "0") {
+		err := fmt.Errorf("no current location")
+		Log.Infow(err.Error(), "GID", gid)
+		return tasks, err
+	}
+	if err != nil {
+		Log.Error(err)
+		return tasks, err
+	}
+
+	if radius <= 0 {
+		radius = nearbyDefaultRadius
+	}
+	if radius > nearbyMaxRadius {
+		radius = nearbyMaxRadius
+	}
+	if limit < 1 {
+		limit = nearbyDefaultLimit
+	}
+	if limit > nearbyMaxLimit {
+		limit = nearbyMaxLimit
+	}
+
+	wantLinks := len(types) == 0
+	var markerTypes []interface{}
+	for _, t := range types {
+		if t == "link" {
+			wantLinks = true
+			continue
+		}
+		markerTypes = append(markerTypes, t)
+	}
+
+	distance := distanceSQL("Y(p.loc)", "X(p.loc)")
+	readable := "(SELECT ID FROM operation WHERE gid = ? UNION SELECT opID FROM opcoowners WHERE gid = ? UNION SELECT t.opID FROM opteams=t, agentteams=x WHERE x.gid = ? AND x.suspended = 0 AND (x.teamID = t.teamID OR x.teamID IN (SELECT descendant FROM teamtree WHERE ancestor = t.teamID)))"
+
+	if len(types) == 0 || len(markerTypes) > 0 {
+		q := "SELECT m.opID, o.name, m.ID, m.type, m.portalID, p.name, Y(p.loc), X(p.loc), m.gid, m.zone, " + distance + " AS distance " +
+			"FROM marker=m, portal=p, operation=o WHERE m.opID IN " + readable + " AND p.ID = m.portalID AND p.opID = m.opID AND o.ID = m.opID " +
+			"AND m.state != 'completed' AND (m.gid IS NULL OR m.gid = ?)"
+		args := []interface{}{lat, lon, lat, gid, gid, gid, gid}
+		if len(markerTypes) > 0 {
+			q = q + " AND m.type IN (" + strings.TrimSuffix(strings.Repeat("?,", len(markerTypes)), ",") + ")"
+			args = append(args, markerTypes...)
+		}
+		q = q + " HAVING distance < ? ORDER BY distance LIMIT ?"
+		args = append(args, radius, limit)
+
+		if err := nearbyQuery(&tasks, "marker", q, args...); err != nil {
+			return tasks, err
+		}
+	}
+
+	if wantLinks {
+		q := "SELECT l.opID, o.name, l.ID, '', l.fromPortalID, p.name, Y(p.loc), X(p.loc), l.gid, l.zone, " + distance + " AS distance " +
+			"FROM link=l, portal=p, operation=o WHERE l.opID IN " + readable + " AND p.ID = l.fromPortalID AND p.opID = l.opID AND o.ID = l.opID " +
+			"AND l.completed = 0 AND (l.gid IS NULL OR l.gid = ?) HAVING distance < ? ORDER BY distance LIMIT ?"
+		if err := nearbyQuery(&tasks, "link", q, lat, lon, lat, gid, gid, gid, gid, radius, limit); err != nil {
+			return tasks, err
+		}
+	}
+
+	tasks = gid.filterNearby(tasks)
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].Distance < tasks[j].Distance
+	})
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
+	return tasks, nil
+}
+
+func nearbyQuery(tasks *[]NearbyTask, kind, q string, args ...interface{}) error {
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := NearbyTask{Kind: kind}
+		var assigned sql.NullString
+		if err := rows.Scan(&t.OpID, &t.OpName, &t.ID, &t.Type, &t.PortalID, &t.PortalName, &t.Lat, &t.Lon, &assigned, &t.Zone, &t.Distance); err != nil {
+			Log.Error(err)
+			continue
+		}
+		t.AssignedTo = GoogleID(assigned.String)
+		*tasks = append(*tasks, t)
+	}
+	return nil
+}
+
+// filterNearby applies each op's zone and assigned-only permissions and sets the self-assign action
+func (gid GoogleID) filterNearby(in []NearbyTask) []NearbyTask {
+	type opAccess struct {
+		read      bool
+		zones     []Zone
+		canAssign bool
+		claims    *ClaimPolicy
+	}
+	access := make(map[OperationID]opAccess)
+
+	out := make([]NearbyTask, 0, len(in))
+	for _, t := range in {
+		a, ok := access[t.OpID]
+		if !ok {
+			o := Operation{ID: t.OpID}
+			a.read, a.zones = o.ReadAccess(gid)
+			a.canAssign, a.claims = o.canSelfAssign(gid)
+			access[t.OpID] = a
+		}
+
+		// assigned-only agents see only their own tasks
+		if t.AssignedTo != gid && (!a.read || !t.Zone.inZones(a.zones)) {
+			continue
+		}
+
+		if t.AssignedTo == "" {
+			if a.canAssign {
+				t.CanAssign = true
+				t.Assign = fmt.Sprintf("/api/v1/draw/%s/%s/%s/assign", t.OpID, t.Kind, t.ID)
+			} else if a.claims.permits(t.Kind, t.Type, t.Zone) {
+				t.CanAssign = true
+				t.Assign = fmt.Sprintf("/api/v1/draw/%s/%s/%s/claim", t.OpID, t.Kind, t.ID)
+			}
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// canSelfAssign reports if an agent may assign tasks in the op to themselves,
+// and otherwise the claim policy under which they may claim them
+func (o *Operation) canSelfAssign(gid GoogleID) (bool, *ClaimPolicy) {
+	if o.ID.IsFrozen() {
+		return false, nil
+	}
+	if o.WriteAccess(gid) {
+		return true, nil
+	}
+	p, err := o.ID.ClaimPolicy()
+	if err != nil {
+		return false, nil
+	}
+	return false, p
+}
@@ -0,0 +1,61 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestNearbyTasks(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(j, &in); err != nil {
+		t.Error(err.Error())
+	}
+	if len(in.OpPortals) == 0 {
+		t.Fatal("no portals in test op")
+	}
+
+	if err := gid.AgentLocation(in.OpPortals[0].Lat, in.OpPortals[0].Lon); err != nil {
+		t.Error(err.Error())
+	}
+
+	tasks, err := gid.NearbyTasks(50, nil, 0)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	for i := 1; i < len(tasks); i++ {
+		if tasks[i].Distance < tasks[i-1].Distance {
+			t.Error("nearby tasks not sorted by distance")
+		}
+	}
+	for _, task := range tasks {
+		if task.AssignedTo != "" && task.AssignedTo != gid {
+			t.Error("task assigned to someone else returned")
+		}
+	}
+
+	links, err := gid.NearbyTasks(50, []string{"link"}, 0)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	for _, task := range links {
+		if task.Kind != "link" {
+			t.Error("type filter not applied")
+		}
+	}
+
+	if err := in.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
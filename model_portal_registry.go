@@ -0,0 +1,249 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// RegistryPortal is the server-wide record of a portal, updated from every op upload and defensive key submission.
+// Notes are the team-shared comments and hardness visible to the requesting agent.
+type RegistryPortal struct {
+	ID       PortalID     `json:"id"`
+	Name     string       `json:"name"`
+	Lat      string       `json:"lat"`
+	Lon      string       `json:"lng"`
+	Updated  string       `json:"updated"`
+	Distance float64      `json:"distance,omitempty"`
+	Notes    []PortalNote `json:"notes"`
+}
+
+// PortalNote is a comment and hardness for a portal, shared with a single team
+type PortalNote struct {
+	TeamID   TeamID   `json:"teamID"`
+	GID      GoogleID `json:"gid"`
+	Comment  string   `json:"comment"`
+	Hardness string   `json:"hardness"`
+	Updated  string   `json:"updated"`
+}
+
+// PortalSearch is the set of filters for a portal registry search; zero values are ignored.
+// Radius is in km and is only used if Lat and Lon are set.
+type PortalSearch struct {
+	Name   string
+	Lat    string
+	Lon    string
+	Radius float64
+	Limit  int
+}
+
+const (
+	portalSearchDefaultLimit = 50
+	portalSearchMaxLimit     = 200
+	portalSearchMaxRadius    = 100.0
+	kmPerDegree              = 111.2 // of latitude, and of longitude at the equator
+)
+
+// registerPortal adds or refreshes a portal's name and location in the registry
+func registerPortal(portalID PortalID, name, lat, lon string) error {
+	if portalID == "" || name == "" || lat == "" || lon == "" {
+		return nil
+	}
+
+	_, err := db.Exec("INSERT INTO portalregistry (ID, name, loc, updated) VALUES (?, ?, POINT(?, ?), UTC_TIMESTAMP()) ON DUPLICATE KEY UPDATE name = VALUES(name), loc = VALUES(loc), updated = UTC_TIMESTAMP()",
+		portalID, name, lon, lat)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// RegistryPortal returns the registry entry for a portal along with the notes shared to the agent's teams
+func (gid GoogleID) RegistryPortal(portalID PortalID) (RegistryPortal, error) {
+	p := RegistryPortal{
+		ID:    portalID,
+		Notes: make([]PortalNote, 0),
+	}
+
+	err := db.QueryRow("SELECT name, Y(loc), X(loc), updated FROM portalregistry WHERE ID = ?", portalID).Scan(&p.Name, &p.Lat, &p.Lon, &p.Updated)
+	if err == sql.ErrNoRows {
+		err := fmt.Errorf("portal not found")
+		Log.Infow(err.Error(), "GID", gid, "portal", portalID)
+		return p, err
+	}
+	if err != nil {
+		Log.Error(err)
+		return p, err
+	}
+
+	if err := gid.portalNotes(&p); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// SearchPortals finds portals in the registry by name and/or proximity
+func (gid GoogleID) SearchPortals(s PortalSearch) ([]RegistryPortal, error) {
+	portals := make([]RegistryPortal, 0)
+
+	var where []string
+	var args []interface{}
+	distance := "0"
+
+	if s.Name != "" {
+		where = append(where, "name LIKE ?")
+		args = append(args, "%"+likeEscape(s.Name)+"%")
+	}
+
+	if s.Lat != "" && s.Lon != "" {
+		if s.Radius <= 0 || s.Radius > portalSearchMaxRadius {
+			s.Radius = portalSearchMaxRadius
+		}
+		lat, laterr := strconv.ParseFloat(s.Lat, 64)
+		lon, lonerr := strconv.ParseFloat(s.Lon, 64)
+		if laterr != nil || lonerr != nil {
+			err := fmt.Errorf("invalid location")
+			Log.Warnw(err.Error(), "GID", gid, "lat", s.Lat, "lon", s.Lon)
+			return portals, err
+		}
+
+		distance = distanceSQL("Y(loc)", "X(loc)")
+		args = append([]interface{}{s.Lat, s.Lon, s.Lat}, args...)
+
+		// a cheap bounding box first, so the distance is only computed near the point
+		dlat := s.Radius / kmPerDegree
+		where = append(where, "Y(loc) BETWEEN ? AND ?")
+		args = append(args, lat-dlat, lat+dlat)
+		if c := math.Cos(lat * math.Pi / 180); c > 0.01 {
+			dlon := s.Radius / (kmPerDegree * c)
+			if lon-dlon >= -180 && lon+dlon <= 180 {
+				where = append(where, "X(loc) BETWEEN ? AND ?")
+				args = append(args, lon-dlon, lon+dlon)
+			}
+		}
+	}
+
+	if len(where) == 0 && distance == "0" {
+		err := fmt.Errorf("portal search requires a name or location")
+		Log.Infow(err.Error(), "GID", gid)
+		return portals, err
+	}
+
+	if s.Limit < 1 {
+		s.Limit = portalSearchDefaultLimit
+	}
+	if s.Limit > portalSearchMaxLimit {
+		s.Limit = portalSearchMaxLimit
+	}
+
+	q := fmt.Sprintf("SELECT ID, name, Y(loc), X(loc), updated, %s AS distance FROM portalregistry", distance)
+	if len(where) > 0 {
+		q = q + " WHERE " + strings.Join(where, " AND ")
+	}
+	if distance != "0" {
+		q = q + " HAVING distance < ? ORDER BY distance"
+		args = append(args, s.Radius)
+	} else {
+		q = q + " ORDER BY name"
+	}
+	q = q + " LIMIT ?"
+	args = append(args, s.Limit)
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		Log.Error(err)
+		return portals, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := RegistryPortal{
+			Notes: make([]PortalNote, 0),
+		}
+		if err := rows.Scan(&p.ID, &p.Name, &p.Lat, &p.Lon, &p.Updated, &p.Distance); err != nil {
+			Log.Error(err)
+			continue
+		}
+		portals = append(portals, p)
+	}
+
+	for i := range portals {
+		if err := gid.portalNotes(&portals[i]); err != nil {
+			return portals, err
+		}
+	}
+	return portals, nil
+}
+
+// SetPortalNote shares a comment and hardness for a portal with one of the agent's teams, replacing the team's previous note
+func (gid GoogleID) SetPortalNote(portalID PortalID, teamID TeamID, comment, hardness string) error {
+	if inteam, _ := gid.AgentInTeam(teamID); !inteam {
+		err := fmt.Errorf("not a member of team")
+		Log.Warnw(err.Error(), "GID", gid, "resource", teamID, "portal", portalID)
+		return err
+	}
+
+	var exists bool
+	if err := db.QueryRow("SELECT COUNT(*) FROM portalregistry WHERE ID = ?", portalID).Scan(&exists); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if !exists {
+		err := fmt.Errorf("portal not found")
+		Log.Infow(err.Error(), "GID", gid, "portal", portalID)
+		return err
+	}
+
+	if comment == "" && hardness == "" {
+		return gid.DeletePortalNote(portalID, teamID)
+	}
+
+	_, err := db.Exec("REPLACE INTO portalnotes (portalID, teamID, gid, comment, hardness, updated) VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP())",
+		portalID, teamID, gid, MakeNullString(comment), MakeNullString(hardness))
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// DeletePortalNote removes a team's note on a portal
+func (gid GoogleID) DeletePortalNote(portalID PortalID, teamID TeamID) error {
+	if inteam, _ := gid.AgentInTeam(teamID); !inteam {
+		err := fmt.Errorf("not a member of team")
+		Log.Warnw(err.Error(), "GID", gid, "resource", teamID, "portal", portalID)
+		return err
+	}
+
+	if _, err := db.Exec("DELETE FROM portalnotes WHERE portalID = ? AND teamID = ?", portalID, teamID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// portalNotes fills in the notes shared with any team the agent is on
+func (gid GoogleID) portalNotes(p *RegistryPortal) error {
+	rows, err := db.Query("SELECT n.teamID, n.gid, n.comment, n.hardness, n.updated FROM portalnotes=n, agentteams=x WHERE n.portalID = ? AND n.teamID = x.teamID AND x.gid = ? AND x.suspended = 0 ORDER BY n.updated DESC", p.ID, gid)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n PortalNote
+		var comment, hardness sql.NullString
+		if err := rows.Scan(&n.TeamID, &n.GID, &comment, &hardness, &n.Updated); err != nil {
+			Log.Error(err)
+			continue
+		}
+		n.Comment = comment.String
+		n.Hardness = hardness.String
+		p.Notes = append(p.Notes, n)
+	}
+	return nil
+}
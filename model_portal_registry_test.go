@@ -0,0 +1,61 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestPortalRegistry(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(j, &in); err != nil {
+		t.Error(err.Error())
+	}
+	if len(in.OpPortals) == 0 {
+		t.Fatal("no portals in test op")
+	}
+	first := in.OpPortals[0]
+
+	p, err := gid.RegistryPortal(first.ID)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if p.Name != first.Name {
+		t.Errorf("registry name mismatch: %s != %s", p.Name, first.Name)
+	}
+
+	found, err := gid.SearchPortals(wasabee.PortalSearch{Name: first.Name})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(found) == 0 {
+		t.Error("portal not found by name")
+	}
+
+	near, err := gid.SearchPortals(wasabee.PortalSearch{Lat: first.Lat, Lon: first.Lon, Radius: 1})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(near) == 0 || near[0].ID != first.ID {
+		t.Error("portal not found by location")
+	}
+
+	if _, err := gid.SearchPortals(wasabee.PortalSearch{}); err == nil {
+		t.Error("empty portal search allowed")
+	}
+
+	if err := in.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
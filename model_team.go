@@ -4,47 +4,59 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"math"
+	"sort"
 	"strconv"
 )
 
 // TeamData is the wrapper type containing all the team info
 type TeamData struct {
-	Name          string  `json:"name"`
-	ID            TeamID  `json:"id"`
-	Agent         []Agent `json:"agents"`
-	RocksComm     string  `json:"rc,omitempty"`
-	RocksKey      string  `json:"rk,omitempty"`
-	JoinLinkToken string  `json:"jlt,omitempty"`
+	Name          string           `json:"name"`
+	ID            TeamID           `json:"id"`
+	Agent         []Agent          `json:"agents"`
+	RocksComm     string           `json:"rc,omitempty"`
+	RocksKey      string           `json:"rk,omitempty"`
+	JoinLinkToken string           `json:"jlt,omitempty"`
+	PendingOwner  *PendingTransfer `json:"pendingOwner,omitempty"`
+	JoinApproval  bool             `json:"joinApproval"`
+	JoinRequests  []JoinRequest    `json:"joinRequests,omitempty"`
+	Policy        TeamPolicy       `json:"policy"`
+	Parent        TeamID           `json:"parent,omitempty"`
+	Children      []TeamID         `json:"children,omitempty"`
 	// telegramChannel int64
 }
 
 // Agent is the light version of AgentData, containing visible information exported to teams
 type Agent struct {
-	Gid           GoogleID `json:"id"`
-	Name          string   `json:"name"`
-	Level         int64    `json:"level"`
-	EnlID         EnlID    `json:"enlid"`
-	PictureURL    string   `json:"pic"`
-	Verified      bool     `json:"Vverified"`
-	Blacklisted   bool     `json:"blacklisted"`
-	RocksVerified bool     `json:"rocks"`
-	Squad         string   `json:"squad"`
-	State         bool     `json:"state"`
-	Lat           float64  `json:"lat"`
-	Lon           float64  `json:"lng"`
-	Date          string   `json:"date"`
-	Distance      float64  `json:"distance,omitempty"`
-	DisplayName   string   `json:"displayname,omitempty"`
-	CanSendTo     bool     `json:"cansendto,omitempty"`
-	ShareWD       bool     `json:"shareWD`
-	LoadWD        bool     `json:"loadWD`
+	Gid           GoogleID          `json:"id"`
+	Name          string            `json:"name"`
+	Level         int64             `json:"level"`
+	EnlID         EnlID             `json:"enlid"`
+	PictureURL    string            `json:"pic"`
+	Verified      bool              `json:"Vverified"`
+	Blacklisted   bool              `json:"blacklisted"`
+	RocksVerified bool              `json:"rocks"`
+	Squad         string            `json:"squad"`
+	State         bool              `json:"state"`
+	Lat           float64           `json:"lat"`
+	Lon           float64           `json:"lng"`
+	Date          string            `json:"date"`
+	Distance      float64           `json:"distance,omitempty"`
+	DisplayName   string            `json:"displayname,omitempty"`
+	CanSendTo     bool              `json:"cansendto,omitempty"`
+	ShareWD       bool              `json:"shareWD`
+	LoadWD        bool              `json:"loadWD`
+	Role          TeamRole          `json:"role,omitempty"`
+	Suspended     bool              `json:"suspended,omitempty"`
+	Precision     LocationPrecision `json:"precision,omitempty"`
+	Statuses      []AgentStatus     `json:"statuses,omitempty"`
 }
 
-// AgentInTeam checks to see if a agent is in a team and enabled.
+// AgentInTeam checks to see if a agent is in a team and not suspended by the team's policy.
 func (gid GoogleID) AgentInTeam(team TeamID) (bool, error) {
 	var count string
 
-	err := db.QueryRow("SELECT COUNT(*) FROM agentteams WHERE teamID = ? AND gid = ?", team, gid).Scan(&count)
+	err := db.QueryRow("SELECT COUNT(*) FROM agentteams WHERE teamID = ? AND gid = ? AND suspended = 0", team, gid).Scan(&count)
 	if err != nil {
 		return false, err
 	}
@@ -58,7 +70,7 @@ func (gid GoogleID) AgentInTeam(team TeamID) (bool, error) {
 // FetchTeam populates an entire TeamData struct
 func (teamID TeamID) FetchTeam(teamList *TeamData) error {
 	var rows *sql.Rows
-	rows, err := db.Query("SELECT u.gid, u.iname, x.color, x.state, Y(l.loc), X(l.loc), l.upTime, u.VVerified, u.VBlacklisted, u.Vid, x.displayname, sharewd, loadwd "+
+	rows, err := db.Query("SELECT u.gid, u.iname, x.color, x.state, Y(l.loc), X(l.loc), l.upTime, u.VVerified, u.VBlacklisted, u.Vid, x.displayname, sharewd, loadwd, x.role, t.owner, x.suspended, x.locprecision, "+sharingWindowOpen+" "+
 		"FROM team=t, agentteams=x, agent=u, locations=l "+
 		"WHERE t.teamID = ? AND t.teamID = x.teamID AND x.gid = u.gid AND x.gid = l.gid ORDER BY u.iname", teamID)
 	if err != nil {
@@ -71,17 +83,24 @@ func (teamID TeamID) FetchTeam(teamList *TeamData) error {
 		var tmpU Agent
 		var state, lat, lon, sharewd, loadwd string
 		var enlID, dn sql.NullString
+		var owner GoogleID
+		var windowOpen bool
 
 		err := rows.Scan(&tmpU.Gid, &tmpU.Name, &tmpU.Squad, &state, &lat, &lon, &tmpU.Date, &tmpU.Verified,
-			&tmpU.Blacklisted, &enlID, &dn, &sharewd, &loadwd)
+			&tmpU.Blacklisted, &enlID, &dn, &sharewd, &loadwd, &tmpU.Role, &owner, &tmpU.Suspended, &tmpU.Precision, &windowOpen)
 		if err != nil {
 			Log.Error(err)
 			return err
 		}
-		if state == "On" {
+		if tmpU.Gid == owner {
+			tmpU.Role = TeamRoleOwner
+		}
+		// observers see the team but do not share their own location
+		if state == "On" && windowOpen && tmpU.Role != TeamRoleObserver {
 			tmpU.State = true
 			tmpU.Lat, _ = strconv.ParseFloat(lat, 64)
 			tmpU.Lon, _ = strconv.ParseFloat(lon, 64)
+			tmpU.Lat, tmpU.Lon = tmpU.Precision.Blur(tmpU.Lat, tmpU.Lon)
 		} else {
 			tmpU.State = false
 			tmpU.Lat = 0
@@ -112,12 +131,29 @@ func (teamID TeamID) FetchTeam(teamList *TeamData) error {
 		teamList.Agent = append(teamList.Agent, tmpU)
 	}
 
-	var rockscomm, rockskey, joinlinktoken sql.NullString
-	if err := db.QueryRow("SELECT name, rockscomm, rockskey, joinLinkToken FROM team WHERE teamID = ?", teamID).Scan(&teamList.Name, &rockscomm, &rockskey, &joinlinktoken); err != nil {
+	// statuses on the ops shared with this team
+	statuses, err := teamID.teamStatuses()
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		for i := range teamList.Agent {
+			if teamList.Agent[i].Gid == s.Gid {
+				teamList.Agent[i].Statuses = append(teamList.Agent[i].Statuses, s)
+			}
+		}
+	}
+
+	var rockscomm, rockskey, joinlinktoken, parent sql.NullString
+	if err := db.QueryRow("SELECT name, rockscomm, rockskey, joinLinkToken, joinapproval, parent FROM team WHERE teamID = ?", teamID).Scan(&teamList.Name, &rockscomm, &rockskey, &joinlinktoken, &teamList.JoinApproval, &parent); err != nil {
 		Log.Error(err)
 		return err
 	}
 	teamList.ID = teamID
+	teamList.Parent = TeamID(parent.String)
+	if children, err := teamID.Children(); err == nil && len(children) > 0 {
+		teamList.Children = children
+	}
 	if rockscomm.Valid {
 		teamList.RocksComm = rockscomm.String
 	}
@@ -127,6 +163,16 @@ func (teamID TeamID) FetchTeam(teamList *TeamData) error {
 	if joinlinktoken.Valid {
 		teamList.JoinLinkToken = joinlinktoken.String
 	}
+	if teamList.PendingOwner, err = teamID.PendingChown(); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if teamList.JoinRequests, err = teamID.JoinRequests(); err != nil {
+		return err
+	}
+	if teamList.Policy, err = teamID.Policy(); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -169,7 +215,12 @@ func (gid GoogleID) NewTeam(name string) (TeamID, error) {
 		Log.Error(err)
 		return "", err
 	}
-	_, err = db.Exec("INSERT INTO agentteams (teamID, gid, state, color, displayname, shareWD, loadWD) VALUES (?,?,'On','operator',NULL, 'Off', 'Off')", team, gid)
+	_, err = db.Exec("INSERT INTO teamtree (ancestor, descendant) VALUES (?,?)", team, team)
+	if err != nil {
+		Log.Error(err)
+		return TeamID(team), err
+	}
+	_, err = db.Exec("INSERT INTO agentteams (teamID, gid, state, color, displayname, shareWD, loadWD, sharesince) VALUES (?,?,'On','operator',NULL, 'Off', 'Off', UTC_TIMESTAMP())", team, gid)
 	if err != nil {
 		Log.Error(err)
 		return TeamID(team), err
@@ -212,6 +263,17 @@ func (teamID TeamID) Delete() error {
 		}
 	}
 
+	// sub-teams become top-level teams rather than keeping the deleted team's ancestors
+	children, err := teamID.Children()
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := child.setParent(""); err != nil {
+			return err
+		}
+	}
+
 	_, err = db.Exec("DELETE FROM opteams WHERE teamID = ?", teamID)
 	if err != nil {
 		Log.Error(err)
@@ -225,7 +287,7 @@ func (teamID TeamID) Delete() error {
 	return nil
 }
 
-// AddAgent adds a agent to a team
+// AddAgent adds a agent to a team, refusing agents who do not meet the team's policy
 func (teamID TeamID) AddAgent(in AgentID) error {
 	gid, err := in.Gid()
 	if err != nil {
@@ -233,6 +295,11 @@ func (teamID TeamID) AddAgent(in AgentID) error {
 		return err
 	}
 
+	if err := teamID.checkPolicy(gid); err != nil {
+		Log.Infow("agent refused by team policy", "GID", gid, "resource", teamID, "reason", err.Error())
+		return err
+	}
+
 	_, err = db.Exec("INSERT IGNORE INTO agentteams (teamID, gid, state, color, displayname, shareWD, loadWD) VALUES (?, ?, 'Off', '', NULL, 'Off', 'Off')", teamID, gid)
 	if err != nil {
 		Log.Error(err)
@@ -293,27 +360,9 @@ func (teamID TeamID) RemoveAgent(in AgentID) error {
 	return nil
 }
 
-// Chown changes a team's ownership
-// caller must verify permissions
-func (teamID TeamID) Chown(to AgentID) error {
-	gid, err := to.Gid()
-	if err != nil {
-		Log.Error(err)
-		return err
-	}
-
-	_, err = db.Exec("UPDATE team SET owner = ? WHERE teamID = ?", gid, teamID)
-	if err != nil {
-		Log.Error(err)
-		return (err)
-	}
-	return nil
-}
-
 // TeammatesNear identifies other agents who are on ANY mutual team within maxdistance km, returning at most maxresults
 func (gid GoogleID) TeammatesNear(maxdistance, maxresults int, teamList *TeamData) error {
-	var state, lat, lon string
-	var tmpU Agent
+	var lat, lon string
 	var rows *sql.Rows
 
 	err := db.QueryRow("SELECT Y(loc), X(loc) FROM locations WHERE gid = ?", gid).Scan(&lat, &lon)
@@ -321,35 +370,47 @@ func (gid GoogleID) TeammatesNear(maxdistance, maxresults int, teamList *TeamDat
 		Log.Error(err)
 		return err
 	}
-	// Log.Debug("Teammates Near: " + gid.String() + " @ " + lat.String + "," + lon.String + " " + strconv.Itoa(maxdistance) + " " + strconv.Itoa(maxresults))
 
-	// no ST_Distance_Sphere in MariaDB yet...
-	rows, err = db.Query("SELECT DISTINCT u.iname, x.color, x.state, Y(l.loc), X(l.loc), l.upTime, u.VVerified, u.VBlacklisted, "+
-		"ROUND(6371 * acos (cos(radians(?)) * cos(radians(Y(l.loc))) * cos(radians(X(l.loc)) - radians(?)) + sin(radians(?)) * sin(radians(Y(l.loc))))) AS distance "+
+	// distances are measured to the location as each agent shares it, so they cannot be used to find an exact position
+	rows, err = db.Query("SELECT u.gid, u.iname, x.color, x.locprecision, Y(l.loc), X(l.loc), l.upTime, u.VVerified, u.VBlacklisted "+
 		"FROM agentteams=x, agent=u, locations=l "+
 		"WHERE x.teamID IN (SELECT teamID FROM agentteams WHERE gid = ? AND state = 'On') "+
-		"AND x.state = 'On' AND x.gid = u.gid AND x.gid = l.gid AND l.upTime > SUBTIME(UTC_TIMESTAMP(), '12:00:00') "+
-		"HAVING distance < ? AND distance > 0 ORDER BY distance LIMIT 0,?", lat, lon, lat, gid, maxdistance, maxresults)
+		"AND "+locationShared+" AND x.gid != ? AND x.gid = u.gid AND x.gid = l.gid AND l.upTime > SUBTIME(UTC_TIMESTAMP(), '12:00:00')", gid, gid)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
 
+	// an agent on several mutual teams is shown at the finest precision they share with any of them
+	near := make(map[GoogleID]Agent)
 	defer rows.Close()
 	for rows.Next() {
-		err := rows.Scan(&tmpU.Name, &tmpU.Squad, &state, &lat, &lon, &tmpU.Date, &tmpU.Verified, &tmpU.Blacklisted, &tmpU.Distance)
+		var tmpU Agent
+		var alat, alon string
+		err := rows.Scan(&tmpU.Gid, &tmpU.Name, &tmpU.Squad, &tmpU.Precision, &alat, &alon, &tmpU.Date, &tmpU.Verified, &tmpU.Blacklisted)
 		if err != nil {
 			Log.Error(err)
 			return err
 		}
-		if state == "On" {
-			tmpU.State = true
-		} else {
-			tmpU.State = false
+		if a, ok := near[tmpU.Gid]; ok && a.Precision <= tmpU.Precision {
+			continue
+		}
+		tmpU.State = true
+		tmpU.Lat, _ = strconv.ParseFloat(alat, 64)
+		tmpU.Lon, _ = strconv.ParseFloat(alon, 64)
+		tmpU.Lat, tmpU.Lon = tmpU.Precision.Blur(tmpU.Lat, tmpU.Lon)
+		near[tmpU.Gid] = tmpU
+	}
+
+	for _, a := range near {
+		a.Distance = math.Round(Distance(lat, lon, strconv.FormatFloat(a.Lat, 'f', 7, 64), strconv.FormatFloat(a.Lon, 'f', 7, 64)) / 1000)
+		if a.Distance > 0 && a.Distance < float64(maxdistance) {
+			teamList.Agent = append(teamList.Agent, a)
 		}
-		tmpU.Lat, _ = strconv.ParseFloat(lat, 64)
-		tmpU.Lon, _ = strconv.ParseFloat(lon, 64)
-		teamList.Agent = append(teamList.Agent, tmpU)
+	}
+	sort.Slice(teamList.Agent, func(i, j int) bool { return teamList.Agent[i].Distance < teamList.Agent[j].Distance })
+	if len(teamList.Agent) > maxresults {
+		teamList.Agent = teamList.Agent[:maxresults]
 	}
 	return nil
 }
@@ -376,7 +437,9 @@ func (gid GoogleID) SetTeamState(teamID TeamID, state string) error {
 		state = "Off"
 	}
 
-	if _, err := db.Exec("UPDATE agentteams SET state = ? WHERE gid = ? AND teamID = ?", state, gid, teamID); err != nil {
+	// suspended agents may only be switched off
+	// an explicit toggle replaces any sharing window
+	if _, err := db.Exec("UPDATE agentteams SET "+shareSince+", state = ?, shareuntil = NULL, shareop = NULL WHERE gid = ? AND teamID = ? AND (suspended = 0 OR ? = 'Off')", state, gid, teamID, state); err != nil {
 		Log.Error(err)
 		return err
 	}
@@ -441,12 +504,12 @@ func (teamID TeamID) Name() (string, error) {
 	return name, nil
 }
 
-// teamList is used for getting a list of all an agent's teams
+// teamList is used for getting a list of all an agent's teams, except those the agent is suspended from
 func (gid GoogleID) teamList() []TeamID {
 	var tid TeamID
 	var x []TeamID
 
-	rows, err := db.Query("SELECT teamID FROM agentteams WHERE gid = ?", gid)
+	rows, err := db.Query("SELECT teamID FROM agentteams WHERE gid = ? AND suspended = 0", gid)
 	if err != nil {
 		Log.Error(err)
 		return x
@@ -509,7 +572,7 @@ func (teamID TeamID) DeleteJoinToken() error {
 	return nil
 }
 
-// JoinToken verifies a join link
+// JoinToken verifies a join link, adding the agent or filing a join request if the team requires approval
 func (teamID TeamID) JoinToken(gid GoogleID, key string) error {
 	var count string
 
@@ -522,22 +585,34 @@ func (teamID TeamID) JoinToken(gid GoogleID, key string) error {
 	if err != nil {
 		return err
 	}
-	if i != 1 {
-		err = fmt.Errorf("invalid team join token")
-		Log.Errorw(err.Error(), "resource", teamID, "GID", gid)
+
+	// refuse before using up a link or filing a request
+	if err := teamID.checkPolicy(gid); err != nil {
+		Log.Infow("agent refused by team policy", "GID", gid, "resource", teamID, "reason", err.Error())
 		return err
 	}
 
-	err = teamID.AddAgent(gid)
-	if err != nil {
-		return err
+	var token string
+	if i != 1 {
+		// not the team's permanent token, try the expiring links
+		link, err := teamID.useJoinLink(gid, key)
+		if err != nil {
+			return err
+		}
+		token = link.Token
 	}
-	err = teamID.SetSquad(gid, "joined via link")
+
+	approval, err := teamID.JoinApproval()
 	if err != nil {
 		return err
 	}
+	if approval {
+		// the link's squad and start state are applied when the request is approved
+		return teamID.requestJoin(gid, token)
+	}
 
-	return nil
+	squad, on := teamID.joinLinkSettings(token)
+	return teamID.joinViaLink(gid, squad, on)
 }
 
 // LinkToTelegramChat associates a telegram chat ID with the team, performs authorization
@@ -619,12 +694,15 @@ func (gid GoogleID) GetAgentLocations() (string, error) {
 	var list []loc
 	var tmpL loc
 	var lat, lon string
+	var precision LocationPrecision
+	seen := make(map[GoogleID]bool)
 
+	// finest precision first, an agent on several mutual teams is shown as exactly as any of them allows
 	var rows *sql.Rows
-	rows, err := db.Query("SELECT x.gid, Y(l.loc), X(l.loc), l.upTime "+
+	rows, err := db.Query("SELECT x.gid, x.locprecision, Y(l.loc), X(l.loc), l.upTime "+
 		"FROM agentteams=x, locations=l "+
-		"WHERE x.teamID IN (SELECT teamID FROM agentteams WHERE gid = ?) "+
-		"AND x.state = 'On' AND x.gid = l.gid", gid)
+		"WHERE x.teamID IN (SELECT teamID FROM agentteams WHERE gid = ? AND suspended = 0) "+
+		"AND "+locationShared+" AND x.gid = l.gid ORDER BY x.locprecision", gid)
 	if err != nil {
 		Log.Error(err)
 		return "", err
@@ -632,16 +710,21 @@ func (gid GoogleID) GetAgentLocations() (string, error) {
 
 	defer rows.Close()
 	for rows.Next() {
-		if err := rows.Scan(&tmpL.Gid, &lat, &lon, &tmpL.Date); err != nil {
+		if err := rows.Scan(&tmpL.Gid, &precision, &lat, &lon, &tmpL.Date); err != nil {
 			Log.Error(err)
 			return "", err
 		}
+		if seen[tmpL.Gid] {
+			continue
+		}
 		tmpL.Lat, _ = strconv.ParseFloat(lat, 64)
 		tmpL.Lon, _ = strconv.ParseFloat(lon, 64)
 
 		if tmpL.Lat == 0 || tmpL.Lon == 0 {
 			continue
 		}
+		seen[tmpL.Gid] = true
+		tmpL.Lat, tmpL.Lon = precision.Blur(tmpL.Lat, tmpL.Lon)
 
 		list = append(list, tmpL)
 	}
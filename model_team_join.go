@@ -0,0 +1,210 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// JoinRequest is an agent waiting for a team owner or admin to approve their use of the team's join link
+type JoinRequest struct {
+	ID        string `json:"ID"`
+	TeamID    TeamID `json:"teamID"`
+	TeamName  string `json:"teamName"`
+	Agent     Agent  `json:"agent"` // carries the applicant's V and .rocks verification status
+	Requested string `json:"requested"`
+}
+
+// SetJoinApproval toggles whether the team's join link adds agents directly or files a request for approval
+// does not check team ownership -- caller should take care of authorization
+func (teamID TeamID) SetJoinApproval(required bool) error {
+	if _, err := db.Exec("UPDATE team SET joinapproval = ? WHERE teamID = ?", required, teamID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// JoinApproval reports whether the team requires join link requests to be approved
+func (teamID TeamID) JoinApproval() (bool, error) {
+	var required bool
+	if err := db.QueryRow("SELECT joinapproval FROM team WHERE teamID = ?", teamID).Scan(&required); err != nil {
+		Log.Error(err)
+		return false, err
+	}
+	return required, nil
+}
+
+// requestJoin files a pending join request and tells the team's owner and admins, token is the join link used, if any
+func (teamID TeamID) requestJoin(gid GoogleID, token string) error {
+	if inteam, _ := gid.AgentInTeam(teamID); inteam {
+		return nil
+	}
+
+	result, err := db.Exec("INSERT IGNORE INTO joinrequest (ID, teamID, gid, token) VALUES (?, ?, ?, ?)", GenerateID(16), teamID, gid, MakeNullString(token))
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	// already waiting, do not notify again
+	if ra, _ := result.RowsAffected(); ra != 1 {
+		return nil
+	}
+
+	name, err := teamID.Name()
+	if err != nil {
+		return err
+	}
+	iname, _ := gid.IngressName()
+	msg := fmt.Sprintf("%s asked to join %s. Approve or deny the request in Wasabee or with /joinrequests in Telegram.", iname, name)
+	for _, m := range teamID.managers() {
+		if _, err := m.SendMessage(msg); err != nil {
+			Log.Error(err)
+		}
+		m.FirebaseGenericMessage(msg)
+	}
+
+	if _, err := gid.SendMessage(fmt.Sprintf("your request to join %s is waiting for approval", name)); err != nil {
+		Log.Error(err)
+	}
+	Log.Infow("join request", "GID", gid, "resource", teamID)
+	return nil
+}
+
+// managers lists the owner and admins of a team
+func (teamID TeamID) managers() []GoogleID {
+	var list []GoogleID
+
+	rows, err := db.Query("SELECT owner FROM team WHERE teamID = ? UNION SELECT gid FROM agentteams WHERE teamID = ? AND role = 'admin' AND suspended = 0", teamID, teamID)
+	if err != nil {
+		Log.Error(err)
+		return list
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var gid GoogleID
+		if err := rows.Scan(&gid); err != nil {
+			Log.Error(err)
+			continue
+		}
+		list = append(list, gid)
+	}
+	return list
+}
+
+const joinRequestSelect = "SELECT r.ID, r.teamID, t.name, r.requested, u.gid, u.iname, u.level, u.VVerified, u.VBlacklisted, u.Vid, u.RocksVerified " +
+	"FROM joinrequest=r, team=t, agent=u WHERE r.teamID = t.teamID AND r.gid = u.gid "
+
+func scanJoinRequests(rows *sql.Rows) []JoinRequest {
+	list := make([]JoinRequest, 0)
+
+	defer rows.Close()
+	for rows.Next() {
+		var r JoinRequest
+		var name, vid sql.NullString
+		if err := rows.Scan(&r.ID, &r.TeamID, &name, &r.Requested, &r.Agent.Gid, &r.Agent.Name, &r.Agent.Level,
+			&r.Agent.Verified, &r.Agent.Blacklisted, &vid, &r.Agent.RocksVerified); err != nil {
+			Log.Error(err)
+			continue
+		}
+		r.TeamName = name.String
+		if vid.Valid {
+			r.Agent.EnlID = EnlID(vid.String)
+		}
+		r.Agent.PictureURL = r.Agent.Gid.GetPicture()
+		list = append(list, r)
+	}
+	return list
+}
+
+// JoinRequests lists the pending join requests for a team
+// does not check team ownership -- caller should take care of authorization
+func (teamID TeamID) JoinRequests() ([]JoinRequest, error) {
+	rows, err := db.Query(joinRequestSelect+"AND r.teamID = ? ORDER BY r.requested", teamID)
+	if err != nil {
+		Log.Error(err)
+		return make([]JoinRequest, 0), err
+	}
+	return scanJoinRequests(rows), nil
+}
+
+// ManagedJoinRequests lists the pending join requests for every team the agent owns or is an admin of
+func (gid GoogleID) ManagedJoinRequests() ([]JoinRequest, error) {
+	rows, err := db.Query(joinRequestSelect+"AND (t.owner = ? OR EXISTS (SELECT 1 FROM agentteams=a WHERE a.teamID = r.teamID AND a.gid = ? AND a.role = 'admin' AND a.suspended = 0)) ORDER BY r.requested", gid, gid)
+	if err != nil {
+		Log.Error(err)
+		return make([]JoinRequest, 0), err
+	}
+	return scanJoinRequests(rows), nil
+}
+
+// ApproveJoinRequest adds the applicant to the team, only the team's owner or admins may approve
+func (gid GoogleID) ApproveJoinRequest(requestID string) error {
+	return gid.resolveJoinRequest(requestID, true)
+}
+
+// DenyJoinRequest discards the request, only the team's owner or admins may deny
+func (gid GoogleID) DenyJoinRequest(requestID string) error {
+	return gid.resolveJoinRequest(requestID, false)
+}
+
+func (gid GoogleID) resolveJoinRequest(requestID string, approve bool) error {
+	var teamID TeamID
+	var applicant GoogleID
+	var token sql.NullString
+	err := db.QueryRow("SELECT teamID, gid, token FROM joinrequest WHERE ID = ?", requestID).Scan(&teamID, &applicant, &token)
+	if err == sql.ErrNoRows {
+		err := fmt.Errorf("no such join request")
+		Log.Infow(err.Error(), "GID", gid, "request", requestID)
+		return err
+	}
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	if manages, _ := gid.CanManageTeam(teamID); !manages {
+		err := fmt.Errorf("permission denied")
+		Log.Warnw(err.Error(), "GID", gid, "resource", teamID, "request", requestID)
+		return err
+	}
+
+	name, _ := teamID.Name()
+	var msg string
+	if approve {
+		// the team's policy may have changed, or the applicant's status, since the request was made
+		if err := teamID.checkPolicy(applicant); err != nil {
+			Log.Infow("join request refused by team policy", "GID", gid, "resource", teamID, "applicant", applicant, "reason", err.Error())
+			msg = fmt.Sprintf("your request to join %s could not be approved: %s", name, err.Error())
+			if _, err := applicant.SendMessage(msg); err != nil {
+				Log.Error(err)
+			}
+			applicant.FirebaseGenericMessage(msg)
+			return err
+		}
+		squad, on := teamID.joinLinkSettings(token.String)
+		if err := teamID.joinViaLink(applicant, squad, on); err != nil {
+			return err
+		}
+		msg = fmt.Sprintf("your request to join %s was approved", name)
+	} else {
+		msg = fmt.Sprintf("your request to join %s was denied", name)
+	}
+
+	// only once the applicant is on the team, so a failed approval can be retried
+	if _, err := db.Exec("DELETE FROM joinrequest WHERE ID = ?", requestID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	// a denied applicant does not use up a limited link
+	if !approve {
+		teamID.returnJoinLinkUse(applicant, token.String)
+	}
+	if _, err := applicant.SendMessage(msg); err != nil {
+		Log.Error(err)
+	}
+	applicant.FirebaseGenericMessage(msg)
+
+	Log.Infow("join request resolved", "GID", gid, "resource", teamID, "applicant", applicant, "approved", approve)
+	return nil
+}
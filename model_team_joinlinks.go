@@ -0,0 +1,256 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// JoinLink is one of a team's expiring, limited-use join links
+type JoinLink struct {
+	Token   string        `json:"token"`
+	Creator GoogleID      `json:"creator"`
+	Created string        `json:"created"`
+	Expires string        `json:"expires,omitempty"`
+	MaxUses int           `json:"maxUses"` // 0 for unlimited
+	Uses    int           `json:"uses"`
+	Squad   string        `json:"squad,omitempty"` // squad set on agents joining through the link
+	On      bool          `json:"on"`              // agents joining through the link start out sharing their location with the team
+	Joined  []JoinLinkUse `json:"joined"`
+}
+
+// JoinLinkUse records an agent who joined through a link
+type JoinLinkUse struct {
+	Gid    GoogleID `json:"gid"`
+	Name   string   `json:"name"`
+	Joined string   `json:"joined"`
+}
+
+// NewJoinLink creates a join link for the team; a zero duration never expires and zero uses is unlimited
+// does not check team ownership -- caller should take care of authorization
+func (teamID TeamID) NewJoinLink(gid GoogleID, duration time.Duration, maxUses int, squad string, on bool) (JoinLink, error) {
+	var l JoinLink
+
+	if duration < 0 || maxUses < 0 {
+		err := fmt.Errorf("invalid join link limits")
+		Log.Warnw(err.Error(), "GID", gid, "resource", teamID)
+		return l, err
+	}
+
+	token, err := GenerateSafeName()
+	if err != nil {
+		Log.Error(err)
+		return l, err
+	}
+
+	var expires sql.NullString
+	now := time.Now().UTC()
+	if duration > 0 {
+		expires.Valid = true
+		expires.String = now.Add(duration).Format("2006-01-02 15:04:05")
+	}
+
+	_, err = db.Exec("INSERT INTO joinlink (token, teamID, gid, created, expires, maxuses, squad, starton) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		token, teamID, gid, now.Format("2006-01-02 15:04:05"), expires, maxUses, MakeNullString(squad), on)
+	if err != nil {
+		Log.Error(err)
+		return l, err
+	}
+
+	l.Token = token
+	l.Creator = gid
+	l.Created = now.Format("2006-01-02 15:04:05")
+	l.Expires = expires.String
+	l.MaxUses = maxUses
+	l.Squad = squad
+	l.On = on
+	l.Joined = make([]JoinLinkUse, 0)
+	Log.Infow("join link created", "GID", gid, "resource", teamID, "expires", l.Expires, "maxUses", maxUses)
+	return l, nil
+}
+
+// JoinLinks lists the team's join links and who joined through each
+// does not check team ownership -- caller should take care of authorization
+func (teamID TeamID) JoinLinks() ([]JoinLink, error) {
+	links := make([]JoinLink, 0)
+
+	rows, err := db.Query("SELECT token, gid, created, expires, maxuses, uses, squad, starton FROM joinlink WHERE teamID = ? ORDER BY created", teamID)
+	if err != nil {
+		Log.Error(err)
+		return links, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l JoinLink
+		var expires, squad sql.NullString
+		if err := rows.Scan(&l.Token, &l.Creator, &l.Created, &expires, &l.MaxUses, &l.Uses, &squad, &l.On); err != nil {
+			Log.Error(err)
+			continue
+		}
+		l.Expires = expires.String
+		l.Squad = squad.String
+		links = append(links, l)
+	}
+
+	for i := range links {
+		if links[i].Joined, err = joinLinkUses(links[i].Token); err != nil {
+			return links, err
+		}
+	}
+	return links, nil
+}
+
+func joinLinkUses(token string) ([]JoinLinkUse, error) {
+	uses := make([]JoinLinkUse, 0)
+
+	rows, err := db.Query("SELECT l.gid, a.iname, l.joined FROM joinlinklog=l, agent=a WHERE l.token = ? AND l.gid = a.gid ORDER BY l.joined", token)
+	if err != nil {
+		Log.Error(err)
+		return uses, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u JoinLinkUse
+		if err := rows.Scan(&u.Gid, &u.Name, &u.Joined); err != nil {
+			Log.Error(err)
+			continue
+		}
+		uses = append(uses, u)
+	}
+	return uses, nil
+}
+
+// RevokeJoinLink removes one of the team's join links
+// does not check team ownership -- caller should take care of authorization
+func (teamID TeamID) RevokeJoinLink(token string) error {
+	result, err := db.Exec("DELETE FROM joinlink WHERE teamID = ? AND token = ?", teamID, token)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if ra, _ := result.RowsAffected(); ra != 1 {
+		err := fmt.Errorf("no such join link")
+		Log.Infow(err.Error(), "resource", teamID, "token", token)
+		return err
+	}
+	Log.Infow("join link revoked", "resource", teamID, "token", token)
+	return nil
+}
+
+// useJoinLink verifies a link is unexpired and has uses left, counting the use and recording the agent.
+// A use counted for a join request is held until the request is resolved, see returnJoinLinkUse.
+func (teamID TeamID) useJoinLink(gid GoogleID, token string) (JoinLink, error) {
+	var l JoinLink
+	var squad sql.NullString
+
+	err := db.QueryRow("SELECT token, squad, starton FROM joinlink WHERE teamID = ? AND token = ?", teamID, token).Scan(&l.Token, &squad, &l.On)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("invalid team join token")
+		Log.Errorw(err.Error(), "resource", teamID, "GID", gid)
+		return l, err
+	}
+	if err != nil {
+		Log.Error(err)
+		return l, err
+	}
+	l.Squad = squad.String
+
+	// agents already on the team, or already waiting for approval, do not use up the link
+	if inteam, _ := gid.AgentInTeam(teamID); inteam {
+		return l, nil
+	}
+	var waiting int
+	if err := db.QueryRow("SELECT COUNT(*) FROM joinrequest WHERE teamID = ? AND gid = ?", teamID, gid).Scan(&waiting); err != nil {
+		Log.Error(err)
+		return l, err
+	}
+	if waiting > 0 {
+		return l, nil
+	}
+
+	result, err := db.Exec("UPDATE joinlink SET uses = uses + 1 WHERE token = ? AND (maxuses = 0 OR uses < maxuses) AND (expires IS NULL OR expires > UTC_TIMESTAMP())", token)
+	if err != nil {
+		Log.Error(err)
+		return l, err
+	}
+	if ra, _ := result.RowsAffected(); ra != 1 {
+		err = fmt.Errorf("expired or used up team join token")
+		Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+		return l, err
+	}
+
+	if _, err := db.Exec("INSERT IGNORE INTO joinlinklog (token, gid) VALUES (?, ?)", token, gid); err != nil {
+		Log.Error(err)
+		return l, err
+	}
+	return l, nil
+}
+
+// returnJoinLinkUse gives back the use counted when a join request which was then denied was made with the link
+func (teamID TeamID) returnJoinLinkUse(gid GoogleID, token string) {
+	if token == "" {
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM joinlinklog WHERE token = ? AND gid = ?", token, gid)
+	if err != nil {
+		Log.Error(err)
+		return
+	}
+	if ra, _ := result.RowsAffected(); ra != 1 {
+		return
+	}
+	if _, err := db.Exec("UPDATE joinlink SET uses = uses - 1 WHERE teamID = ? AND token = ? AND uses > 0", teamID, token); err != nil {
+		Log.Error(err)
+	}
+}
+
+// joinLinkSettings returns the squad and start state of a link, the defaults if the link is gone or token is empty
+func (teamID TeamID) joinLinkSettings(token string) (string, bool) {
+	squad := "joined via link"
+	var on bool
+	if token == "" {
+		return squad, on
+	}
+
+	var s sql.NullString
+	err := db.QueryRow("SELECT squad, starton FROM joinlink WHERE teamID = ? AND token = ?", teamID, token).Scan(&s, &on)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			Log.Error(err)
+		}
+		return squad, false
+	}
+	if s.String != "" {
+		squad = s.String
+	}
+	return squad, on
+}
+
+// joinViaLink adds an agent who used a join link, new members get the link's squad and start state
+func (teamID TeamID) joinViaLink(gid GoogleID, squad string, on bool) error {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM agentteams WHERE teamID = ? AND gid = ?", teamID, gid).Scan(&count); err != nil {
+		Log.Error(err)
+		return err
+	}
+	// existing members keep their squad and location sharing choice
+	if count > 0 {
+		return nil
+	}
+
+	if err := teamID.AddAgent(gid); err != nil {
+		return err
+	}
+	if err := teamID.SetSquad(gid, squad); err != nil {
+		return err
+	}
+	if on {
+		if err := gid.SetTeamState(teamID, "On"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
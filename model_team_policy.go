@@ -0,0 +1,177 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// TeamPolicy sets the verification an agent needs to join or remain on a team
+type TeamPolicy struct {
+	RequireV          bool `json:"requireV"`          // agent must be verified at V
+	RequireRocks      bool `json:"requireRocks"`      // agent must be verified at enl.rocks
+	RefuseBlacklisted bool `json:"refuseBlacklisted"` // agent must not be blacklisted at V
+	MinLevel          int  `json:"minLevel"`          // 0 for any level
+}
+
+// active reports whether the policy restricts membership at all
+func (p TeamPolicy) active() bool {
+	return p.RequireV || p.RequireRocks || p.RefuseBlacklisted || p.MinLevel > 0
+}
+
+// Policy returns the team's membership policy
+func (teamID TeamID) Policy() (TeamPolicy, error) {
+	var p TeamPolicy
+	err := db.QueryRow("SELECT requirev, requirerocks, refuseblacklisted, minlevel FROM team WHERE teamID = ?", teamID).Scan(&p.RequireV, &p.RequireRocks, &p.RefuseBlacklisted, &p.MinLevel)
+	if err != nil {
+		Log.Error(err)
+		return p, err
+	}
+	return p, nil
+}
+
+// SetPolicy sets the team's membership policy and suspends current members who do not meet it
+// does not check team ownership -- caller should take care of authorization
+func (teamID TeamID) SetPolicy(p TeamPolicy) error {
+	if p.MinLevel < 0 || p.MinLevel > 16 {
+		err := fmt.Errorf("invalid minimum level: %d", p.MinLevel)
+		Log.Warnw(err.Error(), "resource", teamID)
+		return err
+	}
+
+	if _, err := db.Exec("UPDATE team SET requirev = ?, requirerocks = ?, refuseblacklisted = ?, minlevel = ? WHERE teamID = ?",
+		p.RequireV, p.RequireRocks, p.RefuseBlacklisted, p.MinLevel, teamID); err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	rows, err := db.Query("SELECT x.gid FROM agentteams=x, team=t WHERE x.teamID = ? AND x.teamID = t.teamID AND x.gid != t.owner", teamID)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	defer rows.Close()
+
+	var members []GoogleID
+	for rows.Next() {
+		var gid GoogleID
+		if err := rows.Scan(&gid); err != nil {
+			Log.Error(err)
+			continue
+		}
+		members = append(members, gid)
+	}
+	for _, gid := range members {
+		if err := teamID.enforcePolicy(gid, p); err != nil {
+			return err
+		}
+	}
+	Log.Infow("team policy set", "resource", teamID, "policy", p)
+	return nil
+}
+
+// checkPolicy returns an error describing why the agent does not meet the team's policy, nil if they do
+func (teamID TeamID) checkPolicy(gid GoogleID) error {
+	p, err := teamID.Policy()
+	if err != nil {
+		return err
+	}
+	return p.check(gid)
+}
+
+func (p TeamPolicy) check(gid GoogleID) error {
+	if !p.active() {
+		return nil
+	}
+
+	var vverified, vblacklisted, rocksverified bool
+	var level int
+	err := db.QueryRow("SELECT VVerified, VBlacklisted, RocksVerified, level FROM agent WHERE gid = ?", gid).Scan(&vverified, &vblacklisted, &rocksverified, &level)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("unknown agent")
+	}
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	if p.RefuseBlacklisted && vblacklisted {
+		return fmt.Errorf("team does not accept agents blacklisted at V")
+	}
+	if p.RequireV && !vverified {
+		return fmt.Errorf("team requires V verification")
+	}
+	if p.RequireRocks && !rocksverified {
+		return fmt.Errorf("team requires enl.rocks verification")
+	}
+	if level < p.MinLevel {
+		return fmt.Errorf("team requires level %d", p.MinLevel)
+	}
+	return nil
+}
+
+// enforcePolicy suspends a member who no longer meets the policy, or reinstates one who does again, and tells the owner
+func (teamID TeamID) enforcePolicy(gid GoogleID, p TeamPolicy) error {
+	if owns, _ := gid.OwnsTeam(teamID); owns {
+		return nil
+	}
+
+	reason := p.check(gid)
+	suspend := reason != nil
+
+	result, err := db.Exec("UPDATE agentteams SET suspended = ?, state = IF(?, 'Off', state) WHERE teamID = ? AND gid = ? AND suspended != ?", suspend, suspend, teamID, gid, suspend)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if ra, _ := result.RowsAffected(); ra != 1 {
+		// no change
+		return nil
+	}
+
+	var owner GoogleID
+	var name sql.NullString
+	if err := db.QueryRow("SELECT owner, name FROM team WHERE teamID = ?", teamID).Scan(&owner, &name); err != nil {
+		Log.Error(err)
+		return err
+	}
+	iname, _ := gid.IngressName()
+	var msg string
+	if suspend {
+		msg = fmt.Sprintf("%s was suspended from %s: %s", iname, name.String, reason.Error())
+		Log.Infow("agent suspended from team", "resource", teamID, "GID", gid, "reason", reason.Error())
+	} else {
+		msg = fmt.Sprintf("%s now meets the policy of %s and was reinstated", iname, name.String)
+		Log.Infow("agent reinstated on team", "resource", teamID, "GID", gid)
+	}
+	if _, err := owner.SendMessage(msg); err != nil {
+		Log.Error(err)
+	}
+	owner.FirebaseGenericMessage(msg)
+	return nil
+}
+
+// enforceTeamPolicies re-checks the agent against the policy of every team they are on, called after their V or enl.rocks status is refreshed
+func (gid GoogleID) enforceTeamPolicies() {
+	rows, err := db.Query("SELECT t.teamID, t.requirev, t.requirerocks, t.refuseblacklisted, t.minlevel FROM team=t, agentteams=x WHERE x.gid = ? AND x.teamID = t.teamID AND t.owner != ?", gid, gid)
+	if err != nil {
+		Log.Error(err)
+		return
+	}
+	defer rows.Close()
+
+	policies := make(map[TeamID]TeamPolicy)
+	for rows.Next() {
+		var teamID TeamID
+		var p TeamPolicy
+		if err := rows.Scan(&teamID, &p.RequireV, &p.RequireRocks, &p.RefuseBlacklisted, &p.MinLevel); err != nil {
+			Log.Error(err)
+			continue
+		}
+		policies[teamID] = p
+	}
+	for teamID, p := range policies {
+		if err := teamID.enforcePolicy(gid, p); err != nil {
+			Log.Error(err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// TeamRole is an agent's standing on a team
+type TeamRole string
+
+// The owner is stored on the team itself, the other roles on the agent's team membership
+const (
+	TeamRoleOwner    TeamRole = "owner"
+	TeamRoleAdmin    TeamRole = "admin"
+	TeamRoleMember   TeamRole = "member"
+	TeamRoleObserver TeamRole = "observer" // sees teammates' locations but does not share their own
+)
+
+// Valid reports whether a role may be assigned to a team member, the owner role is only set by transferring the team
+func (r TeamRole) Valid() bool {
+	switch r {
+	case TeamRoleAdmin, TeamRoleMember, TeamRoleObserver:
+		return true
+	}
+	return false
+}
+
+// TeamRole returns the agent's role on a team, an empty role if they are not on it.
+// Owners of a parent team are treated as admins of its sub-teams.
+func (gid GoogleID) TeamRole(teamID TeamID) (TeamRole, error) {
+	var role sql.NullString
+	var owner GoogleID
+
+	err := db.QueryRow("SELECT t.owner, x.role FROM team=t LEFT JOIN agentteams=x ON x.teamID = t.teamID AND x.gid = ? AND x.suspended = 0 WHERE t.teamID = ?", gid, teamID).Scan(&owner, &role)
+	if err == sql.ErrNoRows {
+		Log.Warnw("non-existent team role queried", "resource", teamID, "GID", gid)
+		return "", nil
+	}
+	if err != nil {
+		Log.Error(err)
+		return "", err
+	}
+	if owner == gid {
+		return TeamRoleOwner, nil
+	}
+	// owners of a parent team manage its sub-teams
+	if gid.ownsTeamTree(teamID) {
+		return TeamRoleAdmin, nil
+	}
+	return TeamRole(role.String), nil
+}
+
+// CanManageTeam returns true if the agent is the team's owner or one of its admins.
+// Managers may add and remove members, set squads and display names, handle join links and send announcements.
+func (gid GoogleID) CanManageTeam(teamID TeamID) (bool, error) {
+	role, err := gid.TeamRole(teamID)
+	if err != nil {
+		return false, err
+	}
+	return role == TeamRoleOwner || role == TeamRoleAdmin, nil
+}
+
+// SetRole changes a team member's role
+// does not check team ownership -- caller should take care of authorization
+func (teamID TeamID) SetRole(gid GoogleID, role TeamRole) error {
+	if !role.Valid() {
+		err := fmt.Errorf("invalid team role: %s", role)
+		Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+		return err
+	}
+
+	if owns, _ := gid.OwnsTeam(teamID); owns {
+		err := fmt.Errorf("cannot change the role of the team owner")
+		Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+		return err
+	}
+
+	result, err := db.Exec("UPDATE agentteams SET role = ? WHERE teamID = ? AND gid = ?", role, teamID, gid)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if ra, _ := result.RowsAffected(); ra != 1 {
+		if inteam, _ := gid.AgentInTeam(teamID); !inteam {
+			err := fmt.Errorf("agent not on team")
+			Log.Warnw(err.Error(), "resource", teamID, "GID", gid)
+			return err
+		}
+	}
+	Log.Infow("team role set", "resource", teamID, "GID", gid, "role", role)
+	return nil
+}
@@ -0,0 +1,213 @@
+package wasabee
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// RosterEntry is one agent in a team roster import or export.
+// Imports only need Agent, which may be an agent name, @telegram name, GoogleID or EnlID; Squad and DisplayName are optional.
+type RosterEntry struct {
+	Agent         string   `json:"agent"`
+	Gid           GoogleID `json:"gid,omitempty"`
+	Squad         string   `json:"squad,omitempty"`
+	DisplayName   string   `json:"displayname,omitempty"`
+	EnlID         EnlID    `json:"enlid,omitempty"`
+	Level         int64    `json:"level,omitempty"`
+	Role          TeamRole `json:"role,omitempty"`
+	Verified      bool     `json:"Vverified"`
+	Blacklisted   bool     `json:"blacklisted"`
+	RocksVerified bool     `json:"rocks"`
+	LastSeen      string   `json:"lastSeen,omitempty"`
+}
+
+// RosterImport reports the outcome of a roster import; agents who were already on the team count as Present, not Added
+type RosterImport struct {
+	Added     int               `json:"added"`
+	Present   int               `json:"present"`
+	Unmatched []RosterUnmatched `json:"unmatched"`
+}
+
+// RosterUnmatched is an import row which could not be added to the team
+type RosterUnmatched struct {
+	Row    int    `json:"row"`
+	Agent  string `json:"agent"`
+	Reason string `json:"reason"`
+}
+
+// rosterColumns is the CSV header, import files may use any subset in any order as long as agent is present
+var rosterColumns = []string{"agent", "gid", "squad", "displayname", "enlid", "level", "role", "Vverified", "blacklisted", "rocks", "lastSeen"}
+
+// ImportRoster adds each agent in the roster to the team, setting squads and display names
+// does not check team ownership -- caller should take care of authorization
+func (teamID TeamID) ImportRoster(roster []RosterEntry) RosterImport {
+	result := RosterImport{
+		Unmatched: make([]RosterUnmatched, 0),
+	}
+
+	for i, r := range roster {
+		row := i + 1
+		agent := strings.TrimSpace(r.Agent)
+		if agent == "" {
+			agent = string(r.Gid)
+		}
+
+		// a GoogleID is unambiguous, only fall back to the name lookup without one
+		lookup := agent
+		if r.Gid != "" {
+			lookup = string(r.Gid)
+		}
+		gid, err := ToGid(lookup)
+		if err != nil {
+			result.Unmatched = append(result.Unmatched, RosterUnmatched{Row: row, Agent: agent, Reason: err.Error()})
+			continue
+		}
+
+		// suspended members are still on the team, so check the row rather than AgentInTeam
+		var present bool
+		if err := db.QueryRow("SELECT COUNT(*) > 0 FROM agentteams WHERE teamID = ? AND gid = ?", teamID, gid).Scan(&present); err != nil {
+			Log.Error(err)
+			result.Unmatched = append(result.Unmatched, RosterUnmatched{Row: row, Agent: agent, Reason: err.Error()})
+			continue
+		}
+		if !present {
+			if err := teamID.AddAgent(gid); err != nil {
+				result.Unmatched = append(result.Unmatched, RosterUnmatched{Row: row, Agent: agent, Reason: err.Error()})
+				continue
+			}
+		}
+		if r.Squad != "" {
+			if err := teamID.SetSquad(gid, r.Squad); err != nil {
+				result.Unmatched = append(result.Unmatched, RosterUnmatched{Row: row, Agent: agent, Reason: err.Error()})
+				continue
+			}
+		}
+		if r.DisplayName != "" {
+			if err := teamID.SetDisplayname(gid, r.DisplayName); err != nil {
+				result.Unmatched = append(result.Unmatched, RosterUnmatched{Row: row, Agent: agent, Reason: err.Error()})
+				continue
+			}
+		}
+		if present {
+			result.Present++
+		} else {
+			result.Added++
+		}
+	}
+
+	Log.Infow("team roster imported", "resource", teamID, "added", result.Added, "present", result.Present, "unmatched", len(result.Unmatched))
+	return result
+}
+
+// Roster lists the team's members with squads, verification flags and when their location was last updated
+// does not check team ownership -- caller should take care of authorization
+func (teamID TeamID) Roster() ([]RosterEntry, error) {
+	roster := make([]RosterEntry, 0)
+
+	rows, err := db.Query("SELECT u.gid, u.iname, x.color, x.displayname, u.Vid, u.level, x.role, t.owner, u.VVerified, u.VBlacklisted, u.RocksVerified, l.upTime "+
+		"FROM agentteams=x JOIN agent=u ON x.gid = u.gid JOIN team=t ON x.teamID = t.teamID LEFT JOIN locations=l ON x.gid = l.gid "+
+		"WHERE x.teamID = ? ORDER BY x.color, u.iname", teamID)
+	if err != nil {
+		Log.Error(err)
+		return roster, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r RosterEntry
+		var owner GoogleID
+		var dn, vid, seen sql.NullString
+		if err := rows.Scan(&r.Gid, &r.Agent, &r.Squad, &dn, &vid, &r.Level, &r.Role, &owner, &r.Verified, &r.Blacklisted, &r.RocksVerified, &seen); err != nil {
+			Log.Error(err)
+			continue
+		}
+		r.DisplayName = dn.String
+		r.EnlID = EnlID(vid.String)
+		r.LastSeen = seen.String
+		if r.Gid == owner {
+			r.Role = TeamRoleOwner
+		}
+		roster = append(roster, r)
+	}
+	return roster, nil
+}
+
+// ReadRosterCSV parses a roster from CSV; the first line must be a header naming the columns
+func ReadRosterCSV(in io.Reader) ([]RosterEntry, error) {
+	var roster []RosterEntry
+
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return roster, fmt.Errorf("unable to read roster header: %s", err.Error())
+	}
+	cols := make(map[string]int)
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := cols["agent"]; !ok {
+		if _, ok := cols["gid"]; !ok {
+			return roster, fmt.Errorf("roster needs an agent or gid column")
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := cols[strings.ToLower(name)]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return roster, err
+		}
+		roster = append(roster, RosterEntry{
+			Agent:       field(record, "agent"),
+			Gid:         GoogleID(field(record, "gid")),
+			Squad:       field(record, "squad"),
+			DisplayName: field(record, "displayname"),
+		})
+	}
+	return roster, nil
+}
+
+// WriteRosterCSV writes a roster as CSV with a header line
+func WriteRosterCSV(out io.Writer, roster []RosterEntry) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(rosterColumns); err != nil {
+		return err
+	}
+	for _, r := range roster {
+		record := []string{
+			r.Agent,
+			string(r.Gid),
+			r.Squad,
+			r.DisplayName,
+			string(r.EnlID),
+			strconv.FormatInt(r.Level, 10),
+			string(r.Role),
+			strconv.FormatBool(r.Verified),
+			strconv.FormatBool(r.Blacklisted),
+			strconv.FormatBool(r.RocksVerified),
+			r.LastSeen,
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
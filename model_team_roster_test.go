@@ -0,0 +1,58 @@
+package wasabee_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestRoster(t *testing.T) {
+	teamID, err := gid.NewTeam("Roster Team")
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	in := "Agent, Squad, DisplayName\n" + string(gid) + ", operators, devvy\nnobody-by-this-name, ,\n"
+	roster, err := wasabee.ReadRosterCSV(strings.NewReader(in))
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(roster) != 2 || roster[0].Squad != "operators" {
+		t.Error("roster CSV not parsed")
+	}
+	if _, err := wasabee.ReadRosterCSV(strings.NewReader("squad\nfoo\n")); err == nil {
+		t.Error("roster without an agent column accepted")
+	}
+
+	// the owner is already on the team
+	result := teamID.ImportRoster(roster)
+	if result.Added != 0 || result.Present != 1 || len(result.Unmatched) != 1 || result.Unmatched[0].Row != 2 {
+		t.Errorf("roster import: %+v", result)
+	}
+
+	out, err := teamID.Roster()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(out) != 1 || out[0].Squad != "operators" || out[0].DisplayName != "devvy" || out[0].Role != wasabee.TeamRoleOwner {
+		t.Error("roster export does not match import")
+	}
+
+	var buf bytes.Buffer
+	if err := wasabee.WriteRosterCSV(&buf, out); err != nil {
+		t.Error(err.Error())
+	}
+	again, err := wasabee.ReadRosterCSV(&buf)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(again) != 1 || again[0].Gid != gid {
+		t.Error("exported roster does not read back")
+	}
+
+	if err := teamID.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
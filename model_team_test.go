@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/wasabee-project/Wasabee-Server"
 	"testing"
+	"time"
 )
 
 var tids []wasabee.TeamID
@@ -160,3 +161,271 @@ func TestTeammatesNear(t *testing.T) {
 		fmt.Printf("%s is %fkm away\n", v.Name, v.Distance)
 	}
 }
+
+func TestTeamRoles(t *testing.T) {
+	teamID, err := gid.NewTeam("Role Team")
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	role, err := gid.TeamRole(teamID)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if role != wasabee.TeamRoleOwner {
+		t.Errorf("creator has role %s, not owner", role)
+	}
+	if manages, _ := gid.CanManageTeam(teamID); !manages {
+		t.Error("owner cannot manage team")
+	}
+	if err := teamID.SetRole(gid, wasabee.TeamRoleObserver); err == nil {
+		t.Error("owner role changed")
+	}
+	if err := teamID.SetRole(gid, wasabee.TeamRole("wombat")); err == nil {
+		t.Error("invalid role accepted")
+	}
+
+	role, err = wasabee.GoogleID("0").TeamRole(teamID)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if role != "" {
+		t.Error("non-member has a team role")
+	}
+
+	var td wasabee.TeamData
+	if err := teamID.FetchTeam(&td); err != nil {
+		t.Error(err.Error())
+	}
+	for _, a := range td.Agent {
+		if a.Gid == gid && a.Role != wasabee.TeamRoleOwner {
+			t.Error("owner role not shown in team data")
+		}
+	}
+
+	if err := teamID.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestJoinApproval(t *testing.T) {
+	teamID, err := gid.NewTeam("Vetted Team")
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if err := teamID.SetJoinApproval(true); err != nil {
+		t.Error(err.Error())
+	}
+	required, err := teamID.JoinApproval()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if !required {
+		t.Error("join approval not set")
+	}
+
+	tok, err := teamID.GenerateJoinToken()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	// already on the team, no request is filed
+	if err := teamID.JoinToken(gid, tok); err != nil {
+		t.Error(err.Error())
+	}
+	requests, err := teamID.JoinRequests()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(requests) != 0 {
+		t.Error("join request filed for a team member")
+	}
+
+	if err := gid.ApproveJoinRequest("bogus"); err == nil {
+		t.Error("approved a non-existent join request")
+	}
+
+	if err := teamID.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestJoinLinks(t *testing.T) {
+	teamID, err := gid.NewTeam("Linked Team")
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	link, err := teamID.NewJoinLink(gid, time.Hour, 1, "recruits", false)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if _, err := teamID.NewJoinLink(gid, -time.Hour, 0, "", false); err == nil {
+		t.Error("join link with negative duration created")
+	}
+	if err := teamID.JoinToken(gid, link.Token); err != nil {
+		t.Error(err.Error())
+	}
+
+	links, err := teamID.JoinLinks()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(links) != 1 || links[0].Squad != "recruits" || links[0].MaxUses != 1 {
+		t.Error("join link not listed")
+	}
+	// the owner is already on the team, so the link was not used up
+	if links[0].Uses != 0 || len(links[0].Joined) != 0 {
+		t.Error("team member used up a join link")
+	}
+
+	if err := teamID.RevokeJoinLink(link.Token); err != nil {
+		t.Error(err.Error())
+	}
+	if err := teamID.JoinToken(gid, link.Token); err == nil {
+		t.Error("revoked join link accepted")
+	}
+
+	if err := teamID.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestJoinLinkDenied(t *testing.T) {
+	applicant := wasabee.GoogleID("104743827901423568948")
+	if err := (wasabee.AgentData{GoogleID: applicant, IngressName: "applicant", Level: 8}).Save(); err != nil {
+		t.Error(err.Error())
+	}
+
+	teamID, err := gid.NewTeam("Denied Team")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if err := teamID.SetJoinApproval(true); err != nil {
+		t.Error(err.Error())
+	}
+	link, err := teamID.NewJoinLink(gid, time.Hour, 1, "", false)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	uses := func() int {
+		links, err := teamID.JoinLinks()
+		if err != nil || len(links) != 1 {
+			t.Error("join link not listed")
+			return -1
+		}
+		return links[0].Uses
+	}
+
+	// the pending request holds the use, asking again does not take another
+	for i := 0; i < 2; i++ {
+		if err := teamID.JoinToken(applicant, link.Token); err != nil {
+			t.Error(err.Error())
+		}
+	}
+	if u := uses(); u != 1 {
+		t.Errorf("join request counted %d uses", u)
+	}
+
+	requests, err := teamID.JoinRequests()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(requests) != 1 {
+		t.Fatal("join request not filed")
+	}
+	if err := gid.DenyJoinRequest(requests[0].ID); err != nil {
+		t.Error(err.Error())
+	}
+	if u := uses(); u != 0 {
+		t.Error("denied applicant used up the join link")
+	}
+
+	if err := teamID.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestTeamPolicy(t *testing.T) {
+	teamID, err := gid.NewTeam("Policy Team")
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if err := teamID.SetPolicy(wasabee.TeamPolicy{MinLevel: 99}); err == nil {
+		t.Error("invalid minimum level accepted")
+	}
+	if err := teamID.SetPolicy(wasabee.TeamPolicy{MinLevel: 16, RefuseBlacklisted: true}); err != nil {
+		t.Error(err.Error())
+	}
+	p, err := teamID.Policy()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if p.MinLevel != 16 || !p.RefuseBlacklisted {
+		t.Error("team policy not stored")
+	}
+
+	// the owner is never suspended by the team's own policy
+	if inteam, _ := gid.AgentInTeam(teamID); !inteam {
+		t.Error("owner suspended by team policy")
+	}
+
+	if err := teamID.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestTeamParent(t *testing.T) {
+	parent, err := gid.NewTeam("Parent Team")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	child, err := gid.NewTeam("Child Team")
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if err := child.SetParent(gid, parent); err != nil {
+		t.Error(err.Error())
+	}
+	if err := parent.SetParent(gid, child); err == nil {
+		t.Error("team nested beneath its own sub-team")
+	}
+	if err := parent.SetParent(gid, parent); err == nil {
+		t.Error("team nested beneath itself")
+	}
+
+	var td wasabee.TeamData
+	if err := parent.FetchTeam(&td); err != nil {
+		t.Error(err.Error())
+	}
+	if len(td.Children) != 1 || td.Children[0] != child {
+		t.Error("sub-team not listed on parent")
+	}
+
+	if in, _ := gid.AgentInTeamTree(parent); !in {
+		t.Error("agent not in parent team tree")
+	}
+
+	if err := child.SetParent(gid, ""); err != nil {
+		t.Error(err.Error())
+	}
+	if p, _ := child.Parent(); p != "" {
+		t.Errorf("parent not cleared: %s", p)
+	}
+
+	if err := child.SetParent(gid, parent); err != nil {
+		t.Error(err.Error())
+	}
+	if err := parent.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+	if p, _ := child.Parent(); p != "" {
+		t.Errorf("sub-team still nested beneath deleted team: %s", p)
+	}
+	if err := child.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
@@ -0,0 +1,191 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// teamtree holds every ancestor/descendant pair of nested teams so that permissions granted to a team
+// can reach the members of its sub-teams in a single query; the team's own parent column is authoritative.
+// Every team also has a row pairing it with itself, which setParent leaves in place.
+
+// Parent returns the team's parent team, empty if it has none
+func (teamID TeamID) Parent() (TeamID, error) {
+	var parent sql.NullString
+	if err := db.QueryRow("SELECT parent FROM team WHERE teamID = ?", teamID).Scan(&parent); err != nil {
+		Log.Error(err)
+		return "", err
+	}
+	return TeamID(parent.String), nil
+}
+
+// Children lists the teams directly beneath this one
+func (teamID TeamID) Children() ([]TeamID, error) {
+	children := make([]TeamID, 0)
+
+	rows, err := db.Query("SELECT teamID FROM team WHERE parent = ? ORDER BY name", teamID)
+	if err != nil {
+		Log.Error(err)
+		return children, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var child TeamID
+		if err := rows.Scan(&child); err != nil {
+			Log.Error(err)
+			continue
+		}
+		children = append(children, child)
+	}
+	return children, nil
+}
+
+// SetParent nests the team beneath another, or removes it from its parent if parent is empty.
+// The agent must own the team and own (directly or through nesting) the new parent, since members
+// of the team gain the parent's op permissions and the parent's owners gain control of the team.
+func (teamID TeamID) SetParent(gid GoogleID, parent TeamID) error {
+	if owns, _ := gid.OwnsTeam(teamID); !owns {
+		// owners of the current parent may release the team
+		if current, _ := teamID.Parent(); parent != "" || current == "" || !gid.ownsTeamTree(current) {
+			err := fmt.Errorf("permission denied")
+			Log.Warnw(err.Error(), "GID", gid, "resource", teamID)
+			return err
+		}
+	}
+
+	if parent != "" {
+		if !gid.ownsTeamTree(parent) {
+			err := fmt.Errorf("permission denied: not owner of parent team")
+			Log.Warnw(err.Error(), "GID", gid, "resource", teamID, "parent", parent)
+			return err
+		}
+		if parent == teamID || parent.descendsFrom(teamID) {
+			err := fmt.Errorf("a team cannot be nested beneath itself")
+			Log.Warnw(err.Error(), "GID", gid, "resource", teamID, "parent", parent)
+			return err
+		}
+	}
+
+	if err := teamID.setParent(parent); err != nil {
+		return err
+	}
+	Log.Infow("team parent set", "GID", gid, "resource", teamID, "parent", parent)
+	return nil
+}
+
+// setParent moves the team and its sub-teams beneath a new parent, no authorization or cycle checks
+// the parent column and the closure rows change together, or not at all
+func (teamID TeamID) setParent(parent TeamID) error {
+	tx, err := db.Begin()
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && err != sql.ErrTxDone {
+			Log.Error(err)
+		}
+	}()
+
+	subtree, err := teamID.related(tx, "SELECT descendant FROM teamtree WHERE ancestor = ? AND descendant != ancestor")
+	if err != nil {
+		return err
+	}
+	subtree = append(subtree, teamID)
+
+	// detach the subtree from its old ancestors
+	oldAncestors, err := teamID.related(tx, "SELECT ancestor FROM teamtree WHERE descendant = ? AND ancestor != descendant")
+	if err != nil {
+		return err
+	}
+	for _, a := range oldAncestors {
+		for _, d := range subtree {
+			if _, err := tx.Exec("DELETE FROM teamtree WHERE ancestor = ? AND descendant = ?", a, d); err != nil {
+				Log.Error(err)
+				return err
+			}
+		}
+	}
+
+	// attach it to the new ones
+	if parent != "" {
+		newAncestors, err := parent.related(tx, "SELECT ancestor FROM teamtree WHERE descendant = ? AND ancestor != descendant")
+		if err != nil {
+			return err
+		}
+		newAncestors = append(newAncestors, parent)
+		for _, a := range newAncestors {
+			for _, d := range subtree {
+				if _, err := tx.Exec("INSERT IGNORE INTO teamtree (ancestor, descendant) VALUES (?, ?)", a, d); err != nil {
+					Log.Error(err)
+					return err
+				}
+			}
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE team SET parent = ? WHERE teamID = ?", MakeNullString(string(parent)), teamID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+func (teamID TeamID) related(tx *sql.Tx, query string) ([]TeamID, error) {
+	var list []TeamID
+
+	rows, err := tx.Query(query, teamID)
+	if err != nil {
+		Log.Error(err)
+		return list, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t TeamID
+		if err := rows.Scan(&t); err != nil {
+			Log.Error(err)
+			continue
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+// descendsFrom reports whether the team is nested, at any depth, beneath ancestor
+func (teamID TeamID) descendsFrom(ancestor TeamID) bool {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM teamtree WHERE ancestor = ? AND descendant = ?", ancestor, teamID).Scan(&count); err != nil {
+		Log.Error(err)
+		return false
+	}
+	return count > 0
+}
+
+// ownsTeamTree reports whether the agent owns the team or any team it is nested beneath
+func (gid GoogleID) ownsTeamTree(teamID TeamID) bool {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM team WHERE owner = ? AND (teamID = ? OR teamID IN (SELECT ancestor FROM teamtree WHERE descendant = ?))", gid, teamID, teamID).Scan(&count)
+	if err != nil {
+		Log.Error(err)
+		return false
+	}
+	return count > 0
+}
+
+// AgentInTeamTree checks to see if an agent is in a team or any of its sub-teams, for op permissions granted to the team
+func (gid GoogleID) AgentInTeamTree(teamID TeamID) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM agentteams WHERE gid = ? AND suspended = 0 AND (teamID = ? OR teamID IN (SELECT descendant FROM teamtree WHERE ancestor = ?))", gid, teamID, teamID).Scan(&count)
+	if err != nil {
+		Log.Error(err)
+		return false, err
+	}
+	return count > 0, nil
+}
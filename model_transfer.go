@@ -0,0 +1,282 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// transferWindow is how long the recipient has to accept an ownership transfer
+var transferWindow = 48 * time.Hour
+
+const (
+	transferOp   = "op"
+	transferTeam = "team"
+)
+
+// PendingTransfer is an ownership change awaiting acceptance by the recipient
+type PendingTransfer struct {
+	Kind    string   `json:"kind"`
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	From    GoogleID `json:"from"`
+	To      GoogleID `json:"to"`
+	Expires string   `json:"expires"`
+}
+
+// Chown offers an operation to another agent, who must accept before ownership changes
+func (opID OperationID) Chown(gid GoogleID, to string) error {
+	if !opID.IsPrimaryOwner(gid) {
+		err := fmt.Errorf("permission denied: not current owner")
+		Log.Warnw(err.Error(), "GID", gid, "resource", opID)
+		return err
+	}
+
+	s, err := opID.Stat()
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	return offerTransfer(transferOp, string(opID), s.Name, gid, to)
+}
+
+// AcceptChown completes a pending operation transfer, only the recipient may accept
+func (opID OperationID) AcceptChown(gid GoogleID) error {
+	// the new owner no longer needs to be listed as a co-owner
+	return acceptTransfer(transferOp, string(opID), gid, "UPDATE operation SET gid = ? WHERE ID = ? AND gid = ?", func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM opcoowners WHERE opID = ? AND gid = ?", opID, gid)
+		return err
+	})
+}
+
+// CancelChown withdraws (owner) or declines (recipient) a pending operation transfer
+func (opID OperationID) CancelChown(gid GoogleID) error {
+	return cancelTransfer(transferOp, string(opID), gid)
+}
+
+// PendingChown returns the pending transfer for an operation, if any
+func (opID OperationID) PendingChown() (*PendingTransfer, error) {
+	return pendingTransfer(transferOp, string(opID))
+}
+
+// Chown offers a team to another agent, who must accept before ownership changes
+// caller must verify permissions
+func (teamID TeamID) Chown(to AgentID) error {
+	togid, err := to.Gid()
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	var owner GoogleID
+	var name sql.NullString
+	if err := db.QueryRow("SELECT owner, name FROM team WHERE teamID = ?", teamID).Scan(&owner, &name); err != nil {
+		Log.Error(err)
+		return err
+	}
+	return offerTransfer(transferTeam, string(teamID), name.String, owner, string(togid))
+}
+
+// AcceptChown completes a pending team transfer, only the recipient may accept
+func (teamID TeamID) AcceptChown(gid GoogleID) error {
+	return acceptTransfer(transferTeam, string(teamID), gid, "UPDATE team SET owner = ? WHERE teamID = ? AND owner = ?", nil)
+}
+
+// CancelChown withdraws (owner) or declines (recipient) a pending team transfer
+func (teamID TeamID) CancelChown(gid GoogleID) error {
+	return cancelTransfer(transferTeam, string(teamID), gid)
+}
+
+// PendingChown returns the pending transfer for a team, if any
+func (teamID TeamID) PendingChown() (*PendingTransfer, error) {
+	return pendingTransfer(transferTeam, string(teamID))
+}
+
+// PendingTransfers lists the unexpired transfers offered to or by an agent
+func (gid GoogleID) PendingTransfers() ([]PendingTransfer, error) {
+	transfers := make([]PendingTransfer, 0)
+
+	rows, err := db.Query("SELECT kind, resource, name, fromgid, togid, expires FROM transfer WHERE (fromgid = ? OR togid = ?) AND expires > UTC_TIMESTAMP() ORDER BY expires", gid, gid)
+	if err != nil {
+		Log.Error(err)
+		return transfers, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t PendingTransfer
+		if err := rows.Scan(&t.Kind, &t.ID, &t.Name, &t.From, &t.To, &t.Expires); err != nil {
+			Log.Error(err)
+			continue
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, nil
+}
+
+func offerTransfer(kind, resource, name string, from GoogleID, to string) error {
+	togid, err := ToGid(to)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	iname, err := togid.IngressName()
+	if iname == "" || err != nil {
+		err := fmt.Errorf("unknown user")
+		Log.Warnw(err.Error(), "GID", from, "resource", resource, "to", to)
+		return err
+	}
+
+	if togid == from {
+		err := fmt.Errorf("already the owner")
+		Log.Warnw(err.Error(), "GID", from, "resource", resource)
+		return err
+	}
+
+	expires := time.Now().UTC().Add(transferWindow).Format("2006-01-02 15:04:05")
+	_, err = db.Exec("REPLACE INTO transfer (kind, resource, name, fromgid, togid, expires) VALUES (?, ?, ?, ?, ?, ?)",
+		kind, resource, name, from, togid, expires)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	fromName, _ := from.IngressName()
+	msg := fmt.Sprintf("%s would like to transfer ownership of the %s \"%s\" to you. Accept before %s UTC or the offer expires.", fromName, kind, name, expires)
+	if _, err := togid.SendMessage(msg); err != nil {
+		Log.Error(err)
+	}
+	togid.FirebaseGenericMessage(msg)
+
+	Log.Infow("ownership transfer offered", "GID", from, "resource", resource, "kind", kind, "to", togid)
+	return nil
+}
+
+// acceptTransfer changes the owner with update, which takes the new owner, the resource and the offering owner.
+// then, if set, runs in the same transaction after the owner has changed.
+func acceptTransfer(kind, resource string, gid GoogleID, update string, then func(*sql.Tx) error) error {
+	t, err := pendingTransfer(kind, resource)
+	if err != nil {
+		return err
+	}
+	if t == nil || t.To != gid {
+		err := fmt.Errorf("no pending transfer")
+		Log.Warnw(err.Error(), "GID", gid, "resource", resource, "kind", kind)
+		return err
+	}
+
+	// the owner change and the end of the offer happen together
+	tx, err := db.Begin()
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && err != sql.ErrTxDone {
+			Log.Error(err)
+		}
+	}()
+
+	result, err := tx.Exec("DELETE FROM transfer WHERE kind = ? AND resource = ? AND togid = ?", kind, resource, gid)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if ra, _ := result.RowsAffected(); ra != 1 {
+		err := fmt.Errorf("no pending transfer")
+		Log.Warnw(err.Error(), "GID", gid, "resource", resource, "kind", kind)
+		return err
+	}
+
+	// the offer lapses if the agent who made it is no longer the owner
+	result, err = tx.Exec(update, gid, resource, t.From)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if ra, _ := result.RowsAffected(); ra != 1 {
+		err := fmt.Errorf("the agent who offered the transfer no longer owns the %s", kind)
+		Log.Warnw(err.Error(), "GID", gid, "resource", resource, "kind", kind, "from", t.From)
+		// drop the stale offer
+		if err := tx.Commit(); err != nil {
+			Log.Error(err)
+		}
+		return err
+	}
+
+	if then != nil {
+		if err := then(tx); err != nil {
+			Log.Error(err)
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	name, _ := gid.IngressName()
+	msg := fmt.Sprintf("%s accepted ownership of the %s \"%s\"", name, kind, t.Name)
+	if _, err := t.From.SendMessage(msg); err != nil {
+		Log.Error(err)
+	}
+	t.From.FirebaseGenericMessage(msg)
+
+	Log.Infow("ownership transfer accepted", "GID", gid, "resource", resource, "kind", kind, "from", t.From)
+	return nil
+}
+
+func cancelTransfer(kind, resource string, gid GoogleID) error {
+	t, err := pendingTransfer(kind, resource)
+	if err != nil {
+		return err
+	}
+	if t == nil || (t.From != gid && t.To != gid) {
+		err := fmt.Errorf("no pending transfer")
+		Log.Warnw(err.Error(), "GID", gid, "resource", resource, "kind", kind)
+		return err
+	}
+
+	if _, err := db.Exec("DELETE FROM transfer WHERE kind = ? AND resource = ?", kind, resource); err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	// let the other party know
+	other := t.To
+	if gid == t.To {
+		other = t.From
+	}
+	msg := fmt.Sprintf("the transfer of the %s \"%s\" has been cancelled", kind, t.Name)
+	if _, err := other.SendMessage(msg); err != nil {
+		Log.Error(err)
+	}
+	other.FirebaseGenericMessage(msg)
+
+	Log.Infow("ownership transfer cancelled", "GID", gid, "resource", resource, "kind", kind)
+	return nil
+}
+
+func pendingTransfer(kind, resource string) (*PendingTransfer, error) {
+	t := PendingTransfer{
+		Kind: kind,
+		ID:   resource,
+	}
+	err := db.QueryRow("SELECT name, fromgid, togid, expires FROM transfer WHERE kind = ? AND resource = ? AND expires > UTC_TIMESTAMP()", kind, resource).Scan(&t.Name, &t.From, &t.To, &t.Expires)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		Log.Error(err)
+		return nil, err
+	}
+	return &t, nil
+}
+
+func transferClean() {
+	if _, err := db.Exec("DELETE FROM transfer WHERE expires < UTC_TIMESTAMP()"); err != nil {
+		Log.Error(err)
+	}
+}
@@ -0,0 +1,92 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestOpChown(t *testing.T) {
+	other := wasabee.GoogleID("104743827901423568948")
+	if err := (wasabee.AgentData{GoogleID: other, IngressName: "chowntarget", Level: 8}).Save(); err != nil {
+		t.Error(err.Error())
+	}
+
+	content, err := ioutil.ReadFile("testdata/test3.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+	var in wasabee.Operation
+	if err := json.Unmarshal(j, &in); err != nil {
+		t.Error(err.Error())
+	}
+
+	if err := in.ID.Chown(other, string(gid)); err == nil {
+		t.Error("non-owner offered the op")
+	}
+	if err := in.ID.Chown(gid, string(gid)); err == nil {
+		t.Error("op offered to its owner")
+	}
+
+	// declined by the recipient
+	if err := in.ID.Chown(gid, string(other)); err != nil {
+		t.Error(err.Error())
+	}
+	p, err := in.ID.PendingChown()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if p == nil || p.To != other || p.From != gid {
+		t.Error("pending transfer not recorded")
+	}
+	if err := in.ID.AcceptChown(gid); err == nil {
+		t.Error("transfer accepted by the offering agent")
+	}
+	if err := in.ID.CancelChown(other); err != nil {
+		t.Error(err.Error())
+	}
+	if p, _ := in.ID.PendingChown(); p != nil {
+		t.Error("cancelled transfer still pending")
+	}
+	if err := in.ID.AcceptChown(other); err == nil {
+		t.Error("cancelled transfer accepted")
+	}
+
+	// accepted
+	if err := in.ID.Chown(gid, string(other)); err != nil {
+		t.Error(err.Error())
+	}
+	if err := in.ID.AcceptChown(other); err != nil {
+		t.Error(err.Error())
+	}
+	if !in.ID.IsPrimaryOwner(other) || in.ID.IsPrimaryOwner(gid) {
+		t.Error("ownership not transferred")
+	}
+
+	// expired
+	window := wasabee.SetTransferWindow(-time.Minute)
+	if err := in.ID.Chown(other, string(gid)); err != nil {
+		t.Error(err.Error())
+	}
+	wasabee.SetTransferWindow(window)
+	if p, _ := in.ID.PendingChown(); p != nil {
+		t.Error("expired transfer still pending")
+	}
+	if err := in.ID.AcceptChown(gid); err == nil {
+		t.Error("expired transfer accepted")
+	}
+
+	if err := in.Delete(other); err != nil {
+		t.Error(err.Error())
+	}
+	if err := other.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
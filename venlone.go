@@ -212,9 +212,10 @@ func (teamID TeamID) VPullTeam(gid GoogleID, vteamid string, vapikey string) err
 			Log.Error(err)
 			return err
 		}
+		// agents refused by the team's policy are skipped, not fatal to the pull
 		if err = teamID.AddAgent(agent.Gid); err != nil {
-			Log.Error(err)
-			return err
+			Log.Info(err)
+			continue
 		}
 	}
 
@@ -78,8 +78,8 @@ func defaultZones() []ZoneListElement {
 	return zones
 }
 
-func (o *Operation) insertZone(z ZoneListElement) error {
-	_, err := db.Exec("INSERT INTO zone (ID, opID, name) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE name = ?", z.Zone, o.ID, z.Name, z.Name)
+func (o *Operation) insertZone(ex execer, z ZoneListElement) error {
+	_, err := ex.Exec("INSERT INTO zone (ID, opID, name) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE name = ?", z.Zone, o.ID, z.Name, z.Name)
 	if err != nil {
 		Log.Error(err)
 		return err